@@ -18,6 +18,13 @@ type (
 		Namespace         string `json:"namespace"`
 		Name              string `json:"name"`
 		Url               string `json:"url"`
+		ScmRepoId         string `json:"scm_repo_id"` // stable ID in a repository manager
+
+		// metadata synced from a repository manager
+		DefaultBranch string `json:"default_branch"`
+		Visibility    string `json:"visibility"` // "public", "internal", "private" or "" if unknown
+		Archived      bool   `json:"archived"`
+		Description   string `json:"description"`
 	}
 )
 
@@ -28,6 +35,7 @@ type (
 const (
 	contextRepoKey              contextKey = iota
 	contextRepositoryClientKey  contextKey = iota
+	contextSettingsKey          contextKey = iota
 )
 
 func WithRepositoryClient(ctx context.Context, client RepositoryClient) context.Context {
@@ -0,0 +1,198 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+type (
+	// Component is a part of a repository such as a service in a monorepo.
+	// A file belongs to a component when the file matches any of Paths.
+	Component struct {
+		Name  string   `json:"name"`
+		Paths []string `json:"paths"` // globs such as services/payments/**
+	}
+
+	// CoverageThresholds are percentages of coverage. Zero means unset.
+	CoverageThresholds struct {
+		Minimum float64 `json:"minimum"`
+		Target  float64 `json:"target"`
+	}
+
+	NotificationTarget struct {
+		Type   string `json:"type"`   // "webhook", "slack" or "email"
+		Target string `json:"target"` // URL or email address
+	}
+
+	RepositorySettings struct {
+		DefaultBranch string                `json:"default_branch"`
+		Thresholds    CoverageThresholds    `json:"thresholds"`
+		Ignore        []string              `json:"ignore"` // globs of files excluded from coverage
+		Components    []*Component          `json:"components"`
+		Notifications []*NotificationTarget `json:"notifications"`
+
+		// WebhookSecret verifies webhooks of a repository manager which
+		// fetch the mirror. Webhooks are rejected when it is empty.
+		WebhookSecret string `json:"webhook_secret,omitempty"`
+	}
+)
+
+// NewRepositorySettings returns settings of a repository not configured yet.
+func NewRepositorySettings() *RepositorySettings {
+	return &RepositorySettings{
+		Ignore:        []string{},
+		Components:    []*Component{},
+		Notifications: []*NotificationTarget{},
+	}
+}
+
+// MatchGlob reports whether name matches pattern. In addition to the syntax
+// of path.Match, `**` matches zero or more directories.
+func MatchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(name) == 0 {
+		return false
+	}
+
+	if ok, _ := path.Match(pattern[0], name[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], name[1:])
+}
+
+func validateGlob(pattern string) error {
+	if pattern == "" {
+		return errors.New("empty path")
+	}
+	for _, seg := range strings.Split(pattern, "/") {
+		if _, err := path.Match(seg, ""); err != nil {
+			return fmt.Errorf("invalid path %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func (c *Component) Match(filename string) bool {
+	for _, p := range c.Paths {
+		if MatchGlob(p, filename) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Component) Validate() error {
+	if c.Name == "" {
+		return errors.New("component name is empty")
+	}
+	if strings.Contains(c.Name, "/") {
+		return fmt.Errorf("component name %s includes a slash", c.Name)
+	}
+	if len(c.Paths) == 0 {
+		return fmt.Errorf("component %s has no paths", c.Name)
+	}
+	for _, p := range c.Paths {
+		if err := validateGlob(p); err != nil {
+			return fmt.Errorf("component %s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+func validateComponents(components []*Component) error {
+	names := map[string]bool{}
+	for _, c := range components {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		if names[c.Name] {
+			return fmt.Errorf("duplicated component name %s", c.Name)
+		}
+		names[c.Name] = true
+	}
+	return nil
+}
+
+func (t CoverageThresholds) Validate() error {
+	if t.Minimum < 0 || t.Minimum > 100 || t.Target < 0 || t.Target > 100 {
+		return errors.New("threshold has to be between 0 and 100")
+	}
+	if t.Minimum > 0 && t.Target > 0 && t.Minimum > t.Target {
+		return errors.New("minimum threshold is greater than target")
+	}
+	return nil
+}
+
+func (n *NotificationTarget) Validate() error {
+	switch n.Type {
+	case "webhook", "slack", "email":
+	default:
+		return fmt.Errorf("unknown notification type: %q", n.Type)
+	}
+	if n.Target == "" {
+		return fmt.Errorf("%s notification has no target", n.Type)
+	}
+	return nil
+}
+
+func (s *RepositorySettings) Validate() error {
+	if err := s.Thresholds.Validate(); err != nil {
+		return err
+	}
+
+	for _, p := range s.Ignore {
+		if err := validateGlob(p); err != nil {
+			return fmt.Errorf("ignore: %w", err)
+		}
+	}
+
+	if err := validateComponents(s.Components); err != nil {
+		return err
+	}
+
+	for _, n := range s.Notifications {
+		if err := n.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Ignored reports whether a file is excluded from coverage.
+func (s *RepositorySettings) Ignored(filename string) bool {
+	for _, p := range s.Ignore {
+		if MatchGlob(p, filename) {
+			return true
+		}
+	}
+	return false
+}
+
+func WithSettings(ctx context.Context, settings *RepositorySettings) context.Context {
+	return context.WithValue(ctx, contextSettingsKey, settings)
+}
+
+func SettingsFrom(ctx context.Context) (*RepositorySettings, bool) {
+	settings, ok := ctx.Value(contextSettingsKey).(*RepositorySettings)
+	return settings, ok
+}
@@ -0,0 +1,81 @@
+package base
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"services/payments/**", "services/payments/api/server.go", true},
+		{"services/payments/**", "services/payments/main.go", true},
+		{"services/payments/**", "services/billing/main.go", false},
+		{"services/*/main.go", "services/payments/main.go", true},
+		{"services/*/main.go", "services/payments/cmd/main.go", false},
+		{"**/*_gen.go", "api_gen.go", true},
+		{"**/*_gen.go", "services/payments/api_gen.go", true},
+		{"services/**/api/*.go", "services/payments/v1/api/server.go", true},
+		{"main.go", "main.go", true},
+		{"main.go", "cmd/main.go", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, MatchGlob(tt.pattern, tt.name), "%s %s", tt.pattern, tt.name)
+	}
+}
+
+func Test_validateComponents(t *testing.T) {
+	valid := []*Component{
+		{Name: "payments", Paths: []string{"services/payments/**"}},
+		{Name: "billing", Paths: []string{"services/billing/**", "lib/billing/*.go"}},
+	}
+	assert.NoError(t, validateComponents(valid))
+
+	invalids := [][]*Component{
+		{{Name: "", Paths: []string{"a/**"}}},
+		{{Name: "a/b", Paths: []string{"a/**"}}},
+		{{Name: "a", Paths: []string{}}},
+		{{Name: "a", Paths: []string{""}}},
+		{{Name: "a", Paths: []string{"a/[/**"}}},
+		{{Name: "a", Paths: []string{"a/**"}}, {Name: "a", Paths: []string{"b/**"}}},
+	}
+	for _, components := range invalids {
+		assert.Error(t, validateComponents(components))
+	}
+}
+
+func TestRepositorySettings_Validate(t *testing.T) {
+	valid := &RepositorySettings{
+		DefaultBranch: "main",
+		Thresholds:    CoverageThresholds{Minimum: 60, Target: 80},
+		Ignore:        []string{"**/*_gen.go"},
+		Components:    []*Component{{Name: "payments", Paths: []string{"services/payments/**"}}},
+		Notifications: []*NotificationTarget{{Type: "slack", Target: "https://hooks.slack.com/x"}},
+	}
+	assert.NoError(t, valid.Validate())
+	assert.NoError(t, NewRepositorySettings().Validate())
+
+	invalids := []*RepositorySettings{
+		{Thresholds: CoverageThresholds{Minimum: 101}},
+		{Thresholds: CoverageThresholds{Minimum: 80, Target: 60}},
+		{Ignore: []string{"a/[/**"}},
+		{Components: []*Component{{Name: "a"}}},
+		{Notifications: []*NotificationTarget{{Type: "fax", Target: "123"}}},
+		{Notifications: []*NotificationTarget{{Type: "webhook"}}},
+	}
+	for _, settings := range invalids {
+		assert.Error(t, settings.Validate())
+	}
+}
+
+func TestRepositorySettings_Ignored(t *testing.T) {
+	settings := &RepositorySettings{Ignore: []string{"**/*_gen.go", "vendor/**"}}
+	assert.True(t, settings.Ignored("api/server_gen.go"))
+	assert.True(t, settings.Ignored("vendor/github.com/x/y.go"))
+	assert.False(t, settings.Ignored("api/server.go"))
+}
@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iszk1215/mora/mora/coverage"
+	"github.com/iszk1215/mora/mora/server"
+	"github.com/iszk1215/mora/mora/udm"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// adminCmd represents the admin command
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "Maintenance commands working on the database directly",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		log.Logger = log.Output(
+			zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339}).With().Caller().Logger()
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	},
+}
+
+var dedupeCmd = &cobra.Command{
+	Use:   "dedupe",
+	Short: "Deduplicate coverage blocks and report the space reclaimed",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, db, err := openDatabase(cmd)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		// Init migrates blocks to the deduplicated format
+		err = coverage.NewCoverageStore(db).Init()
+		if err != nil {
+			return err
+		}
+
+		report, err := coverage.Compact(db)
+		if err != nil {
+			return err
+		}
+
+		stats := report.Stats
+		fmt.Printf("%-20s%s\n", "Database", config.DatabaseFilename)
+		fmt.Printf("%-20s%d\n", "Files", stats.Files)
+		fmt.Printf("%-20s%d\n", "Blocks", stats.Blocks)
+		fmt.Printf("%-20s%s (%s without deduplication)\n", "Blocks size",
+			formatBytes(stats.StoredBytes), formatBytes(stats.LogicalBytes))
+		fmt.Printf("%-20s%s\n", "Reclaimed", formatBytes(stats.Reclaimed()))
+		fmt.Printf("%-20s%d\n", "Removed blocks", report.RemovedBlocks)
+		fmt.Printf("%-20s%s -> %s\n", "Database size",
+			formatBytes(report.SizeBefore), formatBytes(report.SizeAfter))
+
+		return nil
+	},
+}
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Prune coverages according to the retention policy",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		config, db, err := openDatabase(cmd)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		policy, err := config.Retention.Policy()
+		if err != nil {
+			return err
+		}
+
+		if !policy.Enabled() {
+			return errors.New("retention policy is not configured")
+		}
+
+		store := coverage.NewCoverageStore(db)
+		err = store.Init()
+		if err != nil {
+			return err
+		}
+
+		pruned, err := coverage.Prune(store, policy, time.Now(), dryRun)
+		if err != nil {
+			return err
+		}
+
+		for _, cov := range pruned {
+			fmt.Printf("%-8d%-44s%s\n", cov.RepoID, cov.Revision, cov.Timestamp)
+		}
+
+		if dryRun {
+			fmt.Printf("%d coverages will be pruned\n", len(pruned))
+		} else {
+			fmt.Printf("%d coverages are pruned\n", len(pruned))
+		}
+
+		return nil
+	},
+}
+
+var repoCmd = &cobra.Command{
+	Use:   "repo",
+	Short: "Manage repositories",
+}
+
+var repoAddCmd = &cobra.Command{
+	Use:   "add <url>...",
+	Short: "Register repositories such as https://github.com/owner/repo",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, db, err := openDatabase(cmd)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		for _, url := range args {
+			repo, err := server.AddRepository(config, db, url)
+			if err != nil {
+				return fmt.Errorf("%s: %w", url, err)
+			}
+			fmt.Printf("%-8d%s\n", repo.Id, repo.Url)
+		}
+
+		return nil
+	},
+}
+
+var repoRmCmd = &cobra.Command{
+	Use:   "rm <id or url>...",
+	Short: "Remove repositories with their coverages and metrics",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		yes, _ := cmd.Flags().GetBool("yes")
+
+		config, db, err := openDatabase(cmd)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		// create tables so that a database without some of them can be used
+		repos := server.NewRepositoryStore(db)
+		inits := []func() error{
+			repos.Init,
+			server.NewRepositorySettingsStore(db).Init,
+			coverage.NewCoverageStore(db).Init,
+			func() error { _, err := udm.NewService(db); return err },
+		}
+		for _, init := range inits {
+			if err := init(); err != nil {
+				return err
+			}
+		}
+
+		for _, arg := range args {
+			var repo server.Repository
+			if id, err := strconv.ParseInt(arg, 10, 64); err == nil {
+				repo, err = repos.Find(id)
+				if err != nil {
+					return fmt.Errorf("%s: %w", arg, err)
+				}
+			} else {
+				repo, err = repos.FindURL(arg)
+				if err != nil {
+					return fmt.Errorf("%s: %w", arg, err)
+				}
+			}
+
+			if !yes && !confirm(fmt.Sprintf("Remove %s (id=%d) and all its data?", repo.Url, repo.Id)) {
+				fmt.Println("Skipped")
+				continue
+			}
+
+			err = server.DeleteRepository(db, repo.Id)
+			if err != nil {
+				return err
+			}
+			err = server.RemoveRepositoryFiles(config, repo)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%-8d%s removed\n", repo.Id, repo.Url)
+		}
+
+		return nil
+	},
+}
+
+func confirm(msg string) bool {
+	fmt.Printf("%s [y/N] ", msg)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func openDatabase(cmd *cobra.Command) (server.MoraConfig, *sqlx.DB, error) {
+	configFile, _ := cmd.Flags().GetString("config")
+
+	config, err := server.ReadMoraConfig(configFile)
+	if err != nil {
+		return server.MoraConfig{}, nil, err
+	}
+
+	db, err := sqlx.Connect("sqlite3", config.DatabaseFilename)
+	if err != nil {
+		return server.MoraConfig{}, nil, err
+	}
+
+	return config, db, nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func init() {
+	rootCmd.AddCommand(adminCmd)
+	adminCmd.AddCommand(dedupeCmd)
+	adminCmd.AddCommand(pruneCmd)
+	adminCmd.AddCommand(repoCmd)
+	repoCmd.AddCommand(repoAddCmd)
+	repoCmd.AddCommand(repoRmCmd)
+
+	adminCmd.PersistentFlags().StringP("config", "c", "mora.conf", "Config filename")
+
+	pruneCmd.Flags().Bool("dry-run", false, "show coverages to be pruned without removing them")
+	repoRmCmd.Flags().BoolP("yes", "y", false, "remove without confirmation")
+}
@@ -38,10 +38,16 @@ to quickly create a Cobra application.`,
 		repoPath, _ := cmd.Flags().GetString("repo-path")
 		force, _ := cmd.Flags().GetBool("force")
 		entryName, _ := cmd.Flags().GetString("entry")
+		entryTags, _ := cmd.Flags().GetStringToString("entry-tag")
 		dryRun, _ := cmd.Flags().GetBool("dry-run")
 		yes, _ := cmd.Flags().GetBool("yes")
 
-		return coverage.Upload(server, repoURL, repoPath, entryName, dryRun, force, yes, args)
+		refs := coverage.RevisionRefs{}
+		refs.Branch, _ = cmd.Flags().GetString("branch")
+		refs.Tag, _ = cmd.Flags().GetString("tag")
+		refs.PullRequest, _ = cmd.Flags().GetInt("pr")
+
+		return coverage.Upload(server, repoURL, repoPath, entryName, entryTags, refs, dryRun, force, yes, args)
 	},
 }
 
@@ -62,6 +68,10 @@ func init() {
 	uploadCmd.Flags().String("repo-path", "", "path of repositry")
 	uploadCmd.Flags().String("repo", "", "URL")
 	uploadCmd.Flags().String("entry", "_default", "entry name")
+	uploadCmd.Flags().StringToString("entry-tag", nil, "entry tags such as os=linux,suite=unit")
+	uploadCmd.Flags().String("branch", "", "branch name (default: detected from git and CI)")
+	uploadCmd.Flags().String("tag", "", "tag name (default: detected from git and CI)")
+	uploadCmd.Flags().Int("pr", 0, "pull request number (default: detected from CI)")
 	uploadCmd.Flags().BoolP("force", "f", false, "force upload even when working tree is dirty")
 	uploadCmd.Flags().Bool("dry-run", false, "test")
 	uploadCmd.Flags().BoolP("yes", "y", false, "yes")
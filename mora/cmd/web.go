@@ -5,6 +5,7 @@ Copyright © 2022 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
 	"net/http"
 	"os"
 	"strconv"
@@ -52,6 +53,7 @@ to quickly create a Cobra application.`,
 		}
 
 		handler := server.Handler()
+		server.RunBackgroundJobs(context.Background())
 
 		log.Info().Msg("Started")
 		err = http.ListenAndServe(":"+strconv.Itoa(config.Server.Port), handler)
@@ -0,0 +1,117 @@
+package coverage
+
+import (
+	"sort"
+
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/rs/zerolog/log"
+)
+
+// olderThan returns true if a is older than b. Coverages with the same
+// timestamp are ordered by ID, i.e. in order of upload.
+func olderThan(a, b *Coverage) bool {
+	if a.Timestamp.Equal(b.Timestamp) {
+		return a.ID < b.ID
+	}
+	return a.Timestamp.Before(b.Timestamp)
+}
+
+func sortByTimestamp(coverages []*Coverage) {
+	sort.SliceStable(coverages, func(i, j int) bool {
+		return olderThan(coverages[i], coverages[j])
+	})
+}
+
+// sortCoverages sorts coverages from old to new. When a repository is
+// mirrored, coverages are sorted in a topological order of the commit graph,
+// and parallel revisions are sorted by timestamp. Otherwise, or when a
+// revision is not in the mirror, all coverages are sorted by timestamp.
+func (s *CoverageHandler) sortCoverages(repo base.Repository, coverages []*Coverage) {
+	sortByTimestamp(coverages)
+
+	if s.mirror == nil || !s.mirror.Has(repo) {
+		return
+	}
+
+	revisions := []string{}
+	for _, cov := range coverages {
+		revisions = append(revisions, cov.Revision)
+	}
+
+	gens, err := s.mirror.Generations(repo, revisions)
+	if err != nil {
+		log.Warn().Err(err).Msg("sortCoverages: sorted by timestamp")
+		return
+	}
+
+	sort.SliceStable(coverages, func(i, j int) bool {
+		return gens[coverages[i].Revision] < gens[coverages[j].Revision]
+	})
+}
+
+// findNearestAncestorInMirror returns a coverage in coverages of the nearest
+// ancestor of revision in the commit graph, or nil if not found.
+func (s *CoverageHandler) findNearestAncestorInMirror(repo base.Repository, revision string, coverages []*Coverage) (*Coverage, error) {
+	candidates := []string{}
+	for _, c := range coverages {
+		candidates = append(candidates, c.Revision)
+	}
+
+	ancestor, err := s.mirror.NearestAncestor(repo, revision, candidates)
+	if err != nil || ancestor == "" {
+		return nil, err
+	}
+
+	return s.coverages.FindRevision(repo.Id, ancestor)
+}
+
+// nearestAncestorIn returns a coverage in coverages of the nearest ancestor
+// revision of cov. The commit graph in the mirror is used if available,
+// otherwise the latest coverage older than cov by olderThan is returned. nil
+// is returned when no ancestor has coverage.
+func (s *CoverageHandler) nearestAncestorIn(repo base.Repository, cov *Coverage, coverages []*Coverage) (*Coverage, error) {
+	if s.mirror != nil && s.mirror.Has(repo) {
+		candidates := []string{}
+		for _, c := range coverages {
+			candidates = append(candidates, c.Revision)
+		}
+
+		ancestor, err := s.mirror.NearestAncestor(repo, cov.Revision, candidates)
+		if err == nil {
+			for _, c := range coverages {
+				if ancestor != "" && c.Revision == ancestor {
+					return c, nil
+				}
+			}
+			return nil, nil
+		}
+		log.Warn().Err(err).Msg("nearestAncestorIn: fallback to timestamp")
+	}
+
+	var nearest *Coverage
+	for _, c := range coverages {
+		if !olderThan(c, cov) {
+			continue
+		}
+		if nearest == nil || olderThan(nearest, c) {
+			nearest = c
+		}
+	}
+
+	return nearest, nil
+}
+
+// findNearestAncestor returns a coverage of the nearest ancestor revision of
+// cov with profiles, or nil when no ancestor has coverage.
+func (s *CoverageHandler) findNearestAncestor(repo base.Repository, cov *Coverage) (*Coverage, error) {
+	coverages, err := s.coverages.List(repo.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	nearest, err := s.nearestAncestorIn(repo, cov, coverages)
+	if err != nil || nearest == nil {
+		return nil, err
+	}
+	return s.coverages.Find(nearest.ID)
+}
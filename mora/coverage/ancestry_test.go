@@ -0,0 +1,156 @@
+package coverage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/mirror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func commitEmpty(t *testing.T, r *git.Repository, when time.Time, parents ...string) string {
+	w, err := r.Worktree()
+	require.NoError(t, err)
+
+	hashes := []plumbing.Hash{}
+	for _, p := range parents {
+		hashes = append(hashes, plumbing.NewHash(p))
+	}
+
+	hash, err := w.Commit("commit", &git.CommitOptions{
+		AllowEmptyCommits: true,
+		Parents:           hashes,
+		Author:            &object.Signature{Name: "mora", Email: "mora@example.com", When: when},
+	})
+	require.NoError(t, err)
+
+	return hash.String()
+}
+
+// setupMirror returns a mirror of a repository with commits below, where c1
+// is uploaded after c2 and c3.
+//
+//	c0 - c1
+//	  \
+//	   c2 - c3
+func setupMirror(t *testing.T, repo *base.Repository) (*mirror.Mirror, []*Coverage) {
+	src := t.TempDir()
+	r, err := git.PlainInit(src, false)
+	require.NoError(t, err)
+
+	now := time.Now().Round(0)
+	c0 := commitEmpty(t, r, now.Add(-4*time.Hour))
+	c1 := commitEmpty(t, r, now.Add(-3*time.Hour), c0)
+	c2 := commitEmpty(t, r, now.Add(-2*time.Hour), c0)
+	c3 := commitEmpty(t, r, now.Add(-1*time.Hour), c2)
+
+	err = r.Storer.SetReference(
+		plumbing.NewHashReference("refs/heads/topic", plumbing.NewHash(c1)))
+	require.NoError(t, err)
+
+	repo.Url = src
+	m, err := mirror.New(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, m.Fetch(context.Background(), *repo, nil))
+
+	coverages := []*Coverage{
+		{RepoID: repo.Id, Revision: c0, Timestamp: now.Add(-4 * time.Hour)},
+		{RepoID: repo.Id, Revision: c1, Timestamp: now.Add(-1 * time.Hour)},
+		{RepoID: repo.Id, Revision: c2, Timestamp: now.Add(-3 * time.Hour)},
+		{RepoID: repo.Id, Revision: c3, Timestamp: now.Add(-2 * time.Hour)},
+	}
+
+	return m, coverages
+}
+
+func TestCoverageHandler_sortCoverages(t *testing.T) {
+	repo := base.Repository{Id: 1215}
+	m, c := setupMirror(t, &repo)
+
+	s := newCoverageHandler(setupCoverageStore(t))
+
+	coverages := []*Coverage{c[3], c[1], c[0], c[2]}
+	s.sortCoverages(repo, coverages)
+	assert.Equal(t, []*Coverage{c[0], c[2], c[3], c[1]}, coverages)
+
+	s.mirror = m
+	s.sortCoverages(repo, coverages)
+	assert.Equal(t, []*Coverage{c[0], c[2], c[1], c[3]}, coverages)
+
+	// fallback to timestamp when a revision is not in the mirror
+	unknown := &Coverage{Revision: "unknown", Timestamp: c[0].Timestamp.Add(-time.Hour)}
+	coverages = []*Coverage{c[3], c[1], c[0], c[2], unknown}
+	s.sortCoverages(repo, coverages)
+	assert.Equal(t, []*Coverage{unknown, c[0], c[2], c[3], c[1]}, coverages)
+}
+
+func TestCoverageHandler_findNearestAncestor(t *testing.T) {
+	repo := base.Repository{Id: 1215}
+	m, c := setupMirror(t, &repo)
+
+	s := newCoverageHandler(setupCoverageStore(t, c...))
+
+	// by timestamp
+	got, err := s.findNearestAncestor(repo, c[1])
+	require.NoError(t, err)
+	assert.Equal(t, c[3].ID, got.ID)
+
+	got, err = s.findNearestAncestor(repo, c[0])
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	// by commit graph
+	s.mirror = m
+
+	got, err = s.findNearestAncestor(repo, c[1])
+	require.NoError(t, err)
+	assert.Equal(t, c[0].ID, got.ID)
+
+	got, err = s.findNearestAncestor(repo, c[3])
+	require.NoError(t, err)
+	assert.Equal(t, c[2].ID, got.ID)
+
+	got, err = s.findNearestAncestor(repo, c[0])
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func Test_CoverageHandler_Ancestor(t *testing.T) {
+	rm := NewMockRepositoryClient()
+	repo := base.Repository{Id: 1215}
+	m, c := setupMirror(t, &repo)
+
+	s := newCoverageHandler(setupCoverageStore(t, c...))
+	s.mirror = m
+
+	get := func(cov *Coverage) *httptest.ResponseRecorder {
+		path := fmt.Sprintf("/%d/ancestor", cov.ID)
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		ctx := base.WithRepo(base.WithRepositoryClient(req.Context(), rm), repo)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req.WithContext(ctx))
+		return w
+	}
+
+	w := get(c[1])
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	var got CoverageResponse
+	err := json.NewDecoder(w.Result().Body).Decode(&got)
+	require.NoError(t, err)
+	assert.Equal(t, c[0].ID, got.ID)
+	assert.Equal(t, c[0].Revision, got.Revision)
+
+	w = get(c[0])
+	require.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+}
@@ -0,0 +1,244 @@
+package coverage
+
+import (
+	"fmt"
+
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/rs/zerolog/log"
+)
+
+// findAncestors returns the nearest ancestor of each coverage in coverages
+// as nearestAncestorIn does. A key of the map is an ID of a coverage. When
+// the commit graph is not available, an ancestor is the previous coverage by
+// olderThan.
+func (s *CoverageHandler) findAncestors(repo base.Repository, coverages []*Coverage) map[int64]*Coverage {
+	byRevision := map[string]*Coverage{}
+	revisions := []string{}
+	for _, cov := range coverages {
+		byRevision[cov.Revision] = cov
+		revisions = append(revisions, cov.Revision)
+	}
+
+	byTime := append([]*Coverage{}, coverages...)
+	sortByTimestamp(byTime)
+	previous := map[int64]*Coverage{}
+	for i := 1; i < len(byTime); i++ {
+		previous[byTime[i].ID] = byTime[i-1]
+	}
+
+	var nearest map[string]string // nil if the commit graph is not available
+	if s.mirror != nil && s.mirror.Has(repo) {
+		var err error
+		nearest, err = s.mirror.NearestAncestors(repo, revisions)
+		if err != nil {
+			log.Warn().Err(err).Msg("findAncestors: fallback to the sorted order")
+		}
+	}
+
+	ancestors := map[int64]*Coverage{}
+	for _, cov := range coverages {
+		if revision, ok := nearest[cov.Revision]; ok {
+			if revision != "" {
+				ancestors[cov.ID] = byRevision[revision]
+			}
+			continue
+		}
+
+		if prev, ok := previous[cov.ID]; ok {
+			ancestors[cov.ID] = prev
+		}
+	}
+
+	return ancestors
+}
+
+// carryForward adds entries which a coverage lacks but its ancestor has.
+// An added entry has CarriedFrom, a revision where the entry was uploaded,
+// and the same profiles as the ancestor's. Coverages have to be sorted by sortCoverages so that an
+// ancestor is processed before its descendants.
+func (s *CoverageHandler) carryForward(repo base.Repository, coverages []*Coverage) {
+	ancestors := s.findAncestors(repo, coverages)
+
+	for _, cov := range coverages {
+		ancestor, ok := ancestors[cov.ID]
+		if !ok {
+			continue
+		}
+
+		for _, e := range ancestor.Entries {
+			if cov.FindEntry(e.Name) != nil {
+				continue
+			}
+
+			carriedFrom := e.CarriedFrom
+			if carriedFrom == "" {
+				carriedFrom = ancestor.Revision
+			}
+
+			cov.Entries = append(cov.Entries, &CoverageEntry{
+				Name:        e.Name,
+				Hits:        e.Hits,
+				Lines:       e.Lines,
+				Profiles:    e.Profiles,
+				Tags:        e.Tags,
+				CarriedFrom: carriedFrom,
+
+				blocksHashes: e.blocksHashes,
+			})
+		}
+	}
+}
+
+// listCoverages returns sorted coverages of a repository with carried
+// forward entries. Profiles of entries have hits and lines without blocks.
+func (s *CoverageHandler) listCoverages(repo base.Repository) ([]*Coverage, error) {
+	coverages, err := s.coverages.ListFileTotals(repo.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.sortCoverages(repo, coverages)
+	s.carryForward(repo, coverages)
+
+	return coverages, nil
+}
+
+// carryForwardTo returns a copy of cov with entries carried forward from its
+// ancestors as listCoverages does. Only the ancestor chain of cov is followed
+// and carried entries have profiles.
+func (s *CoverageHandler) carryForwardTo(repo base.Repository, cov *Coverage) (*Coverage, error) {
+	coverages, err := s.coverages.List(repo.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	// the chain is followed until all entries in the repository are found
+	names := map[string]bool{}
+	for _, c := range coverages {
+		for _, e := range c.Entries {
+			names[e.Name] = true
+		}
+	}
+
+	ret := *cov
+	ret.Entries = append([]*CoverageEntry{}, cov.Entries...)
+
+	src := cov
+	for len(ret.Entries) < len(names) {
+		src, err = s.nearestAncestorIn(repo, src, coverages)
+		if err != nil {
+			return nil, err
+		}
+		if src == nil {
+			break
+		}
+
+		if !hasMissingEntry(&ret, src) {
+			continue
+		}
+
+		loaded, err := s.coverages.Find(src.ID)
+		if err != nil {
+			return nil, err
+		}
+		if loaded == nil {
+			return nil, fmt.Errorf("coverage not found: id=%d", src.ID)
+		}
+
+		for _, e := range loaded.Entries {
+			if ret.FindEntry(e.Name) != nil {
+				continue
+			}
+			ret.Entries = append(ret.Entries, &CoverageEntry{
+				Name:        e.Name,
+				Hits:        e.Hits,
+				Lines:       e.Lines,
+				Profiles:    e.Profiles,
+				Tags:        e.Tags,
+				CarriedFrom: loaded.Revision,
+			})
+		}
+	}
+
+	return &ret, nil
+}
+
+// hasMissingEntry returns true if src has an entry which cov does not have.
+func hasMissingEntry(cov *Coverage, src *Coverage) bool {
+	for _, e := range src.Entries {
+		if cov.FindEntry(e.Name) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// findCarriedEntry returns an entry carried forward to cov with profiles,
+// or nil if cov does not have the entry even after carry-forward. cov does
+// not have the entry. Ancestors of cov are followed until one which has the
+// entry.
+func (s *CoverageHandler) findCarriedEntry(repo base.Repository, cov *Coverage, name string) (*CoverageEntry, error) {
+	coverages, err := s.coverages.List(repo.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	src := cov
+	for {
+		src, err = s.nearestAncestorIn(repo, src, coverages)
+		if err != nil || src == nil {
+			return nil, err
+		}
+		if src.FindEntry(name) != nil {
+			break
+		}
+	}
+
+	src, err = s.coverages.Find(src.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := src.FindEntry(name)
+	if entry == nil {
+		return nil, nil
+	}
+
+	return &CoverageEntry{
+		Name:        entry.Name,
+		Hits:        entry.Hits,
+		Lines:       entry.Lines,
+		Profiles:    entry.Profiles,
+		Tags:        entry.Tags,
+		CarriedFrom: src.Revision,
+	}, nil
+}
+
+// searchCoverages returns sorted coverages matched with filter with carried
+// forward entries. Entries are carried forward along all coverages of the
+// repository, not only along matched ones.
+func (s *CoverageHandler) searchCoverages(repo base.Repository, filter CoverageFilter) ([]*Coverage, error) {
+	coverages, err := s.listCoverages(repo)
+	if err != nil || filter == (CoverageFilter{}) {
+		return coverages, err
+	}
+
+	matched, err := s.coverages.Search(repo.Id, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := map[int64]bool{}
+	for _, cov := range matched {
+		ids[cov.ID] = true
+	}
+
+	filtered := []*Coverage{}
+	for _, cov := range coverages {
+		if ids[cov.ID] {
+			filtered = append(filtered, cov)
+		}
+	}
+
+	return filtered, nil
+}
@@ -0,0 +1,203 @@
+package coverage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func entryNames(cov *Coverage) map[string]string {
+	names := map[string]string{}
+	for _, e := range cov.Entries {
+		names[e.Name] = e.CarriedFrom
+	}
+	return names
+}
+
+func TestCoverageHandler_carryForward(t *testing.T) {
+	repo := base.Repository{Id: 1215}
+	now := time.Now().Round(0)
+
+	cov0 := &Coverage{ID: 1, RepoID: repo.Id, Revision: "r0", Timestamp: now,
+		Entries: []*CoverageEntry{{Name: "unit"}, {Name: "integ", Hits: 1, Lines: 2}}}
+	cov1 := &Coverage{ID: 2, RepoID: repo.Id, Revision: "r1", Timestamp: now.Add(time.Hour),
+		Entries: []*CoverageEntry{{Name: "unit"}}}
+	cov2 := &Coverage{ID: 3, RepoID: repo.Id, Revision: "r2", Timestamp: now.Add(2 * time.Hour),
+		Entries: []*CoverageEntry{{Name: "unit"}}}
+
+	s := newCoverageHandler(setupCoverageStore(t))
+	s.carryForward(repo, []*Coverage{cov0, cov1, cov2})
+
+	assert.Equal(t, map[string]string{"unit": "", "integ": ""}, entryNames(cov0))
+	assert.Equal(t, map[string]string{"unit": "", "integ": "r0"}, entryNames(cov1))
+	assert.Equal(t, map[string]string{"unit": "", "integ": "r0"}, entryNames(cov2))
+
+	carried := cov2.FindEntry("integ")
+	assert.Equal(t, 1, carried.Hits)
+	assert.Equal(t, 2, carried.Lines)
+}
+
+func TestCoverageHandler_carryForward_Mirror(t *testing.T) {
+	repo := base.Repository{Id: 1215}
+	m, c := setupMirror(t, &repo)
+
+	// c1 is on another branch, and uploaded between c0 and c2
+	c[1].Timestamp = c[0].Timestamp.Add(time.Minute)
+	c[0].Entries = []*CoverageEntry{{Name: "unit"}, {Name: "integ"}}
+	c[1].Entries = []*CoverageEntry{{Name: "unit"}, {Name: "integ"}}
+	c[2].Entries = []*CoverageEntry{{Name: "unit"}}
+	c[3].Entries = []*CoverageEntry{{Name: "unit"}}
+
+	s := newCoverageHandler(setupCoverageStore(t, c...))
+	s.mirror = m
+
+	coverages, err := s.listCoverages(repo)
+	require.NoError(t, err)
+	require.Equal(t, 4, len(coverages))
+
+	got := map[string]map[string]string{}
+	for _, cov := range coverages {
+		got[cov.Revision] = entryNames(cov)
+	}
+
+	assert.Equal(t, map[string]string{"unit": "", "integ": c[0].Revision}, got[c[2].Revision])
+	assert.Equal(t, map[string]string{"unit": "", "integ": c[0].Revision}, got[c[3].Revision])
+	assert.Equal(t, map[string]string{"unit": "", "integ": ""}, got[c[1].Revision])
+
+	// followed over c2 without c1 on another branch
+	entry, err := s.findCarriedEntry(repo, c[3], "integ")
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.Equal(t, c[0].Revision, entry.CarriedFrom)
+
+	entry, err = s.findCarriedEntry(repo, c[3], "e2e")
+	require.NoError(t, err)
+	assert.Nil(t, entry)
+
+	// same entries as listed
+	for _, cov := range c {
+		carried, err := s.carryForwardTo(repo, cov)
+		require.NoError(t, err)
+		assert.Equal(t, got[cov.Revision], entryNames(carried))
+	}
+}
+
+func TestCoverageHandler_carryForwardTo(t *testing.T) {
+	repo := base.Repository{Id: 1215}
+	now := time.Now().Round(0)
+
+	prof := &profile.Profile{FileName: "a.go", Hits: 1, Lines: 2, Blocks: [][]int{{1, 2, 1}}}
+	cov0 := &Coverage{RepoID: repo.Id, Revision: "r0", Timestamp: now,
+		Entries: []*CoverageEntry{
+			{Name: "e2e", Hits: 1, Lines: 2, Profiles: map[string]*profile.Profile{"a.go": prof}},
+		}}
+	cov1 := &Coverage{RepoID: repo.Id, Revision: "r1", Timestamp: now.Add(time.Hour),
+		Entries: []*CoverageEntry{{Name: "unit"}, {Name: "integ"}}}
+	cov2 := &Coverage{RepoID: repo.Id, Revision: "r2", Timestamp: now.Add(2 * time.Hour),
+		Entries: []*CoverageEntry{{Name: "unit"}}}
+	s := newCoverageHandler(setupCoverageStore(t, cov0, cov1, cov2))
+
+	got, err := s.carryForwardTo(repo, cov2)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"unit": "", "integ": "r1", "e2e": "r0"}, entryNames(got))
+	assert.Equal(t, prof, got.FindEntry("e2e").Profiles["a.go"])
+
+	// cov2 is not modified
+	assert.Equal(t, 1, len(cov2.Entries))
+}
+
+func Test_CoverageHandler_FileList_CarriedForward(t *testing.T) {
+	rm := NewMockRepositoryClient()
+	repo := base.Repository{Id: 1215}
+	now := time.Now().Round(0)
+
+	prof := &profile.Profile{FileName: "a.go", Hits: 1, Lines: 2, Blocks: [][]int{{1, 2, 1}}}
+	cov0 := &Coverage{RepoID: repo.Id, Revision: "r0", Timestamp: now,
+		Entries: []*CoverageEntry{
+			{Name: "integ", Hits: 1, Lines: 2, Profiles: map[string]*profile.Profile{"a.go": prof}},
+		}}
+	cov1 := &Coverage{RepoID: repo.Id, Revision: "r1", Timestamp: now.Add(time.Hour),
+		Entries: []*CoverageEntry{{Name: "unit"}}}
+
+	s := newCoverageHandler(setupCoverageStore(t, cov0, cov1))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r = r.WithContext(base.WithRepo(base.WithRepositoryClient(r.Context(), rm), repo))
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	w := get(fmt.Sprintf("/%d/integ/files", cov1.ID))
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	var files FileListResponse
+	require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&files))
+	assert.Equal(t, "r1", files.Metadata.Revision)
+	assert.Equal(t, "r0", files.Metadata.CarriedFrom)
+	assert.Equal(t, []*FileResponse{{FileName: "a.go", Hits: 1, Lines: 2}}, files.Files)
+
+	w = get(fmt.Sprintf("/%d/e2e/files", cov1.ID))
+	require.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+
+	w = get("/")
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	var list CoverageListResponse
+	require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&list))
+	require.Equal(t, 2, len(list.Coverages))
+	assert.Equal(t, []*CoverageEntry{
+		{Name: "unit"},
+		{Name: "integ", Hits: 1, Lines: 2, CarriedFrom: "r0"},
+		{Name: CombinedEntryName, Hits: 1, Lines: 2},
+	}, list.Coverages[1].Entries)
+}
+
+func Test_CoverageHandler_FileList_CarriedForward_SameTimestamp(t *testing.T) {
+	rm := NewMockRepositoryClient()
+	repo := base.Repository{Id: 1215}
+	now := time.Now().Round(0)
+
+	prof := &profile.Profile{FileName: "a.go", Hits: 1, Lines: 2, Blocks: [][]int{{1, 2, 1}}}
+	cov0 := &Coverage{RepoID: repo.Id, Revision: "r0", Timestamp: now,
+		Entries: []*CoverageEntry{
+			{Name: "integ", Hits: 1, Lines: 2, Profiles: map[string]*profile.Profile{"a.go": prof}},
+		}}
+	// uploaded later with the same timestamp
+	cov1 := &Coverage{RepoID: repo.Id, Revision: "r1", Timestamp: now,
+		Entries: []*CoverageEntry{{Name: "unit"}}}
+
+	s := newCoverageHandler(setupCoverageStore(t, cov0, cov1))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r = r.WithContext(base.WithRepo(base.WithRepositoryClient(r.Context(), rm), repo))
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/")
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	var list CoverageListResponse
+	require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&list))
+	require.Equal(t, 2, len(list.Coverages))
+	assert.Equal(t, "r1", list.Coverages[1].Revision)
+	assert.Equal(t, "r0", list.Coverages[1].Entries[1].CarriedFrom)
+
+	w = get(fmt.Sprintf("/%d/integ/files", cov1.ID))
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	w = get(fmt.Sprintf("/%d/%s/files", cov1.ID, CombinedEntryName))
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+}
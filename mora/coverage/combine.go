@@ -0,0 +1,162 @@
+package coverage
+
+import (
+	"strings"
+
+	"github.com/elliotchance/pie/v2"
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/profile"
+)
+
+// CombinedEntryName is a name of an entry synthesized from all entries of a
+// coverage including carried entries. A line is hit in the entry if the line
+// is hit in any entry. The entry is not stored but computed when read.
+const CombinedEntryName = "_combined"
+
+// combineEntries returns a combined entry of entries with profiles.
+func combineEntries(entries []*CoverageEntry) *CoverageEntry {
+	files := map[string][]*profile.Profile{}
+	for _, e := range entries {
+		for filename, p := range e.Profiles {
+			files[filename] = append(files[filename], p)
+		}
+	}
+
+	combined := &CoverageEntry{
+		Name:     CombinedEntryName,
+		Profiles: map[string]*profile.Profile{},
+	}
+
+	for filename, profiles := range files {
+		p := profile.Union(profiles...)
+		combined.Profiles[filename] = p
+		combined.Hits += p.Hits
+		combined.Lines += p.Lines
+	}
+
+	return combined
+}
+
+// fileUnion combines entries whose profiles have only hits and lines as
+// loaded by ListFileTotals. Hits and lines of a file in more than one entry
+// are of the union of its blocks, which are loaded by hashes on demand. The
+// totals are memoized by the set of hashes because most files do not change
+// between consecutive revisions.
+type fileUnion struct {
+	store  CoverageStore
+	blocks map[string][][]int          // [hash]
+	totals map[string]*profile.Profile // [joined sorted hashes]
+}
+
+func newFileUnion(store CoverageStore) *fileUnion {
+	return &fileUnion{
+		store:  store,
+		blocks: map[string][][]int{},
+		totals: map[string]*profile.Profile{},
+	}
+}
+
+// combine returns a combined entry of entries. Profiles of the entry have
+// only hits and lines.
+func (u *fileUnion) combine(entries []*CoverageEntry) (*CoverageEntry, error) {
+	files := map[string][]*profile.Profile{}
+	hashes := map[string][]string{}
+	for _, e := range entries {
+		for filename, p := range e.Profiles {
+			files[filename] = append(files[filename], p)
+			hashes[filename] = append(hashes[filename], e.blocksHashes[filename])
+		}
+	}
+
+	missing := []string{}
+	for filename := range hashes {
+		hashes[filename] = sortedUnique(hashes[filename])
+		if len(hashes[filename]) == 1 {
+			continue
+		}
+		for _, h := range hashes[filename] {
+			if _, ok := u.blocks[h]; !ok {
+				missing = append(missing, h)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		blocks, err := u.store.FindBlocks(sortedUnique(missing))
+		if err != nil {
+			return nil, err
+		}
+		for h, b := range blocks {
+			u.blocks[h] = b
+		}
+	}
+
+	combined := &CoverageEntry{
+		Name:     CombinedEntryName,
+		Profiles: map[string]*profile.Profile{},
+	}
+
+	for filename, profiles := range files {
+		p := &profile.Profile{FileName: filename}
+		if len(hashes[filename]) == 1 {
+			p.Hits, p.Lines = profiles[0].Hits, profiles[0].Lines
+		} else {
+			t := u.union(filename, hashes[filename])
+			p.Hits, p.Lines = t.Hits, t.Lines
+		}
+		combined.Profiles[filename] = p
+		combined.Hits += p.Hits
+		combined.Lines += p.Lines
+	}
+
+	return combined, nil
+}
+
+func (u *fileUnion) union(filename string, hashes []string) *profile.Profile {
+	key := strings.Join(hashes, ",")
+	if t, ok := u.totals[key]; ok {
+		return t
+	}
+
+	profiles := []*profile.Profile{}
+	for _, h := range hashes {
+		profiles = append(profiles,
+			&profile.Profile{FileName: filename, Blocks: u.blocks[h]})
+	}
+	p := profile.Union(profiles...)
+	t := &profile.Profile{Hits: p.Hits, Lines: p.Lines}
+	u.totals[key] = t
+	return t
+}
+
+func sortedUnique(values []string) []string {
+	return pie.Sort(pie.Unique(values))
+}
+
+// addCombinedEntries adds the combined entry to each coverage with entries.
+// Coverages have to be listed by listCoverages.
+func (s *CoverageHandler) addCombinedEntries(coverages []*Coverage) error {
+	union := newFileUnion(s.coverages)
+	for _, cov := range coverages {
+		if len(cov.Entries) == 0 {
+			continue
+		}
+		combined, err := union.combine(cov.Entries)
+		if err != nil {
+			return err
+		}
+		cov.Entries = append(cov.Entries, combined)
+	}
+	return nil
+}
+
+// findCombinedEntry returns the combined entry of cov with profiles, or nil
+// if cov has no entries even after carry-forward.
+func (s *CoverageHandler) findCombinedEntry(repo base.Repository, cov *Coverage) (*CoverageEntry, error) {
+	carried, err := s.carryForwardTo(repo, cov)
+	if err != nil || len(carried.Entries) == 0 {
+		return nil, err
+	}
+
+	return combineEntries(carried.Entries), nil
+}
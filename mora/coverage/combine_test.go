@@ -0,0 +1,130 @@
+package coverage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeEntry(name string, profiles ...*profile.Profile) *CoverageEntry {
+	e := &CoverageEntry{Name: name, Profiles: map[string]*profile.Profile{}}
+	for _, p := range profiles {
+		e.Profiles[p.FileName] = p
+		e.Hits += p.Hits
+		e.Lines += p.Lines
+	}
+	return e
+}
+
+func Test_combineEntries(t *testing.T) {
+	unit := makeEntry("unit",
+		&profile.Profile{FileName: "a.go", Hits: 2, Lines: 4, Blocks: [][]int{{1, 2, 1}, {3, 4, 0}}},
+		&profile.Profile{FileName: "b.go", Hits: 0, Lines: 1, Blocks: [][]int{{1, 1, 0}}})
+	integ := makeEntry("integ",
+		&profile.Profile{FileName: "a.go", Hits: 1, Lines: 2, Blocks: [][]int{{3, 3, 1}, {4, 4, 0}}})
+
+	got := combineEntries([]*CoverageEntry{unit, integ})
+
+	want := &CoverageEntry{
+		Name:  CombinedEntryName,
+		Hits:  3,
+		Lines: 5,
+		Profiles: map[string]*profile.Profile{
+			"a.go": {FileName: "a.go", Hits: 3, Lines: 4, Blocks: [][]int{{1, 3, 1}, {4, 4, 0}}},
+			"b.go": {FileName: "b.go", Hits: 0, Lines: 1, Blocks: [][]int{{1, 1, 0}}},
+		},
+	}
+	assert.Equal(t, want, got)
+}
+
+func Test_CoverageHandler_Combined(t *testing.T) {
+	rm := NewMockRepositoryClient()
+	repo := base.Repository{Id: 1215}
+	now := time.Now().Round(0)
+
+	// a and b hit disjoint lines
+	a := &profile.Profile{FileName: "a.go", Hits: 1, Lines: 2, Blocks: [][]int{{1, 1, 1}, {2, 2, 0}}}
+	b := &profile.Profile{FileName: "a.go", Hits: 1, Lines: 2, Blocks: [][]int{{1, 1, 0}, {2, 2, 1}}}
+
+	cov0 := &Coverage{RepoID: repo.Id, Revision: "r0", Timestamp: now,
+		Entries: []*CoverageEntry{makeEntry("integ", b)}}
+	// integ is carried forward from r0
+	cov1 := &Coverage{RepoID: repo.Id, Revision: "r1", Timestamp: now.Add(time.Hour),
+		Entries: []*CoverageEntry{makeEntry("unit", a)}}
+	store := setupCoverageStore(t, cov0, cov1)
+	s := newCoverageHandler(store)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r = r.WithContext(base.WithRepo(base.WithRepositoryClient(r.Context(), rm), repo))
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	// not stored
+	got, err := store.Find(cov1.ID)
+	require.NoError(t, err)
+	assert.Nil(t, got.FindEntry(CombinedEntryName))
+
+	t.Run("latest", func(t *testing.T) {
+		w := get("/latest")
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+		var got CoverageResponse
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&got))
+		require.Equal(t, 3, len(got.Entries))
+		assert.Equal(t, &CoverageEntry{Name: CombinedEntryName, Hits: 2, Lines: 2}, got.Entries[2])
+	})
+
+	t.Run("list", func(t *testing.T) {
+		w := get("/")
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+		var got CoverageListResponse
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&got))
+		require.Equal(t, 2, len(got.Coverages))
+		assert.Equal(t, &CoverageEntry{Name: CombinedEntryName, Hits: 1, Lines: 2},
+			got.Coverages[0].Entries[1])
+		assert.Equal(t, &CoverageEntry{Name: CombinedEntryName, Hits: 2, Lines: 2},
+			got.Coverages[1].Entries[2])
+	})
+
+	t.Run("files", func(t *testing.T) {
+		w := get(fmt.Sprintf("/%d/%s/files", cov1.ID, CombinedEntryName))
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+		var got FileListResponse
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&got))
+		assert.Equal(t, 2, got.Metadata.Hits)
+		assert.Equal(t, []*FileResponse{{FileName: "a.go", Hits: 2, Lines: 2}}, got.Files)
+	})
+}
+
+func TestCoverageHandler_HandleUpload_ReservedEntry(t *testing.T) {
+	request := &CoverageUploadRequest{
+		Revision:  "rev",
+		Timestamp: time.Now(),
+		Entries:   []*CoverageEntryUploadRequest{{Name: CombinedEntryName}},
+	}
+	body, err := json.Marshal(request)
+	require.NoError(t, err)
+
+	s := newCoverageHandler(setupCoverageStore(t))
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+	req = req.WithContext(base.WithRepo(req.Context(), base.Repository{Id: 1}))
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
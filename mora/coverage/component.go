@@ -0,0 +1,58 @@
+package coverage
+
+import (
+	"github.com/iszk1215/mora/mora/base"
+)
+
+type (
+	EntryTotal struct {
+		Name  string `json:"entry"`
+		Hits  int    `json:"hits"`
+		Lines int    `json:"lines"`
+	}
+
+	// ComponentTotal is hits and lines of files in a component. Hits and
+	// Lines are of all entries combined, and Entries are per entry.
+	ComponentTotal struct {
+		Name    string        `json:"name"`
+		Hits    int           `json:"hits"`
+		Lines   int           `json:"lines"`
+		Entries []*EntryTotal `json:"entries"`
+	}
+)
+
+// countComponent returns hits and lines of files of an entry in a component.
+func countComponent(c *base.Component, e *CoverageEntry) (int, int) {
+	hits := 0
+	lines := 0
+	for filename, p := range e.Profiles {
+		if c.Match(filename) {
+			hits += p.Hits
+			lines += p.Lines
+		}
+	}
+	return hits, lines
+}
+
+// componentTotals computes totals of each component from hits and lines of
+// files of entries, which can be listed without blocks, and of their combined
+// entry. Entries without files in a component are omitted from the component.
+func componentTotals(components []*base.Component, entries []*CoverageEntry, combined *CoverageEntry) []*ComponentTotal {
+	totals := []*ComponentTotal{}
+	for _, c := range components {
+		total := &ComponentTotal{Name: c.Name, Entries: []*EntryTotal{}}
+		total.Hits, total.Lines = countComponent(c, combined)
+
+		for _, e := range entries {
+			hits, lines := countComponent(c, e)
+			if lines > 0 {
+				total.Entries = append(total.Entries,
+					&EntryTotal{Name: e.Name, Hits: hits, Lines: lines})
+			}
+		}
+
+		totals = append(totals, total)
+	}
+
+	return totals
+}
@@ -0,0 +1,111 @@
+package coverage
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/render"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// handleCoverageComponents and handleComponentTrend
+	ComponentCoverageResponse struct {
+		ID          int64             `json:"index"`
+		Revision    string            `json:"revision"`
+		RevisionURL string            `json:"revision_url"`
+		Timestamp   time.Time         `json:"time"`
+		Components  []*ComponentTotal `json:"components"`
+	}
+
+	ComponentTrendResponse struct {
+		Repo       base.Repository             `json:"repo"`
+		Components []*base.Component           `json:"definitions"`
+		Coverages  []ComponentCoverageResponse `json:"coverages"`
+	}
+)
+
+// componentsFrom returns components in repository settings.
+func componentsFrom(ctx context.Context) []*base.Component {
+	settings, ok := base.SettingsFrom(ctx)
+	if !ok {
+		return []*base.Component{}
+	}
+	return settings.Components
+}
+
+// handleComponentTrend returns totals of components of coverages matched
+// with a filter such as `?branch=main`.
+func (s *CoverageHandler) handleComponentTrend(w http.ResponseWriter, r *http.Request) {
+	rm, _ := base.RepositoryClientFrom(r.Context())
+	repo, _ := base.RepoFrom(r.Context())
+
+	filter, err := parseCoverageFilter(r)
+	if err != nil {
+		render.BadRequest(w, err)
+		return
+	}
+
+	components := componentsFrom(r.Context())
+
+	coverages, err := s.searchCoverages(repo, filter)
+	if err != nil {
+		log.Error().Err(err).Msg("handleComponentTrend")
+		render.InternalError(w, err)
+		return
+	}
+
+	resp := ComponentTrendResponse{
+		Repo:       repo,
+		Components: components,
+		Coverages:  []ComponentCoverageResponse{},
+	}
+
+	union := newFileUnion(s.coverages)
+	for _, cov := range coverages {
+		combined, err := union.combine(cov.Entries)
+		if err != nil {
+			log.Error().Err(err).Msg("handleComponentTrend")
+			render.InternalError(w, err)
+			return
+		}
+
+		resp.Coverages = append(resp.Coverages, ComponentCoverageResponse{
+			ID:          cov.ID,
+			Revision:    cov.Revision,
+			RevisionURL: rm.RevisionURL(repo.Url, cov.Revision),
+			Timestamp:   cov.Timestamp,
+			Components:  componentTotals(components, cov.Entries, combined),
+		})
+	}
+
+	render.JSON(w, resp, http.StatusOK)
+}
+
+// handleCoverageComponents returns totals of components of a coverage
+// including entries carried forward.
+func (s *CoverageHandler) handleCoverageComponents(w http.ResponseWriter, r *http.Request) {
+	rm, _ := base.RepositoryClientFrom(r.Context())
+	repo, _ := base.RepoFrom(r.Context())
+	cov, _ := CoverageFrom(r.Context())
+
+	components := componentsFrom(r.Context())
+
+	carried, err := s.carryForwardTo(repo, cov)
+	if err != nil {
+		log.Error().Err(err).Msg("handleCoverageComponents")
+		render.InternalError(w, err)
+		return
+	}
+
+	resp := ComponentCoverageResponse{
+		ID:          cov.ID,
+		Revision:    cov.Revision,
+		RevisionURL: rm.RevisionURL(repo.Url, cov.Revision),
+		Timestamp:   cov.Timestamp,
+		Components:  componentTotals(components, carried.Entries, combineEntries(carried.Entries)),
+	}
+	render.JSON(w, resp, http.StatusOK)
+}
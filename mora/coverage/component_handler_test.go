@@ -0,0 +1,85 @@
+package coverage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CoverageHandler_Components(t *testing.T) {
+	rm := NewMockRepositoryClient()
+	repo := base.Repository{Id: 1215}
+	now := time.Now().Round(0)
+
+	payments := &profile.Profile{FileName: "services/payments/a.go", Hits: 1, Lines: 2, Blocks: [][]int{{1, 1, 1}, {2, 2, 0}}}
+	billing := &profile.Profile{FileName: "services/billing/b.go", Hits: 1, Lines: 1, Blocks: [][]int{{1, 1, 1}}}
+	// integ hits a line of payments which unit does not hit
+	paymentsInteg := &profile.Profile{FileName: "services/payments/a.go", Hits: 1, Lines: 2, Blocks: [][]int{{1, 1, 0}, {2, 2, 1}}}
+
+	cov0 := &Coverage{RepoID: repo.Id, Revision: "r0", Timestamp: now,
+		Entries: []*CoverageEntry{makeEntry("unit", payments), makeEntry("integ", billing, paymentsInteg)}}
+	// integ is carried forward from r0
+	cov1 := &Coverage{RepoID: repo.Id, Revision: "r1", Timestamp: now.Add(time.Hour),
+		Entries: []*CoverageEntry{makeEntry("unit", payments)}}
+
+	s := newCoverageHandler(setupCoverageStore(t, cov0, cov1))
+
+	components := []*base.Component{
+		{Name: "payments", Paths: []string{"services/payments/**"}},
+		{Name: "billing", Paths: []string{"services/billing/**"}},
+	}
+	settings := base.NewRepositorySettings()
+	settings.Components = components
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		ctx := base.WithRepo(base.WithRepositoryClient(r.Context(), rm), repo)
+		r = r.WithContext(base.WithSettings(ctx, settings))
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("coverage", func(t *testing.T) {
+		w := get(fmt.Sprintf("/%d/components", cov1.ID))
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+		var got ComponentCoverageResponse
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&got))
+		assert.Equal(t, "r1", got.Revision)
+		assert.Equal(t, []*ComponentTotal{
+			{Name: "payments", Hits: 2, Lines: 2, Entries: []*EntryTotal{
+				{Name: "unit", Hits: 1, Lines: 2},
+				{Name: "integ", Hits: 1, Lines: 2},
+			}},
+			{Name: "billing", Hits: 1, Lines: 1, Entries: []*EntryTotal{{Name: "integ", Hits: 1, Lines: 1}}},
+		}, got.Components)
+	})
+
+	t.Run("trend", func(t *testing.T) {
+		w := get("/components/trend")
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+		var got ComponentTrendResponse
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&got))
+		assert.Equal(t, components, got.Components)
+		require.Equal(t, 2, len(got.Coverages))
+		assert.Equal(t, "r0", got.Coverages[0].Revision)
+		assert.Equal(t, "r1", got.Coverages[1].Revision)
+		for _, cov := range got.Coverages {
+			require.Equal(t, 2, len(cov.Components))
+			assert.Equal(t, 2, cov.Components[0].Hits)
+			assert.Equal(t, 2, cov.Components[0].Lines)
+			assert.Equal(t, 1, cov.Components[1].Hits)
+			assert.Equal(t, 1, cov.Components[1].Lines)
+		}
+	})
+}
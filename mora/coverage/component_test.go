@@ -0,0 +1,39 @@
+package coverage
+
+import (
+	"testing"
+
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/profile"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_componentTotals(t *testing.T) {
+	components := []*base.Component{
+		{Name: "payments", Paths: []string{"services/payments/**"}},
+		{Name: "billing", Paths: []string{"services/billing/**"}},
+		{Name: "docs", Paths: []string{"docs/**"}},
+	}
+
+	// unit and integ hit different lines of a.go
+	unit := makeEntry("unit",
+		&profile.Profile{FileName: "services/payments/a.go", Hits: 1, Lines: 2, Blocks: [][]int{{1, 1, 1}, {2, 2, 0}}},
+		&profile.Profile{FileName: "services/billing/b.go", Hits: 1, Lines: 1, Blocks: [][]int{{1, 1, 1}}})
+	integ := makeEntry("integ",
+		&profile.Profile{FileName: "services/payments/a.go", Hits: 1, Lines: 2, Blocks: [][]int{{1, 1, 0}, {2, 2, 1}}})
+	entries := []*CoverageEntry{unit, integ}
+
+	got := componentTotals(components, entries, combineEntries(entries))
+
+	want := []*ComponentTotal{
+		{Name: "payments", Hits: 2, Lines: 2, Entries: []*EntryTotal{
+			{Name: "unit", Hits: 1, Lines: 2},
+			{Name: "integ", Hits: 1, Lines: 2},
+		}},
+		{Name: "billing", Hits: 1, Lines: 1, Entries: []*EntryTotal{
+			{Name: "unit", Hits: 1, Lines: 1},
+		}},
+		{Name: "docs", Hits: 0, Lines: 0, Entries: []*EntryTotal{}},
+	}
+	assert.Equal(t, want, got)
+}
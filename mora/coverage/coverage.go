@@ -15,14 +15,45 @@ type (
 		Hits     int    `json:"hits"`
 		Lines    int    `json:"lines"`
 		Profiles map[string]*profile.Profile
+
+		// Key/value pairs such as os=linux. Nil if no tags.
+		Tags map[string]string `json:"tags,omitempty"`
+
+		// Revision where this entry was uploaded when this entry is carried
+		// forward from an ancestor. Empty if not carried.
+		CarriedFrom string `json:"carried_from,omitempty"`
+
+		// Hashes of blocks of files. Set by ListFileTotals to compute
+		// union of profiles without blocks. See fileUnion.
+		blocksHashes map[string]string
 	}
 
 	Coverage struct {
-		ID        int64
-		RepoID    int64
-		Revision  string
-		Timestamp time.Time
-		Entries   []*CoverageEntry
+		ID          int64
+		RepoID      int64
+		Revision    string
+		Timestamp   time.Time
+		Branch      string // empty if unknown
+		Tag         string // empty if not tagged
+		PullRequest int    // zero if not a pull request
+		Entries     []*CoverageEntry
+	}
+
+	// CoverageFilter selects coverages by refs. Empty fields match any
+	// coverage.
+	CoverageFilter struct {
+		Branch      string
+		Tag         string
+		PullRequest int
+	}
+
+	// FileRevision is coverage of a file at a revision
+	FileRevision struct {
+		CoverageID int64
+		Revision   string
+		Timestamp  time.Time
+		Hits       int
+		Lines      int
 	}
 
 	CoverageStore interface {
@@ -30,8 +61,20 @@ type (
 		Find(id int64) (*Coverage, error)
 		FindRevision(id int64, revision string) (*Coverage, error)
 		List(id int64) ([]*Coverage, error)
+		// ListFileTotals returns coverages of a repository with hits and
+		// lines of files. Blocks of profiles are not loaded.
+		ListFileTotals(repoID int64) ([]*Coverage, error)
+		// FindBlocks returns blocks of profiles by hashes of blocks.
+		FindBlocks(hashes []string) (map[string][][]int, error)
+		// Search returns coverages of a repository matched with filter.
+		// Profiles of entries are not loaded.
+		Search(repoID int64, filter CoverageFilter) ([]*Coverage, error)
 		ListAll() ([]*Coverage, error)
+		// ListFileRevisions returns coverage of a file in an entry at all
+		// revisions in time order
+		ListFileRevisions(repoID int64, entry string, filename string) ([]*FileRevision, error)
 		Put(*Coverage) error
+		Delete(id int64) error
 	}
 )
 
@@ -71,11 +114,25 @@ func mergeCoverage(a, b *Coverage) (*Coverage, error) {
 		return tmp[i].Name < tmp[j].Name
 	})
 
+	// refs given later are preferred
 	merged := &Coverage{
-		RepoID:    a.RepoID,
-		Revision:  a.Revision,
-		Timestamp: a.Timestamp,
-		Entries:   tmp,
+		RepoID:      a.RepoID,
+		Revision:    a.Revision,
+		Timestamp:   a.Timestamp,
+		Branch:      a.Branch,
+		Tag:         a.Tag,
+		PullRequest: a.PullRequest,
+		Entries:     tmp,
+	}
+
+	if b.Branch != "" {
+		merged.Branch = b.Branch
+	}
+	if b.Tag != "" {
+		merged.Tag = b.Tag
+	}
+	if b.PullRequest != 0 {
+		merged.PullRequest = b.PullRequest
 	}
 
 	return merged, nil
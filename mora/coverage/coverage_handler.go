@@ -4,13 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"reflect"
 	"sort"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/mirror"
 	"github.com/iszk1215/mora/mora/profile"
 	"github.com/iszk1215/mora/mora/render"
 	"github.com/rs/zerolog/log"
@@ -23,6 +28,9 @@ type (
 		RevisionURL string           `json:"revision_url"`
 		Revision    string           `json:"revision"`
 		Timestamp   time.Time        `json:"time"`
+		Branch      string           `json:"branch,omitempty"`
+		Tag         string           `json:"tag,omitempty"`
+		PullRequest int              `json:"pull_request,omitempty"`
 		Entries     []*CoverageEntry `json:"entries"`
 		// Profiles in entry is emptry
 	}
@@ -45,6 +53,7 @@ type (
 		Time        time.Time `json:"time"`
 		Hits        int       `json:"hits"`
 		Lines       int       `json:"lines"`
+		CarriedFrom string    `json:"carried_from,omitempty"`
 	}
 
 	FileListResponse struct {
@@ -53,6 +62,13 @@ type (
 		Files    []*FileResponse `json:"files"`
 	}
 
+	// handleFileTree
+	FileTreeResponse struct {
+		Metadata MetaResonse        `json:"meta"`
+		Repo     base.Repository    `json:"repo"`
+		Root     *DirectoryResponse `json:"root"`
+	}
+
 	// handleFile
 	CodeResponse struct {
 		Repo     base.Repository `json:"repo"`
@@ -61,24 +77,47 @@ type (
 		Blocks   [][]int         `json:"blocks"`
 	}
 
+	// handleFileHistory
+	FileRevisionResponse struct {
+		ID          int64     `json:"index"`
+		Revision    string    `json:"revision"`
+		RevisionURL string    `json:"revision_url"`
+		Timestamp   time.Time `json:"time"`
+		Hits        int       `json:"hits"`
+		Lines       int       `json:"lines"`
+	}
+
+	FileHistoryResponse struct {
+		Repo      base.Repository        `json:"repo"`
+		Entry     string                 `json:"entry"`
+		FileName  string                 `json:"filename"`
+		Revisions []FileRevisionResponse `json:"revisions"`
+	}
+
 	// Upload
 	CoverageEntryUploadRequest struct {
 		Name     string             `json:"entry"`
 		Hits     int                `json:"hits"`
 		Lines    int                `json:"lines"`
 		Profiles []*profile.Profile `json:"profiles"`
+		Tags     map[string]string  `json:"tags,omitempty"`
 	}
 
 	// FIXME: Remove RepoURL
 	CoverageUploadRequest struct {
-		RepoURL   string                        `json:"repo"`
-		Revision  string                        `json:"revision"`
-		Timestamp time.Time                     `json:"time"`
-		Entries   []*CoverageEntryUploadRequest `json:"entries"`
+		RepoURL     string                        `json:"repo"`
+		Revision    string                        `json:"revision"`
+		Timestamp   time.Time                     `json:"time"`
+		Branch      string                        `json:"branch,omitempty"`
+		Tag         string                        `json:"tag,omitempty"`
+		PullRequest int                           `json:"pull_request,omitempty"` // zero if not a pull request
+		Entries     []*CoverageEntryUploadRequest `json:"entries"`
 	}
 
 	CoverageHandler struct {
 		coverages CoverageStore
+		sources   *sourceCache   // nil if disabled
+		mirror    *mirror.Mirror // nil if disabled
 	}
 
 	coverageContextKey int
@@ -107,9 +146,12 @@ func CoverageEntryFrom(ctx context.Context) (*CoverageEntry, bool) {
 	return entry, ok
 }
 
-func injectCoverageEntry(next http.Handler) http.Handler {
+// injectCoverageEntry injects an entry of a coverage. When the coverage does
+// not have the entry, an entry carried forward from an ancestor is injected.
+func (s *CoverageHandler) injectCoverageEntry(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		entryName := chi.URLParam(r, "entry")
+		repo, _ := base.RepoFrom(r.Context())
 
 		cov, ok := CoverageFrom(r.Context())
 		if !ok {
@@ -118,7 +160,37 @@ func injectCoverageEntry(next http.Handler) http.Handler {
 			return
 		}
 
-		entry := cov.FindEntry(entryName)
+		var entry *CoverageEntry
+		var err error
+		if entryName == GroupEntryName {
+			tags, err := parseEntryTagFilter(r)
+			if err != nil {
+				render.BadRequest(w, err)
+				return
+			}
+
+			entry, err = s.findGroupEntry(repo, cov, tags)
+			if err != nil {
+				log.Error().Err(err).Msg("injectCoverageEntry")
+				render.InternalError(w, err)
+				return
+			}
+		} else if entryName == CombinedEntryName {
+			entry, err = s.findCombinedEntry(repo, cov)
+			if err != nil {
+				log.Error().Err(err).Msg("injectCoverageEntry")
+				render.InternalError(w, err)
+				return
+			}
+		} else if entry = cov.FindEntry(entryName); entry == nil {
+			entry, err = s.findCarriedEntry(repo, cov, entryName)
+			if err != nil {
+				log.Error().Err(err).Msg("injectCoverageEntry")
+				render.InternalError(w, err)
+				return
+			}
+		}
+
 		if entry == nil {
 			log.Warn().Msg("can not find entry")
 			render.NotFound(w, render.ErrNotFound)
@@ -160,14 +232,19 @@ func makeCoverageResponse(revisionURL string, cov *Coverage) CoverageResponse {
 		Timestamp:   cov.Timestamp,
 		Revision:    cov.Revision,
 		RevisionURL: revisionURL,
+		Branch:      cov.Branch,
+		Tag:         cov.Tag,
+		PullRequest: cov.PullRequest,
 		Entries:     []*CoverageEntry{},
 	}
 
 	for _, e := range cov.Entries {
 		f := &CoverageEntry{
-			Name:  e.Name,
-			Hits:  e.Hits,
-			Lines: e.Lines,
+			Name:        e.Name,
+			Hits:        e.Hits,
+			Lines:       e.Lines,
+			Tags:        e.Tags,
+			CarriedFrom: e.CarriedFrom,
 		}
 		resp.Entries = append(resp.Entries, f)
 	}
@@ -192,31 +269,144 @@ func makeCoverageListResponse(
 	return resp
 }
 
+// parseCoverageFilter parses a filter from query parameters `branch`, `tag`
+// and `pr`.
+func parseCoverageFilter(r *http.Request) (CoverageFilter, error) {
+	query := r.URL.Query()
+	filter := CoverageFilter{
+		Branch: query.Get("branch"),
+		Tag:    query.Get("tag"),
+	}
+
+	if pr := query.Get("pr"); pr != "" {
+		n, err := strconv.Atoi(pr)
+		if err != nil || n <= 0 {
+			return CoverageFilter{}, errors.New("invalid pull request number")
+		}
+		filter.PullRequest = n
+	}
+
+	return filter, nil
+}
+
 func (s *CoverageHandler) handleCoverageList(w http.ResponseWriter, r *http.Request) {
 	rm, _ := base.RepositoryClientFrom(r.Context())
 	repo, _ := base.RepoFrom(r.Context())
 
-	coverages, err := s.coverages.List(repo.Id)
+	filter, err := parseCoverageFilter(r)
+	if err != nil {
+		render.BadRequest(w, err)
+		return
+	}
+
+	tags, err := parseEntryTagFilter(r)
+	if err != nil {
+		render.BadRequest(w, err)
+		return
+	}
+
+	coverages, err := s.searchCoverages(repo, filter)
 	if err != nil {
 		log.Warn().Err(err).Msg("")
 		render.NotFound(w, render.ErrNotFound)
 		return
 	}
 
+	if len(tags) > 0 {
+		coverages, err = s.groupCoverages(coverages, tags)
+	} else {
+		err = s.addCombinedEntries(coverages)
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("")
+		render.InternalError(w, err)
+		return
+	}
+
 	if len(coverages) == 0 {
 		log.Warn().Msgf("Unknown coverage not found for repo.Id=%d", repo.Id)
 		render.NotFound(w, render.ErrNotFound)
 		return
 	}
 
-	sort.Slice(coverages, func(i, j int) bool {
-		return coverages[i].Timestamp.Before(coverages[j].Timestamp)
-	})
-
 	resp := makeCoverageListResponse(rm, repo, coverages)
 	render.JSON(w, resp, http.StatusOK)
 }
 
+// handleLatest returns the latest coverage matched with a filter such as
+// `?branch=main`.
+func (s *CoverageHandler) handleLatest(w http.ResponseWriter, r *http.Request) {
+	rm, _ := base.RepositoryClientFrom(r.Context())
+	repo, _ := base.RepoFrom(r.Context())
+
+	filter, err := parseCoverageFilter(r)
+	if err != nil {
+		render.BadRequest(w, err)
+		return
+	}
+
+	tags, err := parseEntryTagFilter(r)
+	if err != nil {
+		render.BadRequest(w, err)
+		return
+	}
+
+	coverages, err := s.searchCoverages(repo, filter)
+	if err != nil {
+		log.Error().Err(err).Msg("handleLatest")
+		render.InternalError(w, err)
+		return
+	}
+
+	if len(tags) > 0 {
+		coverages, err = s.groupCoverages(coverages, tags)
+	} else {
+		err = s.addCombinedEntries(coverages)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("handleLatest")
+		render.InternalError(w, err)
+		return
+	}
+
+	if len(coverages) == 0 {
+		render.NotFound(w, render.ErrNotFound)
+		return
+	}
+
+	latest := coverages[len(coverages)-1]
+
+	revURL := rm.RevisionURL(repo.Url, latest.Revision)
+	render.JSON(w, makeCoverageResponse(revURL, latest), http.StatusOK)
+}
+
+// handleAncestor returns a coverage of the nearest ancestor revision. This is
+// a base to be compared with.
+func (s *CoverageHandler) handleAncestor(w http.ResponseWriter, r *http.Request) {
+	rm, _ := base.RepositoryClientFrom(r.Context())
+	repo, _ := base.RepoFrom(r.Context())
+	cov, _ := CoverageFrom(r.Context())
+
+	ancestor, err := s.findNearestAncestor(repo, cov)
+	if err != nil {
+		log.Error().Err(err).Msg("handleAncestor")
+		render.InternalError(w, err)
+		return
+	}
+
+	if ancestor == nil {
+		render.NotFound(w, render.ErrNotFound)
+		return
+	}
+
+	if len(ancestor.Entries) > 0 {
+		ancestor.Entries = append(ancestor.Entries, combineEntries(ancestor.Entries))
+	}
+
+	revURL := rm.RevisionURL(repo.Url, ancestor.Revision)
+	render.JSON(w, makeCoverageResponse(revURL, ancestor), http.StatusOK)
+}
+
 func makeFileListResponse(rm base.RepositoryClient, repo base.Repository, cov *Coverage, entry *CoverageEntry) FileListResponse {
 	files := []*FileResponse{}
 	for _, pr := range entry.Profiles {
@@ -229,15 +419,20 @@ func makeFileListResponse(rm base.RepositoryClient, repo base.Repository, cov *C
 	})
 
 	return FileListResponse{
-		Files: files,
-		Repo:  repo,
-		Metadata: MetaResonse{
-			Revision:    cov.Revision,
-			RevisionURL: rm.RevisionURL(repo.Url, cov.Revision),
-			Time:        cov.Timestamp,
-			Hits:        entry.Hits,
-			Lines:       entry.Lines,
-		},
+		Files:    files,
+		Repo:     repo,
+		Metadata: makeMetaResponse(rm, repo, cov, entry),
+	}
+}
+
+func makeMetaResponse(rm base.RepositoryClient, repo base.Repository, cov *Coverage, entry *CoverageEntry) MetaResonse {
+	return MetaResonse{
+		Revision:    cov.Revision,
+		RevisionURL: rm.RevisionURL(repo.Url, cov.Revision),
+		Time:        cov.Timestamp,
+		Hits:        entry.Hits,
+		Lines:       entry.Lines,
+		CarriedFrom: entry.CarriedFrom,
 	}
 }
 
@@ -252,6 +447,64 @@ func handleFileList(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, resp, http.StatusOK)
 }
 
+func handleFileTree(w http.ResponseWriter, r *http.Request) {
+	rm, _ := base.RepositoryClientFrom(r.Context())
+	repo, _ := base.RepoFrom(r.Context())
+	cov, _ := CoverageFrom(r.Context())
+	entry, _ := CoverageEntryFrom(r.Context())
+
+	resp := FileTreeResponse{
+		Metadata: makeMetaResponse(rm, repo, cov, entry),
+		Repo:     repo,
+		Root:     makeDirectoryTree(entry.Profiles),
+	}
+	render.JSON(w, resp, http.StatusOK)
+}
+
+func (s *CoverageHandler) handleFileHistory(w http.ResponseWriter, r *http.Request) {
+	rm, _ := base.RepositoryClientFrom(r.Context())
+	repo, _ := base.RepoFrom(r.Context())
+
+	file := chi.URLParam(r, "*")
+	entry := r.URL.Query().Get("entry")
+	if entry == "" {
+		render.BadRequest(w, errors.New("entry is not specified"))
+		return
+	}
+
+	revisions, err := s.coverages.ListFileRevisions(repo.Id, entry, file)
+	if err != nil {
+		log.Error().Err(err).Msg("handleFileHistory")
+		render.InternalError(w, err)
+		return
+	}
+
+	if len(revisions) == 0 {
+		render.NotFound(w, render.ErrNotFound)
+		return
+	}
+
+	resp := FileHistoryResponse{
+		Repo:      repo,
+		Entry:     entry,
+		FileName:  file,
+		Revisions: []FileRevisionResponse{},
+	}
+
+	for _, rev := range revisions {
+		resp.Revisions = append(resp.Revisions, FileRevisionResponse{
+			ID:          rev.CoverageID,
+			Revision:    rev.Revision,
+			RevisionURL: rm.RevisionURL(repo.Url, rev.Revision),
+			Timestamp:   rev.Timestamp,
+			Hits:        rev.Hits,
+			Lines:       rev.Lines,
+		})
+	}
+
+	render.JSON(w, resp, http.StatusOK)
+}
+
 func getSourceCode(ctx context.Context, revision, path string) ([]byte, error) {
 	rm, _ := base.RepositoryClientFrom(ctx)
 	repo, _ := base.RepoFrom(ctx)
@@ -267,7 +520,137 @@ func getSourceCode(ctx context.Context, revision, path string) ([]byte, error) {
 	return content.Data, nil
 }
 
-func handleFile(w http.ResponseWriter, r *http.Request) {
+// fetchSourceCode returns source code from the mirror if available, otherwise
+// from the repository manager.
+func (s *CoverageHandler) fetchSourceCode(ctx context.Context, revision, path string) ([]byte, error) {
+	repo, _ := base.RepoFrom(ctx)
+	if s.mirror != nil && s.mirror.Has(repo) {
+		code, err := s.mirror.Contents(repo, revision, path)
+		if err == nil {
+			return code, nil
+		}
+		// revision may not be fetched yet
+		log.Warn().Err(err).Msgf("fetchSourceCode: mirror: %s", path)
+	}
+
+	return getSourceCode(ctx, revision, path)
+}
+
+// getSourceCode returns source code from the cache if available
+func (s *CoverageHandler) getSourceCode(ctx context.Context, revision, path string) ([]byte, error) {
+	if s.sources == nil {
+		return s.fetchSourceCode(ctx, revision, path)
+	}
+
+	repo, _ := base.RepoFrom(ctx)
+	key := sourceKey{repoID: repo.Id, revision: revision, path: path}
+
+	code, ok := s.sources.get(key)
+	if ok {
+		return code, nil
+	}
+
+	code, err := s.fetchSourceCode(ctx, revision, path)
+	if err != nil {
+		return nil, err
+	}
+
+	s.sources.put(key, code)
+	return code, nil
+}
+
+// limits of prewarmSourceCache not to exhaust a rate limit of a repository
+// manager by an upload
+const (
+	maxPrewarmFiles    = 100
+	prewarmConcurrency = 4
+)
+
+// changedFiles returns sorted files in cov whose blocks differ from the
+// nearest ancestor, i.e. files which are likely to be changed and viewed.
+// All files are returned when cov has no ancestor.
+func (s *CoverageHandler) changedFiles(repo base.Repository, cov *Coverage) []string {
+	ancestor, err := s.findNearestAncestor(repo, cov)
+	if err != nil {
+		log.Warn().Err(err).Msg("changedFiles")
+	}
+
+	files := map[string]bool{}
+	for _, e := range cov.Entries {
+		var prev *CoverageEntry
+		if ancestor != nil {
+			prev = ancestor.FindEntry(e.Name)
+		}
+
+		for filename, p := range e.Profiles {
+			if prev != nil {
+				if q, ok := prev.Profiles[filename]; ok && reflect.DeepEqual(p.Blocks, q.Blocks) {
+					continue
+				}
+			}
+			files[filename] = true
+		}
+	}
+
+	sorted := []string{}
+	for filename := range files {
+		sorted = append(sorted, filename)
+	}
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+// prewarmSourceCache fetches source code of files changed in cov into the
+// cache. At most maxPrewarmFiles files are fetched with prewarmConcurrency
+// requests at a time. Nothing is fetched for a mirrored repository because
+// code is read from the mirror.
+func (s *CoverageHandler) prewarmSourceCache(ctx context.Context, cov *Coverage) {
+	repo, _ := base.RepoFrom(ctx)
+	if s.mirror != nil && s.mirror.Has(repo) {
+		return
+	}
+
+	files := s.changedFiles(repo, cov)
+	if len(files) > maxPrewarmFiles {
+		files = files[:maxPrewarmFiles]
+	}
+
+	queue := make(chan string)
+	var count int64
+	var wg sync.WaitGroup
+	for i := 0; i < prewarmConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filename := range queue {
+				key := sourceKey{repoID: repo.Id, revision: cov.Revision, path: filename}
+				if s.sources.contains(key) {
+					continue
+				}
+
+				code, err := s.fetchSourceCode(ctx, cov.Revision, filename)
+				if err != nil {
+					log.Warn().Err(err).Msgf("prewarmSourceCache: %s", filename)
+					continue
+				}
+				s.sources.put(key, code)
+				atomic.AddInt64(&count, 1)
+			}
+		}()
+	}
+
+	for _, filename := range files {
+		queue <- filename
+	}
+	close(queue)
+	wg.Wait()
+
+	log.Info().Msgf("prewarmSourceCache: fetched %d files: repo.Id=%d revision=%s",
+		count, repo.Id, cov.Revision)
+}
+
+func (s *CoverageHandler) handleFile(w http.ResponseWriter, r *http.Request) {
 	log.Print("handleFile")
 	repo, _ := base.RepoFrom(r.Context())
 	cov, _ := CoverageFrom(r.Context())
@@ -283,7 +666,13 @@ func handleFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	code, err := getSourceCode(r.Context(), cov.Revision, file)
+	// profiles of a carried entry are for the revision carried from
+	revision := cov.Revision
+	if entry.CarriedFrom != "" {
+		revision = entry.CarriedFrom
+	}
+
+	code, err := s.getSourceCode(r.Context(), revision, file)
 	if err != nil {
 		log.Error().Err(err).Msg("handleFile")
 		render.NotFound(w, render.ErrNotFound)
@@ -325,6 +714,10 @@ func parseCoverageEntryUploadRequest(req *CoverageEntryUploadRequest) (*Coverage
 		return nil, errors.New("entry name is empty")
 	}
 
+	if isReservedEntryName(req.Name) {
+		return nil, fmt.Errorf("entry name %s is reserved", req.Name)
+	}
+
 	files := map[string]*profile.Profile{}
 	for _, p := range req.Profiles {
 		files[p.FileName] = p
@@ -335,6 +728,9 @@ func parseCoverageEntryUploadRequest(req *CoverageEntryUploadRequest) (*Coverage
 	entry.Profiles = files
 	entry.Hits = req.Hits
 	entry.Lines = req.Lines
+	if len(req.Tags) > 0 {
+		entry.Tags = req.Tags
+	}
 
 	return entry, nil
 }
@@ -352,6 +748,29 @@ func parseCoverageEntryUploadRequests(req []*CoverageEntryUploadRequest) ([]*Cov
 	return entries, nil
 }
 
+// removeIgnoredFiles removes files ignored by repository settings from
+// entries, and updates hits and lines of the entries.
+func removeIgnoredFiles(entries []*CoverageEntry, settings *base.RepositorySettings) {
+	for _, e := range entries {
+		removed := false
+		for filename := range e.Profiles {
+			if settings.Ignored(filename) {
+				delete(e.Profiles, filename)
+				removed = true
+			}
+		}
+
+		if removed {
+			e.Hits = 0
+			e.Lines = 0
+			for _, p := range e.Profiles {
+				e.Hits += p.Hits
+				e.Lines += p.Lines
+			}
+		}
+	}
+}
+
 func (s *CoverageHandler) HandleCoverageUpload(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
@@ -369,11 +788,18 @@ func (s *CoverageHandler) HandleCoverageUpload(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	if settings, ok := base.SettingsFrom(r.Context()); ok {
+		removeIgnoredFiles(entries, settings)
+	}
+
 	cov := &Coverage{}
 	cov.RepoID = repo.Id
 	cov.Revision = request.Revision
 	cov.Entries = entries
 	cov.Timestamp = request.Timestamp
+	cov.Branch = request.Branch
+	cov.Tag = request.Tag
+	cov.PullRequest = request.PullRequest
 
 	err = s.AddCoverage(cov)
 	if err != nil {
@@ -382,6 +808,11 @@ func (s *CoverageHandler) HandleCoverageUpload(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	if _, ok := base.RepositoryClientFrom(r.Context()); ok && s.sources != nil {
+		// keep values such as a token in the context
+		go s.prewarmSourceCache(context.WithoutCancel(r.Context()), cov)
+	}
+
 	render.JSON(w, cov, http.StatusCreated)
 }
 
@@ -389,19 +820,37 @@ func (s *CoverageHandler) Handler() http.Handler {
 	r := chi.NewRouter()
 	r.Get("/", s.handleCoverageList)
 	r.Post("/", s.HandleCoverageUpload)
+	r.Get("/latest", s.handleLatest)
+	r.Get("/history/files/*", s.handleFileHistory)
+
+	r.Get("/components/trend", s.handleComponentTrend)
 
 	r.Route("/{id}", func(r chi.Router) {
 		r.Use(s.injectCoverage)
-		r.Route("/{entry}", func(r chi.Router) {
-			r.Use(injectCoverageEntry)
-			r.Get("/files", handleFileList)
-			r.Get("/files/*", handleFile)
-		})
+		s.routeCoverage(r)
+	})
+
+	// permalinks such as /ref/main/go/files
+	r.Route("/ref/{ref}", func(r chi.Router) {
+		r.Use(s.injectCoverageByRef)
+		s.routeCoverage(r)
 	})
 
 	return r
 }
 
+// routeCoverage adds routes for a coverage injected in the context
+func (s *CoverageHandler) routeCoverage(r chi.Router) {
+	r.Get("/ancestor", s.handleAncestor)
+	r.Get("/components", s.handleCoverageComponents)
+	r.Route("/{entry}", func(r chi.Router) {
+		r.Use(s.injectCoverageEntry)
+		r.Get("/files", handleFileList)
+		r.Get("/files/*", s.handleFile)
+		r.Get("/tree", handleFileTree)
+	})
+}
+
 func newCoverageHandler(store CoverageStore) *CoverageHandler {
 	return &CoverageHandler{coverages: store}
 }
@@ -505,9 +505,9 @@ func TestCoverageHandler_AddCoverage(t *testing.T) {
 	err := handler.AddCoverage(cov)
 
 	require.NoError(t, err)
-	got, err := store.ListAll()
+	got, err := store.Find(cov.ID)
 	require.NoError(t, err)
-	assert.Equal(t, []*Coverage{cov}, got)
+	assert.Equal(t, cov, got)
 }
 
 func TestCoverageHandler_AddCoverageMerge(t *testing.T) {
@@ -595,10 +595,10 @@ func TestCoverageHandler_AddCoverageMerge(t *testing.T) {
 
 	require.NoError(t, err)
 
-	got, err := store.ListAll()
-	require.NoError(t, err)
 	want.ID = 1
-	assert.Equal(t, []*Coverage{want}, got)
+	got, err := store.Find(want.ID)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
 }
 
 func TestCoverageHandler_HandleUpload(t *testing.T) {
@@ -649,8 +649,209 @@ func TestCoverageHandler_HandleUpload(t *testing.T) {
 	s.Handler().ServeHTTP(w, req)
 
 	require.Equal(t, http.StatusCreated, w.Result().StatusCode)
-	got, err := store.ListAll()
-	require.NoError(t, err)
 	cov.ID = 1 // 1 will be assigned by the server
-	assert.Equal(t, []*Coverage{cov}, got)
+	got, err := store.Find(cov.ID)
+	require.NoError(t, err)
+	assert.Equal(t, cov, got)
+}
+
+func Test_CoverageHandler_FileHistory(t *testing.T) {
+	rm := NewMockRepositoryClient()
+	repo := base.Repository{Id: 1215, Url: "http://mock.scm/org/name"}
+
+	time0 := time.Now().Round(0)
+	makeCoverage := func(revision string, timestamp time.Time, hits int) *Coverage {
+		return &Coverage{
+			RepoID:    repo.Id,
+			Revision:  revision,
+			Timestamp: timestamp,
+			Entries: []*CoverageEntry{
+				{
+					Name:  "go",
+					Hits:  hits,
+					Lines: 10,
+					Profiles: map[string]*profile.Profile{
+						"a/handler.go": {FileName: "a/handler.go", Hits: hits, Lines: 10},
+					},
+				},
+			},
+		}
+	}
+
+	cov0 := makeCoverage("rev0", time0, 9)
+	cov1 := makeCoverage("rev1", time0.Add(time.Hour), 2)
+	store := setupCoverageStore(t, cov1, cov0)
+	s := newCoverageHandler(store)
+
+	serve := func(path string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		ctx := req.Context()
+		ctx = base.WithRepositoryClient(ctx, rm)
+		ctx = base.WithRepo(ctx, repo)
+		req = req.WithContext(ctx)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	t.Run("history", func(t *testing.T) {
+		res := serve("/history/files/a/handler.go?entry=go")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		var got FileHistoryResponse
+		err := json.NewDecoder(res.Body).Decode(&got)
+		require.NoError(t, err)
+
+		assert.Equal(t, "go", got.Entry)
+		assert.Equal(t, "a/handler.go", got.FileName)
+		require.Equal(t, 2, len(got.Revisions))
+		assert.Equal(t, "rev0", got.Revisions[0].Revision)
+		assert.Equal(t, 9, got.Revisions[0].Hits)
+		assert.Equal(t, rm.RevisionURL(repo.Url, "rev0"), got.Revisions[0].RevisionURL)
+		assert.Equal(t, "rev1", got.Revisions[1].Revision)
+		assert.Equal(t, 2, got.Revisions[1].Hits)
+	})
+
+	t.Run("no entry", func(t *testing.T) {
+		res := serve("/history/files/a/handler.go")
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+
+	t.Run("unknown file", func(t *testing.T) {
+		res := serve("/history/files/unknown.go?entry=go")
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+}
+
+func Test_CoverageHandler_CoverageList_Filter(t *testing.T) {
+	rm := NewMockRepositoryClient()
+	repo := base.Repository{Id: 1215}
+
+	now := time.Now().Round(0)
+	cov0 := &Coverage{RepoID: repo.Id, Revision: "r0", Timestamp: now, Branch: "main"}
+	cov1 := &Coverage{RepoID: repo.Id, Revision: "r1", Timestamp: now, Branch: "topic", PullRequest: 12}
+	s := newCoverageHandler(setupCoverageStore(t, cov0, cov1))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r = r.WithContext(base.WithRepo(base.WithRepositoryClient(r.Context(), rm), repo))
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/?pr=12")
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	var got CoverageListResponse
+	err := json.NewDecoder(w.Result().Body).Decode(&got)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(got.Coverages))
+	assert.Equal(t, cov1.ID, got.Coverages[0].ID)
+	assert.Equal(t, "topic", got.Coverages[0].Branch)
+	assert.Equal(t, 12, got.Coverages[0].PullRequest)
+
+	w = get("/?branch=none")
+	require.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+
+	w = get("/?pr=abc")
+	require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
+
+func Test_CoverageHandler_Latest(t *testing.T) {
+	rm := NewMockRepositoryClient()
+	repo := base.Repository{Id: 1215}
+
+	now := time.Now().Round(0)
+	cov0 := &Coverage{RepoID: repo.Id, Revision: "r0", Timestamp: now.Add(-time.Hour), Branch: "main"}
+	cov1 := &Coverage{RepoID: repo.Id, Revision: "r1", Timestamp: now, Branch: "main"}
+	cov2 := &Coverage{RepoID: repo.Id, Revision: "r2", Timestamp: now.Add(time.Hour), Branch: "topic"}
+	s := newCoverageHandler(setupCoverageStore(t, cov1, cov0, cov2))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r = r.WithContext(base.WithRepo(base.WithRepositoryClient(r.Context(), rm), repo))
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/latest?branch=main")
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	var got CoverageResponse
+	err := json.NewDecoder(w.Result().Body).Decode(&got)
+	require.NoError(t, err)
+	assert.Equal(t, cov1.ID, got.ID)
+	assert.Equal(t, "r1", got.Revision)
+
+	w = get("/latest?branch=none")
+	require.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+}
+
+func TestCoverageHandler_HandleUpload_Refs(t *testing.T) {
+	repo := base.Repository{Id: 1215}
+	store := setupCoverageStore(t)
+	s := newCoverageHandler(store)
+
+	request := &CoverageUploadRequest{
+		Revision:    "rev",
+		Timestamp:   time.Now().Round(0),
+		Branch:      "topic",
+		PullRequest: 12,
+		Entries:     []*CoverageEntryUploadRequest{{Name: "go"}},
+	}
+	body, err := json.Marshal(request)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+	req = req.WithContext(base.WithRepo(req.Context(), repo))
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Result().StatusCode)
+
+	got, err := store.FindRevision(repo.Id, "rev")
+	require.NoError(t, err)
+	assert.Equal(t, "topic", got.Branch)
+	assert.Equal(t, "", got.Tag)
+	assert.Equal(t, 12, got.PullRequest)
+}
+
+func TestCoverageHandler_HandleUpload_Ignore(t *testing.T) {
+	repo := base.Repository{Id: 1215}
+	store := setupCoverageStore(t)
+	s := newCoverageHandler(store)
+
+	request := &CoverageUploadRequest{
+		Revision:  "rev",
+		Timestamp: time.Now().Round(0),
+		Entries: []*CoverageEntryUploadRequest{{
+			Name:  "go",
+			Hits:  2,
+			Lines: 3,
+			Profiles: []*profile.Profile{
+				{FileName: "api/server.go", Hits: 1, Lines: 1, Blocks: [][]int{{1, 1, 1}}},
+				{FileName: "api/server_gen.go", Hits: 1, Lines: 2, Blocks: [][]int{{1, 1, 1}, {2, 2, 0}}},
+			},
+		}},
+	}
+	body, err := json.Marshal(request)
+	require.NoError(t, err)
+
+	settings := base.NewRepositorySettings()
+	settings.Ignore = []string{"**/*_gen.go"}
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+	req = req.WithContext(base.WithSettings(base.WithRepo(req.Context(), repo), settings))
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Result().StatusCode)
+
+	got, err := store.FindRevision(repo.Id, "rev")
+	require.NoError(t, err)
+	entry := got.FindEntry("go")
+	assert.Equal(t, 1, entry.Hits)
+	assert.Equal(t, 1, entry.Lines)
+	assert.Equal(t, 1, len(entry.Profiles))
+	assert.NotNil(t, entry.Profiles["api/server.go"])
 }
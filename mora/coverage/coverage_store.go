@@ -1,148 +1,420 @@
 package coverage
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/iszk1215/mora/mora/migration"
+	"github.com/iszk1215/mora/mora/profile"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/rs/zerolog/log"
 )
 
-var schema = `
+var schema_coverage = `
 CREATE TABLE IF NOT EXISTS coverage (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     repo_id INTEGER NOT NULL,
     revision TEXT NOT NULL,
     time DATETIME NOT NULL,
-    contents TEXT NOT NULL,
+    branch TEXT NOT NULL DEFAULT '',
+    tag TEXT NOT NULL DEFAULT '',
+    pull_request INTEGER NOT NULL DEFAULT 0,
     UNIQUE(repo_id, revision)
 )`
 
+var schema_entry = `
+CREATE TABLE IF NOT EXISTS coverage_entry (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    coverage_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    hits INTEGER NOT NULL,
+    lines INTEGER NOT NULL,
+    tags TEXT NOT NULL DEFAULT '{}',
+    UNIQUE(coverage_id, name)
+)`
+
+var schema_file = `
+CREATE TABLE IF NOT EXISTS coverage_file (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entry_id INTEGER NOT NULL,
+    filename TEXT NOT NULL,
+    hits INTEGER NOT NULL,
+    lines INTEGER NOT NULL,
+    blocks_hash TEXT NOT NULL,
+    UNIQUE(entry_id, filename)
+)`
+
+var schema_file_index = `
+CREATE INDEX IF NOT EXISTS coverage_file_filename ON coverage_file (filename)`
+
+var schema_file_hash_index = `
+CREATE INDEX IF NOT EXISTS coverage_file_blocks_hash ON coverage_file (blocks_hash)`
+
+// Blocks are shared by files which have the same blocks. Most files do not
+// change between consecutive revisions.
+var schema_blocks = `
+CREATE TABLE IF NOT EXISTS coverage_blocks (
+    hash TEXT PRIMARY KEY,
+    blocks TEXT NOT NULL
+)`
+
 type (
 	storableCoverage struct {
-		ID       int64     `db:"id"`
-		RepoID   int64     `db:"repo_id"`
-		Revision string    `db:"revision"`
-		Time     time.Time `db:"time"`
-		Contents string    `db:"contents"`
+		ID          int64     `db:"id"`
+		RepoID      int64     `db:"repo_id"`
+		Revision    string    `db:"revision"`
+		Time        time.Time `db:"time"`
+		Branch      string    `db:"branch"`
+		Tag         string    `db:"tag"`
+		PullRequest int       `db:"pull_request"`
+	}
+
+	storableEntry struct {
+		ID         int64  `db:"id"`
+		CoverageID int64  `db:"coverage_id"`
+		Name       string `db:"name"`
+		Hits       int    `db:"hits"`
+		Lines      int    `db:"lines"`
+		Tags       string `db:"tags"`
+	}
+
+	storableFile struct {
+		EntryID  int64  `db:"entry_id"`
+		FileName string `db:"filename"`
+		Hits     int    `db:"hits"`
+		Lines    int    `db:"lines"`
+		Blocks   string `db:"blocks"`
+		Hash     string `db:"blocks_hash"`
+	}
+
+	storableBlocks struct {
+		Hash   string `db:"hash"`
+		Blocks string `db:"blocks"`
+	}
+
+	storableFileRevision struct {
+		CoverageID int64     `db:"id"`
+		Revision   string    `db:"revision"`
+		Time       time.Time `db:"time"`
+		Hits       int       `db:"hits"`
+		Lines      int       `db:"lines"`
 	}
 
 	coverageStoreImpl struct {
 		db *sqlx.DB
 		sync.Mutex
-
-		selectQuery string
 	}
 )
 
 func NewCoverageStore(db *sqlx.DB) CoverageStore {
-	query := "SELECT id, repo_id, revision, time, contents FROM coverage"
-	return &coverageStoreImpl{db: db, selectQuery: query}
+	return &coverageStoreImpl{db: db}
 }
 
-/*
-// contents is serialized []CoverageEntryUploadRequest
-func parseStorableCoverageContents(contents string) ([]*CoverageEntry, error) {
-	var req []*CoverageEntryUploadRequest
+// ----------------------------------------------------------------------
+// Migration from the format where all entries of a coverage are stored as
+// a json in coverage.contents
 
-	err := json.Unmarshal([]byte(contents), &req)
-	if err != nil {
-		return nil, err
+func migrateContents(tx *sqlx.Tx) error {
+	found, err := migration.HasColumn(tx, "coverage", "contents")
+	if err != nil || !found {
+		return err
 	}
 
-	entries, err := parseCoverageEntryUploadRequests(req)
+	log.Info().Msg("Migrate coverage.contents to coverage_entry and coverage_file")
+
+	rows := []struct {
+		ID       int64  `db:"id"`
+		Contents string `db:"contents"`
+	}{}
+	err = tx.Select(&rows, "SELECT id, contents FROM coverage")
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return entries, nil
+	for _, row := range rows {
+		var entries []*CoverageEntry
+		err := json.Unmarshal([]byte(row.Contents), &entries)
+		if err != nil {
+			return err
+		}
+
+		err = insertEntries(tx, row.ID, entries)
+		if err != nil {
+			return err
+		}
+	}
+
+	queries := []string{
+		strings.Replace(schema_coverage, "coverage", "coverage_new", 1),
+		"INSERT INTO coverage_new (id, repo_id, revision, time) SELECT id, repo_id, revision, time FROM coverage",
+		"DROP TABLE coverage",
+		"ALTER TABLE coverage_new RENAME TO coverage",
+	}
+	for _, query := range queries {
+		_, err = tx.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info().Msgf("Migrated %d coverages", len(rows))
+	return nil
 }
 
-func (s *coverageStoreImpl) Migrate() error {
-	query := "SELECT id, contents FROM coverage"
-	rows := []storableCoverage{}
-	err := s.db.Select(&rows, query)
+// Migration from the format where blocks are stored in coverage_file.blocks
+
+func migrateBlocks(tx *sqlx.Tx) error {
+	found, err := migration.HasColumn(tx, "coverage_file", "blocks")
+	if err != nil || !found {
+		return err
+	}
+
+	log.Info().Msg("Migrate coverage_file.blocks to coverage_blocks")
+
+	rows := []struct {
+		ID     int64  `db:"id"`
+		Blocks string `db:"blocks"`
+	}{}
+	err = tx.Select(&rows, "SELECT id, blocks FROM coverage_file")
 	if err != nil {
 		return err
 	}
 
-	for _, row := range rows {
-		log.Print(row)
-		entries, err := parseStorableCoverageContents(row.Contents)
+	queries := []string{
+		"DROP INDEX IF EXISTS coverage_file_filename",
+		strings.Replace(schema_file, "coverage_file", "coverage_file_new", 1),
+		`INSERT INTO coverage_file_new (id, entry_id, filename, hits, lines, blocks_hash)
+SELECT id, entry_id, filename, hits, lines, '' FROM coverage_file`,
+		"DROP TABLE coverage_file",
+		"ALTER TABLE coverage_file_new RENAME TO coverage_file",
+		schema_file_index,
+	}
+	for _, query := range queries {
+		_, err = tx.Exec(query)
 		if err != nil {
 			return err
 		}
+	}
 
-		contents, err := json.Marshal(entries)
+	for _, row := range rows {
+		hash, err := insertBlocks(tx, []byte(row.Blocks))
 		if err != nil {
 			return err
 		}
-		log.Print(contents)
 
-		query = "UPDATE coverage SET contents = $1 WHERE id = $2"
-		_, err = s.db.Exec(query, contents, row.ID)
+		_, err = tx.Exec(
+			"UPDATE coverage_file SET blocks_hash = $1 WHERE id = $2", hash, row.ID)
 		if err != nil {
 			return err
 		}
 	}
+
+	log.Info().Msgf("Migrated %d files", len(rows))
 	return nil
 }
-*/
+
+// Migration to add columns
+
+// refs of a revision
+func migrateRefs(tx *sqlx.Tx) error {
+	return migration.AddColumns(tx, "coverage", []migration.Column{
+		{Name: "branch", Definition: "TEXT NOT NULL DEFAULT ''"},
+		{Name: "tag", Definition: "TEXT NOT NULL DEFAULT ''"},
+		{Name: "pull_request", Definition: "INTEGER NOT NULL DEFAULT 0"},
+	})
+}
+
+// tags of an entry
+func migrateEntryTags(tx *sqlx.Tx) error {
+	return migration.AddColumns(tx, "coverage_entry", []migration.Column{
+		{Name: "tags", Definition: "TEXT NOT NULL DEFAULT '{}'"},
+	})
+}
 
 func (s *coverageStoreImpl) Init() error {
-	_, err := s.db.Exec(schema)
-	/*
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // nolint:errcheck
+
+	schemas := []string{
+		schema_coverage, schema_entry, schema_blocks, schema_file, schema_file_index,
+	}
+	for _, schema := range schemas {
+		_, err = tx.Exec(schema)
 		if err != nil {
 			return err
 		}
-		err = s.Migrate()
-	*/
-	return err
-}
+	}
 
-func toCoverage(record storableCoverage) (*Coverage, error) {
-	var entries []*CoverageEntry
-	err := json.Unmarshal([]byte(record.Contents), &entries)
+	err = migrateContents(tx)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	err = migrateBlocks(tx)
+	if err != nil {
+		return err
+	}
+
+	err = migrateRefs(tx)
+	if err != nil {
+		return err
 	}
 
-	cov := &Coverage{}
-	cov.ID = record.ID
-	cov.RepoID = record.RepoID
-	cov.Revision = record.Revision
-	cov.Entries = entries
-	cov.Timestamp = record.Time
+	err = migrateEntryTags(tx)
+	if err != nil {
+		return err
+	}
 
-	return cov, nil
+	_, err = tx.Exec(schema_file_hash_index)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (s *coverageStoreImpl) scan(query string, params ...interface{}) ([]*Coverage, error) {
-	log.Print("query=", query)
+// ----------------------------------------------------------------------
+// Query
+
+// profileLoading is what scan loads as profiles of entries
+type profileLoading int
+
+const (
+	withoutProfiles profileLoading = iota
+	withFileTotals                 // hits and lines of files without blocks
+	withBlocks
+)
+
+// maxBlocksQueryParams is the number of hashes queried at once by FindBlocks
+// to stay under the limit of parameters of sqlite.
+const maxBlocksQueryParams = 500
+
+// scan returns coverages matched with `where` condition on table `c`.
+// Profiles are loaded as specified by loading.
+func (s *coverageStoreImpl) scan(where string, loading profileLoading, params ...interface{}) ([]*Coverage, error) {
+	log.Print("scan: where=", where)
 
 	rows := []storableCoverage{}
-	err := s.db.Select(&rows, query, params...)
+	err := s.db.Select(&rows,
+		"SELECT c.id, c.repo_id, c.revision, c.time, c.branch, c.tag, c.pull_request"+
+			" FROM coverage c"+where+" ORDER BY c.id",
+		params...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return []*Coverage{}, nil
+	}
+
+	entryRows := []storableEntry{}
+	err = s.db.Select(&entryRows,
+		"SELECT e.id, e.coverage_id, e.name, e.hits, e.lines, e.tags FROM coverage_entry e"+
+			" JOIN coverage c ON e.coverage_id = c.id"+where+" ORDER BY e.id",
+		params...)
 	if err != nil {
 		return nil, err
 	}
 
 	coverages := []*Coverage{}
+	coverageMap := map[int64]*Coverage{}
 	for _, record := range rows {
-		cov, err := toCoverage(record)
+		cov := &Coverage{
+			ID:          record.ID,
+			RepoID:      record.RepoID,
+			Revision:    record.Revision,
+			Timestamp:   record.Time,
+			Branch:      record.Branch,
+			Tag:         record.Tag,
+			PullRequest: record.PullRequest,
+			Entries:     []*CoverageEntry{},
+		}
+		coverages = append(coverages, cov)
+		coverageMap[cov.ID] = cov
+	}
+
+	entryMap := map[int64]*CoverageEntry{}
+	for _, record := range entryRows {
+		entry := &CoverageEntry{
+			Name:  record.Name,
+			Hits:  record.Hits,
+			Lines: record.Lines,
+		}
+
+		err := json.Unmarshal([]byte(record.Tags), &entry.Tags)
 		if err != nil {
 			return nil, err
 		}
+		if len(entry.Tags) == 0 {
+			entry.Tags = nil
+		}
 
-		coverages = append(coverages, cov)
+		cov := coverageMap[record.CoverageID]
+		cov.Entries = append(cov.Entries, entry)
+		entryMap[record.ID] = entry
+	}
+
+	if loading == withoutProfiles {
+		return coverages, nil
+	}
+
+	query := "SELECT f.entry_id, f.filename, f.hits, f.lines, f.blocks_hash FROM coverage_file f"
+	if loading == withBlocks {
+		query = "SELECT f.entry_id, f.filename, f.hits, f.lines, b.blocks FROM coverage_file f" +
+			" JOIN coverage_blocks b ON f.blocks_hash = b.hash"
+	}
+
+	fileRows := []storableFile{}
+	err = s.db.Select(&fileRows,
+		query+
+			" JOIN coverage_entry e ON f.entry_id = e.id"+
+			" JOIN coverage c ON e.coverage_id = c.id"+where,
+		params...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range fileRows {
+		var blocks [][]int
+		if loading == withBlocks {
+			err := json.Unmarshal([]byte(record.Blocks), &blocks)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		entry := entryMap[record.EntryID]
+		if entry.Profiles == nil {
+			entry.Profiles = map[string]*profile.Profile{}
+		}
+		entry.Profiles[record.FileName] = &profile.Profile{
+			FileName: record.FileName,
+			Hits:     record.Hits,
+			Lines:    record.Lines,
+			Blocks:   blocks,
+		}
+
+		if loading == withFileTotals {
+			if entry.blocksHashes == nil {
+				entry.blocksHashes = map[string]string{}
+			}
+			entry.blocksHashes[record.FileName] = record.Hash
+		}
 	}
 
 	return coverages, nil
 }
 
-func (s *coverageStoreImpl) findOne(query string, params ...interface{}) (*Coverage, error) {
-	coverages, err := s.scan(query, params...)
+func (s *coverageStoreImpl) findOne(where string, params ...interface{}) (*Coverage, error) {
+	coverages, err := s.scan(where, withBlocks, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -153,55 +425,293 @@ func (s *coverageStoreImpl) findOne(query string, params ...interface{}) (*Cover
 }
 
 func (s *coverageStoreImpl) Find(id int64) (*Coverage, error) {
-	return s.findOne(s.selectQuery+" WHERE id = ?", id)
+	return s.findOne(" WHERE c.id = ?", id)
 }
 
 func (s *coverageStoreImpl) FindRevision(repoID int64, revision string) (*Coverage, error) {
-	return s.findOne(
-		s.selectQuery+" WHERE repo_id = ? and revision = ?", repoID, revision)
+	return s.findOne(" WHERE c.repo_id = ? and c.revision = ?", repoID, revision)
 }
 
+// List returns coverages of a repository. Profiles of entries are not
+// loaded.
 func (s *coverageStoreImpl) List(repo_id int64) ([]*Coverage, error) {
-	return s.scan(s.selectQuery+" WHERE repo_id = ?", repo_id)
+	return s.scan(" WHERE c.repo_id = ?", withoutProfiles, repo_id)
 }
 
+// ListFileTotals returns coverages of a repository. Profiles of entries are
+// loaded without blocks, i.e. only hits and lines of each file.
+func (s *coverageStoreImpl) ListFileTotals(repoID int64) ([]*Coverage, error) {
+	return s.scan(" WHERE c.repo_id = ?", withFileTotals, repoID)
+}
+
+// FindBlocks returns blocks stored with hashes. A key of the map is a hash.
+func (s *coverageStoreImpl) FindBlocks(hashes []string) (map[string][][]int, error) {
+	ret := map[string][][]int{}
+	for len(hashes) > 0 {
+		n := len(hashes)
+		if n > maxBlocksQueryParams {
+			n = maxBlocksQueryParams
+		}
+
+		query, args, err := sqlx.In(
+			"SELECT hash, blocks FROM coverage_blocks WHERE hash IN (?)", hashes[:n])
+		if err != nil {
+			return nil, err
+		}
+
+		rows := []storableBlocks{}
+		err = s.db.Select(&rows, s.db.Rebind(query), args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range rows {
+			var blocks [][]int
+			err := json.Unmarshal([]byte(record.Blocks), &blocks)
+			if err != nil {
+				return nil, err
+			}
+			ret[record.Hash] = blocks
+		}
+
+		hashes = hashes[n:]
+	}
+
+	return ret, nil
+}
+
+func (s *coverageStoreImpl) Search(repoID int64, filter CoverageFilter) ([]*Coverage, error) {
+	where := " WHERE c.repo_id = ?"
+	params := []interface{}{repoID}
+
+	if filter.Branch != "" {
+		where += " AND c.branch = ?"
+		params = append(params, filter.Branch)
+	}
+	if filter.Tag != "" {
+		where += " AND c.tag = ?"
+		params = append(params, filter.Tag)
+	}
+	if filter.PullRequest != 0 {
+		where += " AND c.pull_request = ?"
+		params = append(params, filter.PullRequest)
+	}
+
+	return s.scan(where, withoutProfiles, params...)
+}
+
+// ListAll returns all coverages. Profiles of entries are not loaded.
 func (s *coverageStoreImpl) ListAll() ([]*Coverage, error) {
-	return s.scan(s.selectQuery)
+	return s.scan("", withoutProfiles)
 }
 
-func (s *coverageStoreImpl) Put(cov *Coverage) error {
-	contents, err := json.Marshal(cov.Entries)
+func (s *coverageStoreImpl) ListFileRevisions(repoID int64, entry string, filename string) ([]*FileRevision, error) {
+	query := `SELECT c.id, c.revision, c.time, f.hits, f.lines FROM coverage c
+JOIN coverage_entry e ON e.coverage_id = c.id
+JOIN coverage_file f ON f.entry_id = e.id
+WHERE c.repo_id = ? AND e.name = ? AND f.filename = ?
+ORDER BY c.time`
+
+	rows := []storableFileRevision{}
+	err := s.db.Select(&rows, query, repoID, entry, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := []*FileRevision{}
+	for _, record := range rows {
+		revisions = append(revisions, &FileRevision{
+			CoverageID: record.CoverageID,
+			Revision:   record.Revision,
+			Timestamp:  record.Time,
+			Hits:       record.Hits,
+			Lines:      record.Lines,
+		})
+	}
+
+	return revisions, nil
+}
+
+// ----------------------------------------------------------------------
+// Update
+
+// insertBlocks stores blocks unless the same blocks have been stored, and
+// returns its hash.
+func insertBlocks(tx *sqlx.Tx, blocks []byte) (string, error) {
+	sum := sha256.Sum256(blocks)
+	hash := hex.EncodeToString(sum[:])
+
+	_, err := tx.Exec(
+		"INSERT OR IGNORE INTO coverage_blocks (hash, blocks) VALUES ($1, $2)",
+		hash, blocks)
+	if err != nil {
+		return "", err
+	}
+
+	return hash, nil
+}
+
+func insertEntries(tx *sqlx.Tx, coverageID int64, entries []*CoverageEntry) error {
+	for _, e := range entries {
+		tags, err := json.Marshal(e.Tags)
+		if err != nil {
+			return err
+		}
+		if e.Tags == nil {
+			tags = []byte("{}")
+		}
+
+		res, err := tx.Exec(
+			"INSERT INTO coverage_entry (coverage_id, name, hits, lines, tags) VALUES ($1, $2, $3, $4, $5)",
+			coverageID, e.Name, e.Hits, e.Lines, string(tags))
+		if err != nil {
+			return err
+		}
+
+		entryID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		for _, p := range e.Profiles {
+			blocks, err := json.Marshal(p.Blocks)
+			if err != nil {
+				return err
+			}
+
+			hash, err := insertBlocks(tx, blocks)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(
+				"INSERT INTO coverage_file (entry_id, filename, hits, lines, blocks_hash) VALUES ($1, $2, $3, $4, $5)",
+				entryID, p.FileName, p.Hits, p.Lines, hash)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func deleteEntries(tx *sqlx.Tx, coverageID int64) error {
+	_, err := tx.Exec(
+		"DELETE FROM coverage_file WHERE entry_id IN (SELECT id FROM coverage_entry WHERE coverage_id = $1)",
+		coverageID)
 	if err != nil {
 		return err
 	}
 
-	// s.Lock()
-	// defer s.Unlock()
+	_, err = tx.Exec("DELETE FROM coverage_entry WHERE coverage_id = $1", coverageID)
+	return err
+}
+
+func (s *coverageStoreImpl) Put(cov *Coverage) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // nolint:errcheck
 
 	rows := []int64{}
-	err = s.db.Select(&rows,
+	err = tx.Select(&rows,
 		"SELECT id FROM coverage WHERE repo_id = $1 and revision = $2",
 		cov.RepoID, cov.Revision)
 	if err != nil {
 		return err
 	}
 
+	var id int64
 	if len(rows) == 0 { // insert
 		log.Print("Insert")
-		res, err := s.db.Exec(
-			"INSERT INTO coverage (repo_id, revision, time, contents) VALUES ($1, $2, $3, $4)",
-			cov.RepoID, cov.Revision, cov.Timestamp, contents)
+		res, err := tx.Exec(`INSERT INTO coverage
+(repo_id, revision, time, branch, tag, pull_request) VALUES ($1, $2, $3, $4, $5, $6)`,
+			cov.RepoID, cov.Revision, cov.Timestamp, cov.Branch, cov.Tag, cov.PullRequest)
 		if err != nil {
 			return err
 		}
 
-		cov.ID, err = res.LastInsertId()
-		// log.Print("Assing id=", cov.ID)
-		return err
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
 	} else { // update
+		// blocks no longer referenced are left. See Compact.
 		log.Print("Update")
-		_, err = s.db.Exec(
-			"UPDATE coverage SET contents = $1 WHERE id = $2", contents, rows[0])
+		id = rows[0]
+		_, err = tx.Exec(
+			"UPDATE coverage SET branch = $1, tag = $2, pull_request = $3 WHERE id = $4",
+			cov.Branch, cov.Tag, cov.PullRequest, id)
+		if err != nil {
+			return err
+		}
+
+		err = deleteEntries(tx, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertEntries(tx, id, cov.Entries)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if len(rows) == 0 {
+		cov.ID = id
+	}
+	return nil
+}
+
+// Delete removes a coverage with its entries, files and blocks used only by
+// the coverage.
+func (s *coverageStoreImpl) Delete(id int64) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // nolint:errcheck
+
+	hashes := []string{}
+	err = tx.Select(&hashes, `SELECT DISTINCT f.blocks_hash FROM coverage_file f
+JOIN coverage_entry e ON f.entry_id = e.id WHERE e.coverage_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	err = deleteEntries(tx, id)
+	if err != nil {
 		return err
 	}
+
+	_, err = tx.Exec("DELETE FROM coverage WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	const chunkSize = 500
+	for len(hashes) > 0 {
+		n := min(chunkSize, len(hashes))
+		query, args, err := sqlx.In(`DELETE FROM coverage_blocks WHERE hash IN (?)
+AND NOT EXISTS (SELECT 1 FROM coverage_file f WHERE f.blocks_hash = coverage_blocks.hash)`,
+			hashes[:n])
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		hashes = hashes[n:]
+	}
+
+	return tx.Commit()
 }
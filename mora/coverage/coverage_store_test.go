@@ -1,9 +1,11 @@
 package coverage
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
+	"github.com/iszk1215/mora/mora/profile"
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/require"
 )
@@ -149,3 +151,472 @@ func TestCoverageStore_Put_Update(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, int64(0), want.ID)
 }
+
+func TestCoverageStore_ListFileRevisions(t *testing.T) {
+	s := initCoverageStore(t)
+
+	time0 := time.Now().Round(0)
+	time1 := time0.Add(-24 * time.Hour)
+	time2 := time0.Add(24 * time.Hour)
+
+	makeCoverage := func(revision string, timestamp time.Time, entry string, hits int) *Coverage {
+		return &Coverage{
+			RepoID:    1215,
+			Revision:  revision,
+			Timestamp: timestamp,
+			Entries: []*CoverageEntry{
+				{
+					Name:  entry,
+					Hits:  hits,
+					Lines: 10,
+					Profiles: map[string]*profile.Profile{
+						"a.go": {FileName: "a.go", Hits: hits, Lines: 10},
+					},
+				},
+			},
+		}
+	}
+
+	cov0 := makeCoverage("rev0", time0, "go", 5)
+	cov1 := makeCoverage("rev1", time1, "go", 3)
+	cov2 := makeCoverage("rev2", time2, "cc", 7) // other entry
+	for _, cov := range []*Coverage{cov0, cov1, cov2} {
+		require.NoError(t, s.Put(cov))
+	}
+
+	got, err := s.ListFileRevisions(1215, "go", "a.go")
+	require.NoError(t, err)
+
+	want := []*FileRevision{
+		{CoverageID: cov1.ID, Revision: "rev1", Timestamp: time1, Hits: 3, Lines: 10},
+		{CoverageID: cov0.ID, Revision: "rev0", Timestamp: time0, Hits: 5, Lines: 10},
+	}
+	require.Equal(t, want, got)
+
+	got, err = s.ListFileRevisions(1215, "go", "unknown.go")
+	require.NoError(t, err)
+	require.Empty(t, got)
+}
+
+func TestCoverageStore_List_WithoutProfiles(t *testing.T) {
+	s := initCoverageStore(t)
+
+	cov := &Coverage{
+		RepoID:    1215,
+		Revision:  "abcde",
+		Timestamp: time.Now().Round(0),
+		Entries: []*CoverageEntry{
+			{
+				Name:  "go",
+				Hits:  3,
+				Lines: 4,
+				Profiles: map[string]*profile.Profile{
+					"a.go": {FileName: "a.go", Hits: 3, Lines: 4,
+						Blocks: [][]int{{1, 3, 1}, {4, 4, 0}}},
+				},
+			},
+		},
+	}
+	require.NoError(t, s.Put(cov))
+
+	want := []*Coverage{
+		{
+			ID:        cov.ID,
+			RepoID:    cov.RepoID,
+			Revision:  cov.Revision,
+			Timestamp: cov.Timestamp,
+			Entries:   []*CoverageEntry{{Name: "go", Hits: 3, Lines: 4}},
+		},
+	}
+
+	got, err := s.List(cov.RepoID)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	got, err = s.ListAll()
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+}
+
+func TestCoverageStore_Put_UpdateEntries(t *testing.T) {
+	s := initCoverageStore(t)
+
+	cov := &Coverage{
+		RepoID:    1215,
+		Revision:  "abcde",
+		Timestamp: time.Now().Round(0),
+		Entries:   []*CoverageEntry{{Name: "go", Hits: 1, Lines: 2}},
+	}
+	require.NoError(t, s.Put(cov))
+
+	cov.Entries = []*CoverageEntry{{Name: "cc", Hits: 3, Lines: 4}}
+	require.NoError(t, s.Put(cov))
+
+	got, err := s.Find(cov.ID)
+	require.NoError(t, err)
+	require.Equal(t, cov, got)
+}
+
+func TestCoverageStore_MigrateContents(t *testing.T) {
+	db, err := sqlx.Connect("sqlite3", ":memory:?_loc=auto")
+	require.NoError(t, err)
+
+	legacy := `
+CREATE TABLE coverage (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    repo_id INTEGER NOT NULL,
+    revision TEXT NOT NULL,
+    time DATETIME NOT NULL,
+    contents TEXT NOT NULL,
+    UNIQUE(repo_id, revision)
+)`
+	_, err = db.Exec(legacy)
+	require.NoError(t, err)
+
+	want := &Coverage{
+		ID:        1,
+		RepoID:    1215,
+		Revision:  "abcde",
+		Timestamp: time.Now().Round(0),
+		Entries: []*CoverageEntry{
+			{
+				Name:  "go",
+				Hits:  3,
+				Lines: 4,
+				Profiles: map[string]*profile.Profile{
+					"a.go": {FileName: "a.go", Hits: 3, Lines: 4,
+						Blocks: [][]int{{1, 3, 1}, {4, 4, 0}}},
+				},
+			},
+			{
+				Name:  "cc",
+				Hits:  0,
+				Lines: 1,
+			},
+		},
+	}
+
+	contents, err := json.Marshal(want.Entries)
+	require.NoError(t, err)
+	_, err = db.Exec(
+		"INSERT INTO coverage (repo_id, revision, time, contents) VALUES ($1, $2, $3, $4)",
+		want.RepoID, want.Revision, want.Timestamp, contents)
+	require.NoError(t, err)
+
+	s := NewCoverageStore(db)
+	require.NoError(t, s.Init())
+
+	got, err := s.Find(want.ID)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	// Init again is no-op
+	require.NoError(t, s.Init())
+
+	// new coverage can be inserted after migration
+	cov := &Coverage{RepoID: 1215, Revision: "012345", Timestamp: time.Now().Round(0)}
+	require.NoError(t, s.Put(cov))
+	require.Equal(t, int64(2), cov.ID)
+}
+
+func countRows(t *testing.T, s CoverageStore, table string) int {
+	var count int
+	err := s.(*coverageStoreImpl).db.Get(&count, "SELECT COUNT(*) FROM "+table)
+	require.NoError(t, err)
+	return count
+}
+
+func TestCoverageStore_Put_SharesBlocks(t *testing.T) {
+	s := initCoverageStore(t)
+
+	makeCoverage := func(revision string, blocks [][]int) *Coverage {
+		return &Coverage{
+			RepoID:    1215,
+			Revision:  revision,
+			Timestamp: time.Now().Round(0),
+			Entries: []*CoverageEntry{
+				{
+					Name:  "go",
+					Hits:  3,
+					Lines: 4,
+					Profiles: map[string]*profile.Profile{
+						"a.go": {FileName: "a.go", Hits: 3, Lines: 4, Blocks: blocks},
+						"b.go": {FileName: "b.go", Hits: 1, Lines: 1, Blocks: [][]int{{1, 1, 1}}},
+					},
+				},
+			},
+		}
+	}
+
+	cov0 := makeCoverage("rev0", [][]int{{1, 3, 1}, {4, 4, 0}})
+	cov1 := makeCoverage("rev1", [][]int{{1, 3, 1}, {4, 4, 0}})
+	cov2 := makeCoverage("rev2", [][]int{{1, 3, 2}, {4, 4, 0}})
+	for _, cov := range []*Coverage{cov0, cov1, cov2} {
+		require.NoError(t, s.Put(cov))
+	}
+
+	require.Equal(t, 6, countRows(t, s, "coverage_file"))
+	require.Equal(t, 3, countRows(t, s, "coverage_blocks"))
+
+	for _, want := range []*Coverage{cov0, cov1, cov2} {
+		got, err := s.Find(want.ID)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+}
+
+func TestCoverageStore_FindBlocks(t *testing.T) {
+	s := initCoverageStore(t)
+
+	blocks := [][]int{{1, 3, 1}, {4, 4, 0}}
+	cov := &Coverage{
+		RepoID:    1215,
+		Revision:  "rev",
+		Timestamp: time.Now().Round(0),
+		Entries: []*CoverageEntry{
+			{
+				Name:  "go",
+				Hits:  3,
+				Lines: 4,
+				Profiles: map[string]*profile.Profile{
+					"a.go": {FileName: "a.go", Hits: 3, Lines: 4, Blocks: blocks},
+				},
+			},
+		},
+	}
+	require.NoError(t, s.Put(cov))
+
+	coverages, err := s.ListFileTotals(cov.RepoID)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(coverages))
+	hash := coverages[0].Entries[0].blocksHashes["a.go"]
+	require.NotEmpty(t, hash)
+
+	got, err := s.FindBlocks([]string{hash, "unknown"})
+	require.NoError(t, err)
+	require.Equal(t, map[string][][]int{hash: blocks}, got)
+}
+
+func TestCoverageStore_MigrateBlocks(t *testing.T) {
+	db, err := sqlx.Connect("sqlite3", ":memory:?_loc=auto")
+	require.NoError(t, err)
+
+	// format where blocks are stored in coverage_file
+	legacy := []string{
+		schema_coverage,
+		schema_entry,
+		`CREATE TABLE coverage_file (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entry_id INTEGER NOT NULL,
+    filename TEXT NOT NULL,
+    hits INTEGER NOT NULL,
+    lines INTEGER NOT NULL,
+    blocks TEXT NOT NULL,
+    UNIQUE(entry_id, filename)
+)`,
+		"CREATE INDEX coverage_file_filename ON coverage_file (filename)",
+	}
+	for _, query := range legacy {
+		_, err = db.Exec(query)
+		require.NoError(t, err)
+	}
+
+	now := time.Now().Round(0)
+	for i, revision := range []string{"rev0", "rev1"} {
+		_, err = db.Exec("INSERT INTO coverage (repo_id, revision, time) VALUES (1215, $1, $2)",
+			revision, now)
+		require.NoError(t, err)
+		_, err = db.Exec("INSERT INTO coverage_entry (coverage_id, name, hits, lines) VALUES ($1, 'go', 3, 4)",
+			i+1)
+		require.NoError(t, err)
+		_, err = db.Exec("INSERT INTO coverage_file (entry_id, filename, hits, lines, blocks) VALUES ($1, 'a.go', 3, 4, '[[1,3,1],[4,4,0]]')",
+			i+1)
+		require.NoError(t, err)
+	}
+
+	s := NewCoverageStore(db)
+	require.NoError(t, s.Init())
+	require.Equal(t, 1, countRows(t, s, "coverage_blocks"))
+
+	got, err := s.Find(2)
+	require.NoError(t, err)
+	want := &Coverage{
+		ID:        2,
+		RepoID:    1215,
+		Revision:  "rev1",
+		Timestamp: now,
+		Entries: []*CoverageEntry{
+			{
+				Name:  "go",
+				Hits:  3,
+				Lines: 4,
+				Profiles: map[string]*profile.Profile{
+					"a.go": {FileName: "a.go", Hits: 3, Lines: 4,
+						Blocks: [][]int{{1, 3, 1}, {4, 4, 0}}},
+				},
+			},
+		},
+	}
+	require.Equal(t, want, got)
+}
+
+func TestCoverageStore_Delete(t *testing.T) {
+	s := initCoverageStore(t)
+
+	makeCoverage := func(revision string, blocks [][]int) *Coverage {
+		return &Coverage{
+			RepoID:    1215,
+			Revision:  revision,
+			Timestamp: time.Now().Round(0),
+			Entries: []*CoverageEntry{
+				{
+					Name: "go",
+					Profiles: map[string]*profile.Profile{
+						"a.go": {FileName: "a.go", Blocks: [][]int{{1, 1, 1}}},
+						"b.go": {FileName: "b.go", Blocks: blocks},
+					},
+				},
+			},
+		}
+	}
+
+	cov0 := makeCoverage("rev0", [][]int{{1, 1, 0}})
+	cov1 := makeCoverage("rev1", [][]int{{1, 1, 2}})
+	require.NoError(t, s.Put(cov0))
+	require.NoError(t, s.Put(cov1))
+	require.Equal(t, 3, countRows(t, s, "coverage_blocks"))
+
+	require.NoError(t, s.Delete(cov0.ID))
+
+	got, err := s.Find(cov0.ID)
+	require.NoError(t, err)
+	require.Nil(t, got)
+
+	// blocks shared with cov1 are kept
+	require.Equal(t, 1, countRows(t, s, "coverage_entry"))
+	require.Equal(t, 2, countRows(t, s, "coverage_file"))
+	require.Equal(t, 2, countRows(t, s, "coverage_blocks"))
+
+	got, err = s.Find(cov1.ID)
+	require.NoError(t, err)
+	require.Equal(t, cov1, got)
+}
+
+func TestCoverageStore_Search(t *testing.T) {
+	now := time.Now().Round(0)
+	main0 := &Coverage{RepoID: 1, Revision: "r0", Timestamp: now, Branch: "main"}
+	main1 := &Coverage{RepoID: 1, Revision: "r1", Timestamp: now, Branch: "main", Tag: "v1.0"}
+	pr := &Coverage{RepoID: 1, Revision: "r2", Timestamp: now, Branch: "topic", PullRequest: 123}
+	other := &Coverage{RepoID: 2, Revision: "r3", Timestamp: now, Branch: "main"}
+	s := setupCoverageStore(t, main0, main1, pr, other)
+
+	ids := func(filter CoverageFilter) []int64 {
+		coverages, err := s.Search(1, filter)
+		require.NoError(t, err)
+		ret := []int64{}
+		for _, cov := range coverages {
+			ret = append(ret, cov.ID)
+		}
+		return ret
+	}
+
+	require.Equal(t, []int64{main0.ID, main1.ID, pr.ID}, ids(CoverageFilter{}))
+	require.Equal(t, []int64{main0.ID, main1.ID}, ids(CoverageFilter{Branch: "main"}))
+	require.Equal(t, []int64{main1.ID}, ids(CoverageFilter{Tag: "v1.0"}))
+	require.Equal(t, []int64{pr.ID}, ids(CoverageFilter{PullRequest: 123}))
+	require.Equal(t, []int64{}, ids(CoverageFilter{Branch: "main", PullRequest: 123}))
+
+	got, err := s.Find(pr.ID)
+	require.NoError(t, err)
+	require.Equal(t, "topic", got.Branch)
+	require.Equal(t, 123, got.PullRequest)
+}
+
+func TestCoverageStore_Put_UpdateRefs(t *testing.T) {
+	now := time.Now().Round(0)
+	cov := &Coverage{RepoID: 1, Revision: "r0", Timestamp: now}
+	s := setupCoverageStore(t, cov)
+
+	err := s.Put(&Coverage{RepoID: 1, Revision: "r0", Timestamp: now, Tag: "v1.0"})
+	require.NoError(t, err)
+
+	got, err := s.Find(cov.ID)
+	require.NoError(t, err)
+	require.Equal(t, "v1.0", got.Tag)
+}
+
+func TestCoverageStore_MigrateRefs(t *testing.T) {
+	db, err := sqlx.Connect("sqlite3", ":memory:?_loc=auto")
+	require.NoError(t, err)
+
+	// format without refs
+	_, err = db.Exec(`CREATE TABLE coverage (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    repo_id INTEGER NOT NULL,
+    revision TEXT NOT NULL,
+    time DATETIME NOT NULL,
+    UNIQUE(repo_id, revision)
+)`)
+	require.NoError(t, err)
+
+	now := time.Now().Round(0)
+	_, err = db.Exec("INSERT INTO coverage (repo_id, revision, time) VALUES (1215, 'rev0', $1)", now)
+	require.NoError(t, err)
+
+	s := NewCoverageStore(db)
+	require.NoError(t, s.Init())
+
+	got, err := s.Find(1)
+	require.NoError(t, err)
+	require.Equal(t, &Coverage{ID: 1, RepoID: 1215, Revision: "rev0", Timestamp: now,
+		Entries: []*CoverageEntry{}}, got)
+
+	require.NoError(t, s.Put(&Coverage{RepoID: 1215, Revision: "rev1", Timestamp: now, Branch: "main"}))
+	coverages, err := s.Search(1215, CoverageFilter{Branch: "main"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(coverages))
+}
+
+func TestCoverageStore_Put_EntryTags(t *testing.T) {
+	cov := &Coverage{RepoID: 1215, Revision: "r0", Timestamp: time.Now().Round(0),
+		Entries: []*CoverageEntry{
+			{Name: "linux", Tags: map[string]string{"os": "linux", "suite": "unit"}},
+			{Name: "untagged"},
+		}}
+	s := setupCoverageStore(t, cov)
+
+	got, err := s.Find(cov.ID)
+	require.NoError(t, err)
+	require.Equal(t, cov, got)
+
+	coverages, err := s.List(1215)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"os": "linux", "suite": "unit"}, coverages[0].FindEntry("linux").Tags)
+}
+
+func TestCoverageStore_MigrateEntryTags(t *testing.T) {
+	db, err := sqlx.Connect("sqlite3", ":memory:?_loc=auto")
+	require.NoError(t, err)
+
+	// format without tags
+	_, err = db.Exec(`CREATE TABLE coverage_entry (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    coverage_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    hits INTEGER NOT NULL,
+    lines INTEGER NOT NULL,
+    UNIQUE(coverage_id, name)
+)`)
+	require.NoError(t, err)
+
+	s := NewCoverageStore(db)
+	require.NoError(t, s.Init())
+
+	cov := &Coverage{RepoID: 1215, Revision: "r0", Timestamp: time.Now().Round(0),
+		Entries: []*CoverageEntry{{Name: "go", Tags: map[string]string{"os": "linux"}}}}
+	require.NoError(t, s.Put(cov))
+
+	got, err := s.Find(cov.ID)
+	require.NoError(t, err)
+	require.Equal(t, cov, got)
+}
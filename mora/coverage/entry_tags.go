@@ -0,0 +1,103 @@
+package coverage
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/iszk1215/mora/mora/base"
+)
+
+// GroupEntryName is a name of an entry synthesized from entries matched with
+// tags given by `entry_tag` query parameters.
+const GroupEntryName = "_group"
+
+func isReservedEntryName(name string) bool {
+	return name == CombinedEntryName || name == GroupEntryName
+}
+
+// parseEntryTags parses tags in a form of "key=value".
+func parseEntryTags(values []string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid entry tag: %q", v)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+// parseEntryTagFilter parses `entry_tag` query parameters such as
+// `?entry_tag=os=linux&entry_tag=suite=integration`.
+func parseEntryTagFilter(r *http.Request) (map[string]string, error) {
+	return parseEntryTags(r.URL.Query()["entry_tag"])
+}
+
+// MatchTags returns true if the entry has all tags.
+func (e *CoverageEntry) MatchTags(tags map[string]string) bool {
+	for k, v := range tags {
+		if value, ok := e.Tags[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+func matchEntries(entries []*CoverageEntry, tags map[string]string) []*CoverageEntry {
+	matched := []*CoverageEntry{}
+	for _, e := range entries {
+		if e.MatchTags(tags) {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+// makeGroupEntry names an entry combined from entries matched with tags as
+// the group entry.
+func makeGroupEntry(combined *CoverageEntry, tags map[string]string) *CoverageEntry {
+	combined.Name = GroupEntryName
+	combined.Tags = tags
+	return combined
+}
+
+// groupCoverages replaces entries of each coverage with entries matched with
+// tags and their group entry. Coverages without matched entries are removed.
+// Coverages have to be listed by listCoverages.
+func (s *CoverageHandler) groupCoverages(coverages []*Coverage, tags map[string]string) ([]*Coverage, error) {
+	union := newFileUnion(s.coverages)
+	ret := []*Coverage{}
+	for _, cov := range coverages {
+		matched := matchEntries(cov.Entries, tags)
+		if len(matched) == 0 {
+			continue
+		}
+
+		combined, err := union.combine(matched)
+		if err != nil {
+			return nil, err
+		}
+		cov.Entries = append(matched, makeGroupEntry(combined, tags))
+		ret = append(ret, cov)
+	}
+
+	return ret, nil
+}
+
+// findGroupEntry returns the group entry of cov with profiles, or nil if no
+// entry matches with tags.
+func (s *CoverageHandler) findGroupEntry(repo base.Repository, cov *Coverage, tags map[string]string) (*CoverageEntry, error) {
+	carried, err := s.carryForwardTo(repo, cov)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := matchEntries(carried.Entries, tags)
+	if len(matched) == 0 {
+		return nil, nil
+	}
+
+	return makeGroupEntry(combineEntries(matched), tags), nil
+}
@@ -0,0 +1,158 @@
+package coverage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseEntryTags(t *testing.T) {
+	got, err := parseEntryTags([]string{"os=linux", "suite=", "arch=x86=64"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"os": "linux", "suite": "", "arch": "x86=64"}, got)
+
+	_, err = parseEntryTags([]string{"linux"})
+	assert.Error(t, err)
+
+	_, err = parseEntryTags([]string{"=linux"})
+	assert.Error(t, err)
+}
+
+func TestCoverageEntry_MatchTags(t *testing.T) {
+	e := &CoverageEntry{Name: "go", Tags: map[string]string{"os": "linux", "suite": "unit"}}
+
+	assert.True(t, e.MatchTags(nil))
+	assert.True(t, e.MatchTags(map[string]string{"os": "linux"}))
+	assert.True(t, e.MatchTags(map[string]string{"os": "linux", "suite": "unit"}))
+	assert.False(t, e.MatchTags(map[string]string{"os": "windows"}))
+	assert.False(t, e.MatchTags(map[string]string{"arch": "arm64"}))
+	assert.False(t, (&CoverageEntry{Name: "go"}).MatchTags(map[string]string{"os": "linux"}))
+}
+
+func Test_CoverageHandler_EntryTags(t *testing.T) {
+	rm := NewMockRepositoryClient()
+	repo := base.Repository{Id: 1215}
+	now := time.Now().Round(0)
+
+	a := &profile.Profile{FileName: "a.go", Hits: 1, Lines: 2, Blocks: [][]int{{1, 1, 1}, {2, 2, 0}}}
+	b := &profile.Profile{FileName: "a.go", Hits: 1, Lines: 2, Blocks: [][]int{{1, 1, 0}, {2, 2, 1}}}
+	c := &profile.Profile{FileName: "b.go", Hits: 0, Lines: 1, Blocks: [][]int{{1, 1, 0}}}
+
+	linuxUnit := makeEntry("linux-unit", a)
+	linuxUnit.Tags = map[string]string{"os": "linux", "suite": "unit"}
+	linuxInteg := makeEntry("linux-integ", b)
+	linuxInteg.Tags = map[string]string{"os": "linux", "suite": "integ"}
+	windowsUnit := makeEntry("windows-unit", c)
+	windowsUnit.Tags = map[string]string{"os": "windows", "suite": "unit"}
+
+	cov0 := &Coverage{RepoID: repo.Id, Revision: "r0", Timestamp: now,
+		Entries: []*CoverageEntry{linuxUnit, linuxInteg, windowsUnit}}
+	// linux-integ is carried forward from r0
+	cov1 := &Coverage{RepoID: repo.Id, Revision: "r1", Timestamp: now.Add(time.Hour),
+		Entries: []*CoverageEntry{linuxUnit, windowsUnit}}
+	s := newCoverageHandler(setupCoverageStore(t, cov0, cov1))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r = r.WithContext(base.WithRepo(base.WithRepositoryClient(r.Context(), rm), repo))
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("list", func(t *testing.T) {
+		w := get("/?entry_tag=os=linux")
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+		var got CoverageListResponse
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&got))
+		require.Equal(t, 2, len(got.Coverages))
+
+		entries := got.Coverages[1].Entries
+		require.Equal(t, 3, len(entries))
+		assert.Equal(t, "linux-unit", entries[0].Name)
+		assert.Equal(t, "linux-integ", entries[1].Name)
+		assert.Equal(t, "r0", entries[1].CarriedFrom)
+		// linux-unit and linux-integ hit disjoint lines
+		assert.Equal(t, &CoverageEntry{Name: GroupEntryName, Hits: 2, Lines: 2,
+			Tags: map[string]string{"os": "linux"}}, entries[2])
+	})
+
+	t.Run("list without match", func(t *testing.T) {
+		w := get("/?entry_tag=os=darwin")
+		require.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+	})
+
+	t.Run("malformed tag", func(t *testing.T) {
+		w := get("/?entry_tag=linux")
+		require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+	})
+
+	t.Run("latest", func(t *testing.T) {
+		w := get("/latest?entry_tag=suite=unit")
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+		var got CoverageResponse
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&got))
+		assert.Equal(t, cov1.ID, got.ID)
+		group := got.Entries[len(got.Entries)-1]
+		assert.Equal(t, GroupEntryName, group.Name)
+		assert.Equal(t, 1, group.Hits)
+		assert.Equal(t, 3, group.Lines)
+	})
+
+	t.Run("group files", func(t *testing.T) {
+		w := get(fmt.Sprintf("/%d/%s/files?entry_tag=os=linux", cov1.ID, GroupEntryName))
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+		var got FileListResponse
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&got))
+		assert.Equal(t, []*FileResponse{{FileName: "a.go", Hits: 2, Lines: 2}}, got.Files)
+	})
+
+	t.Run("group without match", func(t *testing.T) {
+		w := get(fmt.Sprintf("/%d/%s/files?entry_tag=os=darwin", cov1.ID, GroupEntryName))
+		require.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+	})
+}
+
+func TestCoverageHandler_HandleUpload_EntryTags(t *testing.T) {
+	repo := base.Repository{Id: 1215}
+	store := setupCoverageStore(t)
+	s := newCoverageHandler(store)
+
+	upload := func(name string) int {
+		request := &CoverageUploadRequest{
+			Revision:  "rev",
+			Timestamp: time.Now(),
+			Entries: []*CoverageEntryUploadRequest{
+				{Name: name, Tags: map[string]string{"os": "linux"}},
+			},
+		}
+		body, err := json.Marshal(request)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+		req = req.WithContext(base.WithRepo(req.Context(), repo))
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w.Result().StatusCode
+	}
+
+	require.Equal(t, http.StatusBadRequest, upload(GroupEntryName))
+	require.Equal(t, http.StatusCreated, upload("go"))
+
+	got, err := store.FindRevision(repo.Id, "rev")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, map[string]string{"os": "linux"}, got.FindEntry("go").Tags)
+}
@@ -0,0 +1,145 @@
+package coverage
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// StorageStats describes how blocks of file profiles are stored.
+	StorageStats struct {
+		Files        int   // number of files referenced by coverages
+		Blocks       int   // number of stored blocks
+		LogicalBytes int64 // size of blocks when each file has its own copy
+		StoredBytes  int64 // size of stored blocks
+	}
+
+	// CompactReport is a result of Compact.
+	CompactReport struct {
+		Stats         StorageStats
+		RemovedBlocks int64 // number of blocks referenced by no file
+		SizeBefore    int64 // database size before compaction
+		SizeAfter     int64 // database size after compaction
+	}
+)
+
+// Reclaimed returns size saved by sharing blocks between files.
+func (s StorageStats) Reclaimed() int64 {
+	return s.LogicalBytes - s.StoredBytes
+}
+
+func storageStats(db *sqlx.DB) (StorageStats, error) {
+	stats := StorageStats{}
+
+	err := db.QueryRowx(`SELECT COUNT(*), COALESCE(SUM(LENGTH(b.blocks)), 0)
+FROM coverage_file f JOIN coverage_blocks b ON f.blocks_hash = b.hash`).
+		Scan(&stats.Files, &stats.LogicalBytes)
+	if err != nil {
+		return stats, err
+	}
+
+	err = db.QueryRowx(
+		"SELECT COUNT(*), COALESCE(SUM(LENGTH(blocks)), 0) FROM coverage_blocks").
+		Scan(&stats.Blocks, &stats.StoredBytes)
+	return stats, err
+}
+
+func databaseSize(db *sqlx.DB) (int64, error) {
+	var size int64
+	err := db.Get(&size,
+		"SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()")
+	return size, err
+}
+
+// removeUnusedBlocks removes blocks which are referenced by no file.
+func removeUnusedBlocks(tx *sqlx.Tx) (int64, error) {
+	res, err := tx.Exec(`DELETE FROM coverage_blocks WHERE NOT EXISTS
+(SELECT 1 FROM coverage_file f WHERE f.blocks_hash = coverage_blocks.hash)`)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Compact removes unused blocks and vacuums the database. Store has to be
+// initialized, i.e. migrated, before calling Compact.
+func Compact(db *sqlx.DB) (*CompactReport, error) {
+	report := &CompactReport{}
+
+	var err error
+	report.SizeBefore, err = databaseSize(db)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() // nolint:errcheck
+
+	report.RemovedBlocks, err = removeUnusedBlocks(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("Compact: removed %d unused blocks", report.RemovedBlocks)
+
+	_, err = db.Exec("VACUUM")
+	if err != nil {
+		return nil, err
+	}
+
+	report.SizeAfter, err = databaseSize(db)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Stats, err = storageStats(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// DeleteRepository removes all coverages of a repository in tx, and returns
+// the number of removed coverages. Blocks used only by the coverages are
+// also removed.
+func DeleteRepository(tx *sqlx.Tx, repoID int64) (int64, error) {
+	_, err := tx.Exec(`DELETE FROM coverage_file WHERE entry_id IN
+(SELECT e.id FROM coverage_entry e JOIN coverage c ON e.coverage_id = c.id WHERE c.repo_id = $1)`,
+		repoID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(
+		"DELETE FROM coverage_entry WHERE coverage_id IN (SELECT id FROM coverage WHERE repo_id = $1)",
+		repoID)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec("DELETE FROM coverage WHERE repo_id = $1", repoID)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = removeUnusedBlocks(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
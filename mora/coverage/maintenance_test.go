@@ -0,0 +1,93 @@
+package coverage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iszk1215/mora/mora/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompact(t *testing.T) {
+	s := initCoverageStore(t)
+	db := s.(*coverageStoreImpl).db
+
+	cov := &Coverage{
+		RepoID:    1215,
+		Revision:  "rev0",
+		Timestamp: time.Now().Round(0),
+		Entries: []*CoverageEntry{
+			{
+				Name: "go",
+				Profiles: map[string]*profile.Profile{
+					"a.go": {FileName: "a.go", Blocks: [][]int{{1, 3, 1}}},
+					"b.go": {FileName: "b.go", Blocks: [][]int{{1, 3, 1}}},
+				},
+			},
+		},
+	}
+	require.NoError(t, s.Put(cov))
+
+	// replace blocks of a.go and b.go. Old blocks are no longer used.
+	cov.Entries[0].Profiles["a.go"].Blocks = [][]int{{1, 3, 0}}
+	cov.Entries[0].Profiles["b.go"].Blocks = [][]int{{1, 3, 0}}
+	require.NoError(t, s.Put(cov))
+	require.Equal(t, 2, countRows(t, s, "coverage_blocks"))
+
+	report, err := Compact(db)
+	require.NoError(t, err)
+
+	require.Equal(t, int64(1), report.RemovedBlocks)
+	require.Equal(t, 1, countRows(t, s, "coverage_blocks"))
+
+	blockSize := int64(len("[[1,3,0]]"))
+	want := StorageStats{
+		Files:        2,
+		Blocks:       1,
+		LogicalBytes: 2 * blockSize,
+		StoredBytes:  blockSize,
+	}
+	require.Equal(t, want, report.Stats)
+	require.Equal(t, blockSize, report.Stats.Reclaimed())
+}
+
+func TestDeleteRepository(t *testing.T) {
+	s := initCoverageStore(t)
+	db := s.(*coverageStoreImpl).db
+
+	makeCoverage := func(repoID int64, revision string, blocks [][]int) *Coverage {
+		return &Coverage{
+			RepoID:    repoID,
+			Revision:  revision,
+			Timestamp: time.Now().Round(0),
+			Entries: []*CoverageEntry{{
+				Name: "go",
+				Profiles: map[string]*profile.Profile{
+					"a.go": {FileName: "a.go", Blocks: blocks},
+				},
+			}},
+		}
+	}
+
+	shared := [][]int{{1, 3, 1}}
+	require.NoError(t, s.Put(makeCoverage(1, "rev0", shared)))
+	require.NoError(t, s.Put(makeCoverage(1, "rev1", [][]int{{1, 3, 0}})))
+	require.NoError(t, s.Put(makeCoverage(2, "rev0", shared)))
+	require.Equal(t, 2, countRows(t, s, "coverage_blocks"))
+
+	tx, err := db.Beginx()
+	require.NoError(t, err)
+	count, err := DeleteRepository(tx, 1)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	require.Equal(t, int64(2), count)
+	require.Equal(t, 1, countRows(t, s, "coverage"))
+	require.Equal(t, 1, countRows(t, s, "coverage_entry"))
+	require.Equal(t, 1, countRows(t, s, "coverage_file"))
+	require.Equal(t, 1, countRows(t, s, "coverage_blocks"))
+
+	got, err := s.FindRevision(2, "rev0")
+	require.NoError(t, err)
+	require.Equal(t, shared, got.Entries[0].Profiles["a.go"].Blocks)
+}
@@ -0,0 +1,143 @@
+package coverage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	"github.com/drone/go-scm/scm"
+	"github.com/go-chi/chi/v5"
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/render"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	errRefNotFound = errors.New("ref not found")
+
+	// errReloginRequired is returned when a repository manager rejects a
+	// token of a user.
+	errReloginRequired = errors.New("re-login required")
+
+	commitSHA = regexp.MustCompile("^[0-9a-f]{40}$")
+)
+
+// resolveRef resolves a branch or a tag to a commit SHA in the mirror if
+// available, otherwise with the git references API of a repository manager.
+// A commit SHA is returned as it is.
+func (s *CoverageHandler) resolveRef(ctx context.Context, ref string) (string, error) {
+	rm, _ := base.RepositoryClientFrom(ctx)
+	repo, _ := base.RepoFrom(ctx)
+
+	if s.mirror != nil && s.mirror.Has(repo) {
+		revision, err := s.mirror.ResolveRef(repo, ref)
+		if err == nil {
+			return revision, nil
+		}
+		// ref may not be fetched yet
+		log.Warn().Err(err).Msgf("resolveRef: mirror: %s", ref)
+	}
+
+	client := rm.Client()
+	repoPath := repo.Namespace + "/" + repo.Name
+
+	if client.Git != nil {
+		branch, res, err := client.Git.FindBranch(ctx, repoPath, ref)
+		if err == nil {
+			return branch.Sha, nil
+		} else if err = refLookupError(res, err); err != nil {
+			return "", err
+		}
+
+		tag, res, err := client.Git.FindTag(ctx, repoPath, ref)
+		if err == nil {
+			return tag.Sha, nil
+		} else if err = refLookupError(res, err); err != nil {
+			return "", err
+		}
+	}
+
+	if commitSHA.MatchString(ref) {
+		return ref, nil
+	}
+
+	return "", errRefNotFound
+}
+
+// refLookupError returns nil when a lookup of a ref fails because the ref
+// is not found, or not supported by a repository manager, so that the next
+// lookup is tried. errReloginRequired is returned when a token is rejected.
+func refLookupError(res *scm.Response, err error) error {
+	switch {
+	case errors.Is(err, scm.ErrNotFound), errors.Is(err, scm.ErrNotSupported):
+		return nil
+	case res != nil && res.Status == http.StatusNotFound:
+		return nil
+	case res != nil && res.Status == http.StatusUnauthorized:
+		return errReloginRequired
+	}
+	return err
+}
+
+// findCoverageByRef returns a coverage of a revision which ref points to.
+// When the revision has no coverage, because CI is still running for
+// example, a coverage of the nearest ancestor is returned if the repository
+// is mirrored.
+func (s *CoverageHandler) findCoverageByRef(ctx context.Context, ref string) (*Coverage, error) {
+	repo, _ := base.RepoFrom(ctx)
+
+	revision, err := s.resolveRef(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	log.Print("findCoverageByRef: ref=", ref, " revision=", revision)
+
+	cov, err := s.coverages.FindRevision(repo.Id, revision)
+	if err != nil || cov != nil {
+		return cov, err
+	}
+
+	if s.mirror == nil || !s.mirror.Has(repo) {
+		return nil, nil
+	}
+
+	coverages, err := s.coverages.List(repo.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.findNearestAncestorInMirror(repo, revision, coverages)
+}
+
+func (s *CoverageHandler) injectCoverageByRef(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a ref including slashes is given as escaped
+		ref, err := url.PathUnescape(chi.URLParam(r, "ref"))
+		if err != nil {
+			render.BadRequest(w, err)
+			return
+		}
+
+		cov, err := s.findCoverageByRef(r.Context(), ref)
+		if errors.Is(err, errRefNotFound) {
+			render.NotFound(w, err)
+			return
+		} else if errors.Is(err, errReloginRequired) {
+			render.Unauthorized(w, err)
+			return
+		} else if err != nil {
+			log.Error().Err(err).Msg("injectCoverageByRef")
+			render.InternalError(w, err)
+			return
+		}
+
+		if cov == nil {
+			render.NotFound(w, render.ErrNotFound)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(withCoverage(r.Context(), cov)))
+	})
+}
@@ -0,0 +1,163 @@
+package coverage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/drone/go-scm/scm"
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGitService resolves branches and tags of "org/repo" with maps. A
+// lookup of a name in statuses fails with the status.
+type fakeGitService struct {
+	scm.GitService
+	branches map[string]string
+	tags     map[string]string
+	statuses map[string]int
+}
+
+func (s *fakeGitService) findRef(refs map[string]string, repo, name string) (*scm.Reference, *scm.Response, error) {
+	if status, ok := s.statuses[name]; ok {
+		return nil, &scm.Response{Status: status}, errors.New(http.StatusText(status))
+	}
+
+	sha, ok := refs[name]
+	if repo != "org/repo" || !ok {
+		return nil, nil, scm.ErrNotFound
+	}
+	return &scm.Reference{Name: name, Sha: sha}, nil, nil
+}
+
+func (s *fakeGitService) FindBranch(ctx context.Context, repo, name string) (*scm.Reference, *scm.Response, error) {
+	return s.findRef(s.branches, repo, name)
+}
+
+func (s *fakeGitService) FindTag(ctx context.Context, repo, name string) (*scm.Reference, *scm.Response, error) {
+	return s.findRef(s.tags, repo, name)
+}
+
+func Test_CoverageHandler_Ref(t *testing.T) {
+	repo := base.Repository{Id: 1215, Namespace: "org", Name: "repo"}
+	sha0 := "0000000000000000000000000000000000000000"
+	sha1 := "1111111111111111111111111111111111111111"
+
+	rm := NewMockRepositoryClient()
+	rm.client.Git = &fakeGitService{
+		branches: map[string]string{"main": sha1, "feature/x": sha0, "nocov": "abc"},
+		tags:     map[string]string{"v1.0": sha0},
+		statuses: map[string]int{
+			"gone":    http.StatusNotFound,
+			"revoked": http.StatusUnauthorized,
+			"broken":  http.StatusBadGateway,
+		},
+	}
+
+	prof := &profile.Profile{FileName: "a.go", Hits: 1, Lines: 1, Blocks: [][]int{{1, 1, 1}}}
+	now := time.Now().Round(0)
+	cov0 := &Coverage{RepoID: repo.Id, Revision: sha0, Timestamp: now,
+		Entries: []*CoverageEntry{{Name: "go", Hits: 1, Lines: 1,
+			Profiles: map[string]*profile.Profile{"a.go": prof}}}}
+	cov1 := &Coverage{RepoID: repo.Id, Revision: sha1, Timestamp: now,
+		Entries: []*CoverageEntry{{Name: "go", Hits: 1, Lines: 1,
+			Profiles: map[string]*profile.Profile{"a.go": prof}}}}
+
+	s := newCoverageHandler(setupCoverageStore(t, cov0, cov1))
+
+	testCases := []struct {
+		ref      string
+		status   int
+		revision string
+	}{
+		{"main", http.StatusOK, sha1},
+		{"v1.0", http.StatusOK, sha0},
+		{"feature%2Fx", http.StatusOK, sha0},
+		{sha1, http.StatusOK, sha1},
+		{"unknown", http.StatusNotFound, ""},
+		{"nocov", http.StatusNotFound, ""},
+		{"gone", http.StatusNotFound, ""},
+		{"revoked", http.StatusUnauthorized, ""},
+		{"broken", http.StatusInternalServerError, ""},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/ref/"+tc.ref+"/go/files", nil)
+		ctx := base.WithRepo(base.WithRepositoryClient(req.Context(), rm), repo)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req.WithContext(ctx))
+
+		require.Equal(t, tc.status, w.Result().StatusCode, tc.ref)
+		if tc.status != http.StatusOK {
+			continue
+		}
+
+		var got FileListResponse
+		err := json.NewDecoder(w.Result().Body).Decode(&got)
+		require.NoError(t, err)
+		assert.Equal(t, tc.revision, got.Metadata.Revision, tc.ref)
+		assert.Equal(t, 1, len(got.Files))
+	}
+}
+
+func Test_CoverageHandler_Ref_Ancestor(t *testing.T) {
+	repo := base.Repository{Id: 1215, Namespace: "org", Name: "repo"}
+	m, c := setupMirror(t, &repo)
+
+	rm := NewMockRepositoryClient()
+	rm.client.Git = &fakeGitService{branches: map[string]string{"main": c[3].Revision}}
+
+	// c[3] has no coverage yet
+	s := newCoverageHandler(setupCoverageStore(t, c[0], c[2]))
+
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/ref/main/ancestor", nil)
+		ctx := base.WithRepo(base.WithRepositoryClient(req.Context(), rm), repo)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req.WithContext(ctx))
+		return w
+	}
+
+	require.Equal(t, http.StatusNotFound, get().Result().StatusCode)
+
+	s.mirror = m
+	cov, err := s.findCoverageByRef(
+		base.WithRepo(base.WithRepositoryClient(context.Background(), rm), repo), "main")
+	require.NoError(t, err)
+	assert.Equal(t, c[2].ID, cov.ID)
+
+	// ancestor of c[2]
+	w := get()
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	var got CoverageResponse
+	require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&got))
+	assert.Equal(t, c[0].ID, got.ID)
+}
+
+func Test_CoverageHandler_Ref_Mirror(t *testing.T) {
+	repo := base.Repository{Id: 1215, Namespace: "org", Name: "repo"}
+	m, c := setupMirror(t, &repo)
+
+	// refs are not resolved by the repository manager
+	rm := NewMockRepositoryClient()
+	rm.client.Git = &fakeGitService{}
+
+	s := newCoverageHandler(setupCoverageStore(t, c[1]))
+	s.mirror = m
+
+	ctx := base.WithRepo(base.WithRepositoryClient(context.Background(), rm), repo)
+	cov, err := s.findCoverageByRef(ctx, "topic")
+	require.NoError(t, err)
+	require.NotNil(t, cov)
+	assert.Equal(t, c[1].ID, cov.ID)
+
+	_, err = s.findCoverageByRef(ctx, "unknown")
+	assert.ErrorIs(t, err, errRefNotFound)
+}
@@ -0,0 +1,153 @@
+package coverage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	ThinDaily  = "daily"
+	ThinWeekly = "weekly"
+)
+
+type (
+	// RetentionPolicy decides which coverages are pruned. All coverages newer
+	// than KeepDays are kept. Older coverages are thinned to the latest one
+	// per day or per week for each repository. Coverages of KeepRevisions and
+	// tagged coverages are always kept.
+	RetentionPolicy struct {
+		KeepDays      int
+		Thin          string
+		KeepRevisions []string
+	}
+)
+
+func (p RetentionPolicy) Enabled() bool {
+	return p.KeepDays > 0
+}
+
+func (p RetentionPolicy) Validate() error {
+	if p.KeepDays < 0 {
+		return fmt.Errorf("retention: keep_days is negative: %d", p.KeepDays)
+	}
+
+	if p.Thin != ThinDaily && p.Thin != ThinWeekly {
+		return fmt.Errorf("retention: unknown thin: %q", p.Thin)
+	}
+
+	return nil
+}
+
+func (p RetentionPolicy) bucket(t time.Time) string {
+	t = t.UTC()
+	if p.Thin == ThinWeekly {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+	return t.Format("2006-01-02")
+}
+
+func (p RetentionPolicy) keep(cov *Coverage) bool {
+	if cov.Tag != "" {
+		return true
+	}
+
+	for _, rev := range p.KeepRevisions {
+		if rev == cov.Revision {
+			return true
+		}
+	}
+	return false
+}
+
+// selectPrunable returns coverages to be pruned at `now`.
+func (p RetentionPolicy) selectPrunable(coverages []*Coverage, now time.Time) []*Coverage {
+	if !p.Enabled() {
+		return nil
+	}
+
+	sorted := make([]*Coverage, len(coverages))
+	copy(sorted, coverages)
+	sort.SliceStable(sorted, func(i, j int) bool { // newest first
+		return sorted[i].Timestamp.After(sorted[j].Timestamp)
+	})
+
+	limit := now.Add(-time.Duration(p.KeepDays) * 24 * time.Hour)
+
+	type key struct {
+		repoID int64
+		bucket string
+	}
+	seen := map[key]bool{}
+
+	prunable := []*Coverage{}
+	for _, cov := range sorted {
+		if cov.Timestamp.After(limit) {
+			continue
+		}
+
+		k := key{cov.RepoID, p.bucket(cov.Timestamp)}
+		if !seen[k] {
+			seen[k] = true // latest in the bucket
+			continue
+		}
+
+		if p.keep(cov) {
+			continue
+		}
+
+		prunable = append(prunable, cov)
+	}
+
+	return prunable
+}
+
+// Prune removes coverages according to the policy, and returns removed
+// coverages. When dryRun is true, coverages are not removed.
+func Prune(store CoverageStore, policy RetentionPolicy, now time.Time, dryRun bool) ([]*Coverage, error) {
+	coverages, err := store.ListAll()
+	if err != nil {
+		return nil, err
+	}
+
+	prunable := policy.selectPrunable(coverages, now)
+	if dryRun {
+		return prunable, nil
+	}
+
+	for _, cov := range prunable {
+		log.Info().Msgf("Prune coverage: id=%d repo_id=%d revision=%s",
+			cov.ID, cov.RepoID, cov.Revision)
+		err := store.Delete(cov.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return prunable, nil
+}
+
+// RunPruner prunes coverages periodically until ctx is done.
+func RunPruner(ctx context.Context, store CoverageStore, policy RetentionPolicy, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		pruned, err := Prune(store, policy, time.Now(), false)
+		if err != nil {
+			log.Error().Err(err).Msg("RunPruner")
+		} else {
+			log.Info().Msgf("RunPruner: pruned %d coverages", len(pruned))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
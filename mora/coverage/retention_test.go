@@ -0,0 +1,90 @@
+package coverage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionPolicy_Validate(t *testing.T) {
+	require.NoError(t, RetentionPolicy{KeepDays: 30, Thin: ThinDaily}.Validate())
+	require.NoError(t, RetentionPolicy{KeepDays: 30, Thin: ThinWeekly}.Validate())
+	require.Error(t, RetentionPolicy{KeepDays: 30, Thin: "monthly"}.Validate())
+	require.Error(t, RetentionPolicy{KeepDays: -1, Thin: ThinDaily}.Validate())
+}
+
+func TestRetentionPolicy_selectPrunable(t *testing.T) {
+	now := time.Date(2024, 3, 20, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	recent0 := &Coverage{ID: 1, RepoID: 1, Revision: "r1", Timestamp: now.Add(-1 * day)}
+	recent1 := &Coverage{ID: 2, RepoID: 1, Revision: "r2", Timestamp: now.Add(-1*day - time.Hour)}
+	old0 := &Coverage{ID: 3, RepoID: 1, Revision: "r3", Timestamp: now.Add(-10 * day)}
+	old1 := &Coverage{ID: 4, RepoID: 1, Revision: "r4", Timestamp: now.Add(-10*day - time.Hour)}
+	old2 := &Coverage{ID: 5, RepoID: 1, Revision: "r5", Timestamp: now.Add(-11 * day)}
+	other := &Coverage{ID: 6, RepoID: 2, Revision: "r6", Timestamp: now.Add(-10*day - 2*time.Hour)}
+	coverages := []*Coverage{recent0, recent1, old0, old1, old2, other}
+
+	t.Run("disabled", func(t *testing.T) {
+		policy := RetentionPolicy{}
+		assert.Empty(t, policy.selectPrunable(coverages, now))
+	})
+
+	t.Run("daily", func(t *testing.T) {
+		policy := RetentionPolicy{KeepDays: 7, Thin: ThinDaily}
+		got := policy.selectPrunable(coverages, now)
+		assert.Equal(t, []*Coverage{old1}, got)
+	})
+
+	t.Run("weekly", func(t *testing.T) {
+		// 2024-03-09 and 2024-03-10 are in the same ISO week, and 2024-03-03
+		// is in the previous week.
+		policy := RetentionPolicy{KeepDays: 7, Thin: ThinWeekly}
+		old3 := &Coverage{ID: 7, RepoID: 1, Revision: "r7", Timestamp: now.Add(-17 * day)}
+		got := policy.selectPrunable(append(coverages, old3), now)
+		assert.Equal(t, []*Coverage{old1, old2}, got)
+	})
+
+	t.Run("keep revisions", func(t *testing.T) {
+		policy := RetentionPolicy{KeepDays: 7, Thin: ThinDaily, KeepRevisions: []string{"r4"}}
+		assert.Empty(t, policy.selectPrunable(coverages, now))
+	})
+
+	t.Run("keep tagged", func(t *testing.T) {
+		policy := RetentionPolicy{KeepDays: 7, Thin: ThinDaily}
+		tagged := &Coverage{ID: 8, RepoID: 1, Revision: "r8", Tag: "v1.0",
+			Timestamp: now.Add(-10*day - 2*time.Hour)}
+		got := policy.selectPrunable(append(coverages, tagged), now)
+		assert.Equal(t, []*Coverage{old1}, got)
+	})
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Now().Round(0)
+	day := 24 * time.Hour
+
+	cov0 := &Coverage{RepoID: 1, Revision: "r0", Timestamp: now.Add(-10 * day)}
+	cov1 := &Coverage{RepoID: 1, Revision: "r1", Timestamp: now.Add(-10*day - time.Minute)}
+	store := setupCoverageStore(t, cov0, cov1)
+
+	policy := RetentionPolicy{KeepDays: 7, Thin: ThinDaily}
+
+	pruned, err := Prune(store, policy, now, true)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(pruned))
+	require.Equal(t, cov1.ID, pruned[0].ID)
+
+	got, err := store.ListAll()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(got)) // dry run
+
+	_, err = Prune(store, policy, now, false)
+	require.NoError(t, err)
+
+	got, err = store.ListAll()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(got))
+	require.Equal(t, cov0.ID, got[0].ID)
+}
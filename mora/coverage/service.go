@@ -1,25 +1,70 @@
 package coverage
 
 import (
+	"context"
 	"net/http"
+	"time"
 
+	"github.com/iszk1215/mora/mora/mirror"
 	"github.com/jmoiron/sqlx"
 )
 
 type (
+	ServiceConfig struct {
+		// Maximum number of files in the source code cache in memory
+		SourceCacheSize int
+
+		// Directory of the on-disk source code cache. Disabled if empty.
+		SourceCacheDir string
+
+		// Maximum total size in bytes of the on-disk source code cache.
+		// Unlimited if zero.
+		SourceCacheDiskSize int64
+
+		// Mirrors of repositories used as a source of code. Disabled if nil.
+		Mirror *mirror.Mirror
+	}
+
 	CoverageService struct {
+		store   CoverageStore
 		handler *CoverageHandler
 	}
 )
 
-func NewCoverageService(db *sqlx.DB) (*CoverageService, error) {
+func NewCoverageService(db *sqlx.DB, config ServiceConfig) (*CoverageService, error) {
 
 	store := NewCoverageStore(db)
 	if err := store.Init(); err != nil {
 		return nil, err
 	}
 
-	return &CoverageService{handler: newCoverageHandler(store)}, nil
+	handler := newCoverageHandler(store)
+	handler.mirror = config.Mirror
+
+	if config.SourceCacheSize > 0 {
+		sources, err := newSourceCache(config.SourceCacheSize, config.SourceCacheDir,
+			config.SourceCacheDiskSize)
+		if err != nil {
+			return nil, err
+		}
+		handler.sources = sources
+	}
+
+	return &CoverageService{store: store, handler: handler}, nil
+}
+
+// RunPruner prunes coverages periodically according to the policy until ctx
+// is done.
+func (s *CoverageService) RunPruner(ctx context.Context, policy RetentionPolicy, interval time.Duration) {
+	RunPruner(ctx, s.store, policy, interval)
+}
+
+// RemoveCachedSources removes cached source code of a repository.
+func (s *CoverageService) RemoveCachedSources(repoID int64) error {
+	if s.handler.sources == nil {
+		return nil
+	}
+	return s.handler.sources.removeRepository(repoID)
 }
 
 func (s *CoverageService) Handler() http.Handler {
@@ -0,0 +1,320 @@
+package coverage
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	sourceKey struct {
+		repoID   int64
+		revision string
+		path     string
+	}
+
+	sourceCacheItem struct {
+		key  sourceKey
+		code []byte
+	}
+
+	// sourceCache is a LRU cache of source code. Because a revision is
+	// immutable, cached code never becomes stale. When dir is not empty, code
+	// is also stored in dir and survives restart. Files in dir are removed
+	// from the least recently used when their total size exceeds maxBytes
+	// until it is under the low watermark.
+	sourceCache struct {
+		maxEntries int
+		dir        string
+		maxBytes   int64
+
+		lock  sync.Mutex
+		lru   *list.List
+		items map[sourceKey]*list.Element
+
+		diskLock  sync.Mutex
+		diskFiles map[string]*sourceCacheFile // [filename]
+		diskBytes int64                       // total size of files in dir
+	}
+
+	sourceCacheFile struct {
+		name    string
+		size    int64
+		modTime time.Time // used as access time
+	}
+)
+
+// sourceCacheLowWatermark is a ratio of maxBytes to which eviction reduces
+// the total size of files, so that eviction does not run on every put.
+const sourceCacheLowWatermark = 0.9
+
+func newSourceCache(maxEntries int, dir string, maxBytes int64) (*sourceCache, error) {
+	c := &sourceCache{
+		maxEntries: maxEntries,
+		dir:        dir,
+		maxBytes:   maxBytes,
+		lru:        list.New(),
+		items:      map[sourceKey]*list.Element{},
+		diskFiles:  map[string]*sourceCacheFile{},
+	}
+
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+
+		if err := c.loadDiskFiles(); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// loadDiskFiles indexes files in the on-disk store. Files are walked only
+// on start, and the index is kept up to date by put and eviction.
+func (c *sourceCache) loadDiskFiles() error {
+	return filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		c.addDiskFile(&sourceCacheFile{path, info.Size(), info.ModTime()})
+		return nil
+	})
+}
+
+// addDiskFile adds f to the index. The size of an overwritten file is
+// replaced. Has to be called with diskLock.
+func (c *sourceCache) addDiskFile(f *sourceCacheFile) {
+	c.removeDiskFile(f.name)
+	c.diskFiles[f.name] = f
+	c.diskBytes += f.size
+}
+
+// removeDiskFile removes a file from the index. Has to be called with
+// diskLock.
+func (c *sourceCache) removeDiskFile(name string) {
+	if old, ok := c.diskFiles[name]; ok {
+		c.diskBytes -= old.size
+		delete(c.diskFiles, name)
+	}
+}
+
+// evictDisk removes files in the on-disk store from the least recently used
+// until the total size is under the low watermark. Has to be called with
+// diskLock.
+func (c *sourceCache) evictDisk() {
+	files := []*sourceCacheFile{}
+	for _, f := range c.diskFiles {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	low := int64(float64(c.maxBytes) * sourceCacheLowWatermark)
+	removed := 0
+	for _, f := range files {
+		if c.diskBytes <= low {
+			break
+		}
+		err := os.Remove(f.name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Warn().Err(err).Msg("sourceCache.evictDisk")
+			continue
+		}
+		c.removeDiskFile(f.name)
+		removed++
+	}
+
+	log.Info().Msgf("sourceCache.evictDisk: removed %d files", removed)
+}
+
+// sourceCacheRepoDir returns a directory of files of a repository in the
+// on-disk store.
+func sourceCacheRepoDir(dir string, repoID int64) string {
+	return filepath.Join(dir, strconv.FormatInt(repoID, 10))
+}
+
+// filename returns a filename in the on-disk store. The key is hashed not to
+// use a path given by a client as a filename.
+func (c *sourceCache) filename(key sourceKey) string {
+	sum := sha256.Sum256(
+		[]byte(fmt.Sprintf("%d\x00%s\x00%s", key.repoID, key.revision, key.path)))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(sourceCacheRepoDir(c.dir, key.repoID), name[:2], name)
+}
+
+func (c *sourceCache) getMemory(key sourceKey) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*sourceCacheItem).code, true
+}
+
+func (c *sourceCache) putMemory(key sourceKey, code []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.lru.PushFront(&sourceCacheItem{key: key, code: code})
+
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*sourceCacheItem).key)
+	}
+}
+
+func (c *sourceCache) get(key sourceKey) ([]byte, bool) {
+	code, ok := c.getMemory(key)
+	if ok || c.dir == "" {
+		return code, ok
+	}
+
+	filename := c.filename(key)
+	code, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, false
+	}
+
+	// modification time is used as access time for eviction, which is
+	// restored on restart
+	now := time.Now()
+	_ = os.Chtimes(filename, now, now)
+
+	c.diskLock.Lock()
+	if f, ok := c.diskFiles[filename]; ok {
+		f.modTime = now
+	}
+	c.diskLock.Unlock()
+
+	c.putMemory(key, code)
+	return code, true
+}
+
+// writeFile writes code via a temporary file not to leave a partial file.
+func writeFile(filename string, code []byte) error {
+	err := os.MkdirAll(filepath.Dir(filename), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(code)
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+func (c *sourceCache) put(key sourceKey, code []byte) {
+	c.putMemory(key, code)
+
+	if c.dir == "" {
+		return
+	}
+
+	filename := c.filename(key)
+	err := writeFile(filename, code)
+	if err != nil {
+		log.Warn().Err(err).Msg("sourceCache.put")
+		return
+	}
+
+	c.diskLock.Lock()
+	defer c.diskLock.Unlock()
+
+	c.addDiskFile(&sourceCacheFile{filename, int64(len(code)), time.Now()})
+	if c.maxBytes > 0 && c.diskBytes > c.maxBytes {
+		c.evictDisk()
+	}
+}
+
+func (c *sourceCache) contains(key sourceKey) bool {
+	if _, ok := c.getMemory(key); ok {
+		return true
+	}
+
+	if c.dir == "" {
+		return false
+	}
+
+	_, err := os.Stat(c.filename(key))
+	return err == nil
+}
+
+// removeRepository removes cached code of a repository.
+func (c *sourceCache) removeRepository(repoID int64) error {
+	c.lock.Lock()
+	for key, elem := range c.items {
+		if key.repoID == repoID {
+			c.lru.Remove(elem)
+			delete(c.items, key)
+		}
+	}
+	c.lock.Unlock()
+
+	if c.dir == "" {
+		return nil
+	}
+
+	c.diskLock.Lock()
+	defer c.diskLock.Unlock()
+
+	err := RemoveCachedSources(c.dir, repoID)
+	if err != nil {
+		return err
+	}
+
+	prefix := sourceCacheRepoDir(c.dir, repoID) + string(filepath.Separator)
+	for name := range c.diskFiles {
+		if strings.HasPrefix(name, prefix) {
+			c.removeDiskFile(name)
+		}
+	}
+
+	return nil
+}
+
+// RemoveCachedSources removes files of a repository in the on-disk source
+// code cache in dir.
+func RemoveCachedSources(dir string, repoID int64) error {
+	return os.RemoveAll(sourceCacheRepoDir(dir, repoID))
+}
@@ -0,0 +1,347 @@
+package coverage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/drone/go-scm/scm"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/golang/mock/gomock"
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/mirror"
+	"github.com/iszk1215/mora/mora/mockscm"
+	"github.com/iszk1215/mora/mora/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_sourceCache_LRU(t *testing.T) {
+	c, err := newSourceCache(2, "", 0)
+	require.NoError(t, err)
+
+	key0 := sourceKey{1, "rev", "a.go"}
+	key1 := sourceKey{1, "rev", "b.go"}
+	key2 := sourceKey{2, "rev", "a.go"}
+
+	c.put(key0, []byte("a"))
+	c.put(key1, []byte("b"))
+
+	_, ok := c.get(key0) // key1 is the oldest
+	require.True(t, ok)
+
+	c.put(key2, []byte("c"))
+
+	_, ok = c.get(key1)
+	assert.False(t, ok)
+
+	code, ok := c.get(key0)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("a"), code)
+
+	code, ok = c.get(key2)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("c"), code)
+}
+
+func Test_sourceCache_Disk(t *testing.T) {
+	dir := t.TempDir()
+	key0 := sourceKey{1, "rev", "a.go"}
+	key1 := sourceKey{1, "rev", "../b.go"}
+
+	c, err := newSourceCache(1, dir, 0)
+	require.NoError(t, err)
+	c.put(key0, []byte("a"))
+	c.put(key1, []byte("b"))
+
+	// new cache, i.e. restarted server, reads the disk
+	c, err = newSourceCache(1, dir, 0)
+	require.NoError(t, err)
+	assert.True(t, c.contains(key0))
+
+	code, ok := c.get(key0)
+	require.True(t, ok)
+	assert.Equal(t, []byte("a"), code)
+
+	code, ok = c.get(key1)
+	require.True(t, ok)
+	assert.Equal(t, []byte("b"), code)
+
+	assert.False(t, c.contains(sourceKey{1, "rev", "c.go"}))
+}
+
+func Test_sourceCache_DiskSize(t *testing.T) {
+	dir := t.TempDir()
+	key0 := sourceKey{1, "rev", "a.go"}
+	key1 := sourceKey{1, "rev", "b.go"}
+	key2 := sourceKey{1, "rev", "c.go"}
+
+	c, err := newSourceCache(1, dir, 10)
+	require.NoError(t, err)
+
+	c.put(key0, []byte("aaaa"))
+	c.put(key1, []byte("bbbb"))
+
+	// overwritten file is counted once
+	c.put(key1, []byte("bbbb"))
+	assert.Equal(t, int64(8), c.diskBytes)
+
+	// key0 is used recently
+	old := time.Now().Add(-time.Hour)
+	c.diskFiles[c.filename(key1)].modTime = old
+	require.NoError(t, os.Chtimes(c.filename(key1), old, old))
+
+	// evicted until under 9 bytes, i.e. the low watermark
+	c.put(key2, []byte("cccc"))
+	assert.Equal(t, int64(8), c.diskBytes)
+
+	assert.True(t, c.contains(key0))
+	assert.False(t, c.contains(key1))
+	assert.True(t, c.contains(key2))
+
+	// size is restored on restart
+	c, err = newSourceCache(1, dir, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), c.diskBytes)
+	assert.Equal(t, 2, len(c.diskFiles))
+}
+
+func Test_sourceCache_RemoveRepository(t *testing.T) {
+	dir := t.TempDir()
+	key0 := sourceKey{1, "rev", "a.go"}
+	key1 := sourceKey{2, "rev", "a.go"}
+
+	c, err := newSourceCache(2, dir, 0)
+	require.NoError(t, err)
+	c.put(key0, []byte("aaaa"))
+	c.put(key1, []byte("bb"))
+
+	require.NoError(t, c.removeRepository(1))
+	assert.False(t, c.contains(key0))
+	assert.True(t, c.contains(key1))
+	assert.Equal(t, int64(2), c.diskBytes)
+}
+
+func Test_CoverageHandler_File_Cached(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	filename := "a.go"
+	revision := "rev"
+	repo := base.Repository{Id: 1215, Namespace: "org", Name: "repo"}
+
+	contents := mockscm.NewMockContentService(mockCtrl)
+	contents.EXPECT().Find(gomock.Any(), "org/repo", filename, revision).
+		Return(&scm.Content{Data: []byte("code")}, nil, nil).Times(1)
+
+	rm := NewMockRepositoryClient()
+	rm.client.Contents = contents
+
+	cov := &Coverage{
+		RepoID:    repo.Id,
+		Revision:  revision,
+		Timestamp: time.Now().Round(0),
+		Entries: []*CoverageEntry{
+			{
+				Name: "go",
+				Profiles: map[string]*profile.Profile{
+					filename: {FileName: filename, Blocks: [][]int{{1, 1, 1}}},
+				},
+			},
+		},
+	}
+
+	store := setupCoverageStore(t, cov)
+	s := newCoverageHandler(store)
+	s.sources, _ = newSourceCache(10, "", 0)
+
+	for i := 0; i < 2; i++ {
+		path := fmt.Sprintf("/%d/go/files/%s", cov.ID, filename)
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		ctx := base.WithRepo(base.WithRepositoryClient(req.Context(), rm), repo)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req.WithContext(ctx))
+
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+		var got CodeResponse
+		err := json.NewDecoder(w.Result().Body).Decode(&got)
+		require.NoError(t, err)
+		assert.Equal(t, "code", got.Code)
+	}
+}
+
+func Test_CoverageHandler_PrewarmSourceCache(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	repo := base.Repository{Id: 1215, Namespace: "org", Name: "repo"}
+	done := make(chan bool)
+
+	contents := mockscm.NewMockContentService(mockCtrl)
+	contents.EXPECT().Find(gomock.Any(), "org/repo", "a.go", "rev").
+		DoAndReturn(func(ctx context.Context, repo, path, ref string) (*scm.Content, *scm.Response, error) {
+			close(done)
+			return &scm.Content{Data: []byte("code")}, nil, nil
+		}).Times(1)
+
+	rm := NewMockRepositoryClient()
+	rm.client.Contents = contents
+
+	request := &CoverageUploadRequest{
+		Revision:  "rev",
+		Timestamp: time.Now().Round(0),
+		Entries: []*CoverageEntryUploadRequest{
+			{
+				Name:     "go",
+				Profiles: []*profile.Profile{{FileName: "a.go", Blocks: [][]int{{1, 1, 1}}}},
+			},
+		},
+	}
+	body, err := json.Marshal(request)
+	require.NoError(t, err)
+
+	s := newCoverageHandler(setupCoverageStore(t))
+	s.sources, _ = newSourceCache(10, "", 0)
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+	ctx := base.WithRepo(base.WithRepositoryClient(req.Context(), rm), repo)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req.WithContext(ctx))
+	require.Equal(t, http.StatusCreated, w.Result().StatusCode)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("source code is not fetched")
+	}
+
+	require.Eventually(t, func() bool {
+		return s.sources.contains(sourceKey{repo.Id, "rev", "a.go"})
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_CoverageHandler_prewarmSourceCache_ChangedFiles(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	repo := base.Repository{Id: 1215, Namespace: "org", Name: "repo"}
+	now := time.Now().Round(0)
+
+	ancestor := &Coverage{
+		RepoID:    repo.Id,
+		Revision:  "rev0",
+		Timestamp: now.Add(-time.Hour),
+		Entries: []*CoverageEntry{
+			{
+				Name: "go",
+				Profiles: map[string]*profile.Profile{
+					"a.go": {FileName: "a.go", Blocks: [][]int{{1, 1, 1}}},
+					"b.go": {FileName: "b.go", Blocks: [][]int{{1, 1, 1}}},
+				},
+			},
+		},
+	}
+	cov := &Coverage{
+		RepoID:    repo.Id,
+		Revision:  "rev1",
+		Timestamp: now,
+		Entries: []*CoverageEntry{
+			{
+				Name: "go",
+				Profiles: map[string]*profile.Profile{
+					"a.go": {FileName: "a.go", Blocks: [][]int{{1, 1, 1}}},
+					"b.go": {FileName: "b.go", Blocks: [][]int{{1, 2, 0}}},
+					"c.go": {FileName: "c.go", Blocks: [][]int{{1, 1, 1}}},
+				},
+			},
+		},
+	}
+
+	// a.go is not changed
+	contents := mockscm.NewMockContentService(mockCtrl)
+	for _, filename := range []string{"b.go", "c.go"} {
+		contents.EXPECT().Find(gomock.Any(), "org/repo", filename, "rev1").
+			Return(&scm.Content{Data: []byte("code")}, nil, nil).Times(1)
+	}
+
+	rm := NewMockRepositoryClient()
+	rm.client.Contents = contents
+
+	s := newCoverageHandler(setupCoverageStore(t, ancestor, cov))
+	s.sources, _ = newSourceCache(10, "", 0)
+
+	ctx := base.WithRepo(base.WithRepositoryClient(context.Background(), rm), repo)
+	s.prewarmSourceCache(ctx, cov)
+
+	assert.False(t, s.sources.contains(sourceKey{repo.Id, "rev1", "a.go"}))
+	assert.True(t, s.sources.contains(sourceKey{repo.Id, "rev1", "b.go"}))
+	assert.True(t, s.sources.contains(sourceKey{repo.Id, "rev1", "c.go"}))
+}
+
+func Test_CoverageHandler_File_Mirror(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	src := t.TempDir()
+	r, err := git.PlainInit(src, false)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a.go"), []byte("code"), 0o644))
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	_, err = w.Add("a.go")
+	require.NoError(t, err)
+	hash, err := w.Commit("commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "mora", Email: "mora@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	repo := base.Repository{Id: 1215, Namespace: "org", Name: "repo", Url: src}
+	revision := hash.String()
+
+	m, err := mirror.New(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, m.Fetch(context.Background(), repo, nil))
+
+	// a repository manager is not used
+	rm := NewMockRepositoryClient()
+	rm.client.Contents = mockscm.NewMockContentService(mockCtrl)
+
+	cov := &Coverage{
+		RepoID:    repo.Id,
+		Revision:  revision,
+		Timestamp: time.Now().Round(0),
+		Entries: []*CoverageEntry{
+			{
+				Name: "go",
+				Profiles: map[string]*profile.Profile{
+					"a.go": {FileName: "a.go", Blocks: [][]int{{1, 1, 1}}},
+				},
+			},
+		},
+	}
+
+	s := newCoverageHandler(setupCoverageStore(t, cov))
+	s.mirror = m
+
+	path := fmt.Sprintf("/%d/go/files/a.go", cov.ID)
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	ctx := base.WithRepo(base.WithRepositoryClient(req.Context(), rm), repo)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req.WithContext(ctx))
+
+	require.Equal(t, http.StatusOK, rec.Result().StatusCode)
+
+	var got CodeResponse
+	err = json.NewDecoder(rec.Result().Body).Decode(&got)
+	require.NoError(t, err)
+	assert.Equal(t, "code", got.Code)
+}
@@ -0,0 +1,94 @@
+package coverage
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/iszk1215/mora/mora/profile"
+)
+
+type (
+	// DirectoryResponse is a node of a directory tree. Hits and Lines are
+	// the sum of all files under the directory.
+	DirectoryResponse struct {
+		Name       string               `json:"name"`
+		Path       string               `json:"path"`
+		Hits       int                  `json:"hits"`
+		Lines      int                  `json:"lines"`
+		Percentage float64              `json:"percentage"`
+		Dirs       []*DirectoryResponse `json:"dirs"`
+		Files      []*FileResponse      `json:"files"`
+	}
+)
+
+func percentage(hits, lines int) float64 {
+	if lines == 0 {
+		return 0
+	}
+	return float64(hits) * 100.0 / float64(lines)
+}
+
+func newDirectoryResponse(name, path string) *DirectoryResponse {
+	return &DirectoryResponse{
+		Name:  name,
+		Path:  path,
+		Dirs:  []*DirectoryResponse{},
+		Files: []*FileResponse{},
+	}
+}
+
+func (d *DirectoryResponse) findDir(name string) *DirectoryResponse {
+	for _, dir := range d.Dirs {
+		if dir.Name == name {
+			return dir
+		}
+	}
+
+	dir := newDirectoryResponse(name, path.Join(d.Path, name))
+	d.Dirs = append(d.Dirs, dir)
+	return dir
+}
+
+func (d *DirectoryResponse) finish() {
+	sort.Slice(d.Dirs, func(i, j int) bool {
+		return d.Dirs[i].Name < d.Dirs[j].Name
+	})
+	sort.Slice(d.Files, func(i, j int) bool {
+		return d.Files[i].FileName < d.Files[j].FileName
+	})
+
+	for _, dir := range d.Dirs {
+		dir.finish()
+	}
+
+	d.Percentage = percentage(d.Hits, d.Lines)
+}
+
+// makeDirectoryTree groups profiles by directory. FileName of each file in
+// the tree is the full path as in the profile.
+func makeDirectoryTree(profiles map[string]*profile.Profile) *DirectoryResponse {
+	root := newDirectoryResponse("", "")
+
+	for _, pr := range profiles {
+		dir := root
+		dir.Hits += pr.Hits
+		dir.Lines += pr.Lines
+
+		names := strings.Split(path.Clean(pr.FileName), "/")
+		for _, name := range names[:len(names)-1] {
+			if name == "" { // absolute path
+				continue
+			}
+			dir = dir.findDir(name)
+			dir.Hits += pr.Hits
+			dir.Lines += pr.Lines
+		}
+
+		dir.Files = append(dir.Files, &FileResponse{
+			FileName: pr.FileName, Hits: pr.Hits, Lines: pr.Lines})
+	}
+
+	root.finish()
+	return root
+}
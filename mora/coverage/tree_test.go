@@ -0,0 +1,131 @@
+package coverage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_makeDirectoryTree(t *testing.T) {
+	profiles := map[string]*profile.Profile{
+		"main.go":       {FileName: "main.go", Hits: 1, Lines: 2},
+		"a/b/x.go":      {FileName: "a/b/x.go", Hits: 3, Lines: 4},
+		"a/b/y.go":      {FileName: "a/b/y.go", Hits: 0, Lines: 4},
+		"a/z.go":        {FileName: "a/z.go", Hits: 2, Lines: 2},
+		"c/d/e/deep.go": {FileName: "c/d/e/deep.go", Hits: 5, Lines: 10},
+	}
+
+	got := makeDirectoryTree(profiles)
+
+	want := &DirectoryResponse{
+		Name: "", Path: "", Hits: 11, Lines: 22, Percentage: 50,
+		Dirs: []*DirectoryResponse{
+			{
+				Name: "a", Path: "a", Hits: 5, Lines: 10, Percentage: 50,
+				Dirs: []*DirectoryResponse{
+					{
+						Name: "b", Path: "a/b", Hits: 3, Lines: 8, Percentage: 37.5,
+						Dirs: []*DirectoryResponse{},
+						Files: []*FileResponse{
+							{FileName: "a/b/x.go", Hits: 3, Lines: 4},
+							{FileName: "a/b/y.go", Hits: 0, Lines: 4},
+						},
+					},
+				},
+				Files: []*FileResponse{
+					{FileName: "a/z.go", Hits: 2, Lines: 2},
+				},
+			},
+			{
+				Name: "c", Path: "c", Hits: 5, Lines: 10, Percentage: 50,
+				Dirs: []*DirectoryResponse{
+					{
+						Name: "d", Path: "c/d", Hits: 5, Lines: 10, Percentage: 50,
+						Dirs: []*DirectoryResponse{
+							{
+								Name: "e", Path: "c/d/e", Hits: 5, Lines: 10, Percentage: 50,
+								Dirs: []*DirectoryResponse{},
+								Files: []*FileResponse{
+									{FileName: "c/d/e/deep.go", Hits: 5, Lines: 10},
+								},
+							},
+						},
+						Files: []*FileResponse{},
+					},
+				},
+				Files: []*FileResponse{},
+			},
+		},
+		Files: []*FileResponse{
+			{FileName: "main.go", Hits: 1, Lines: 2},
+		},
+	}
+
+	assert.Equal(t, want, got)
+}
+
+func Test_makeDirectoryTree_Empty(t *testing.T) {
+	got := makeDirectoryTree(nil)
+	assert.Equal(t, newDirectoryResponse("", ""), got)
+}
+
+func Test_CoverageHandler_FileTree(t *testing.T) {
+	rm := NewMockRepositoryClient()
+	repo := base.Repository{Id: 1215, Url: "http://mock.scm/org/name"}
+
+	cov := &Coverage{
+		RepoID:    repo.Id,
+		Revision:  "abcde",
+		Timestamp: time.Now().Round(0),
+		Entries: []*CoverageEntry{
+			{
+				Name:  "go",
+				Hits:  4,
+				Lines: 8,
+				Profiles: map[string]*profile.Profile{
+					"a/x.go": {FileName: "a/x.go", Hits: 4, Lines: 8,
+						Blocks: [][]int{{1, 4, 1}, {5, 8, 0}}},
+				},
+			},
+		},
+	}
+
+	store := setupCoverageStore(t, cov)
+	s := newCoverageHandler(store)
+
+	req := httptest.NewRequest(
+		http.MethodGet, fmt.Sprintf("/%d/go/tree", cov.ID), nil)
+	ctx := req.Context()
+	ctx = base.WithRepositoryClient(ctx, rm)
+	ctx = base.WithRepo(ctx, repo)
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	s.Handler().ServeHTTP(w, req)
+
+	result := w.Result()
+	require.Equal(t, http.StatusOK, result.StatusCode)
+
+	body, err := io.ReadAll(result.Body)
+	require.NoError(t, err)
+
+	var got FileTreeResponse
+	err = json.Unmarshal(body, &got)
+	require.NoError(t, err)
+
+	require.Equal(t, 4, got.Root.Hits)
+	require.Equal(t, 8, got.Root.Lines)
+	require.Equal(t, 1, len(got.Root.Dirs))
+	assert.Equal(t, "a", got.Root.Dirs[0].Path)
+	assert.Equal(t, 50.0, got.Root.Dirs[0].Percentage)
+	assert.Equal(t, "a/x.go", got.Root.Dirs[0].Files[0].FileName)
+}
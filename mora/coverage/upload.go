@@ -12,10 +12,12 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"unicode"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/iszk1215/mora/mora/base"
 	"github.com/iszk1215/mora/mora/profile"
 )
@@ -191,7 +193,113 @@ func checkRequest(req *CoverageUploadRequest, repo *git.Repository) (bool, error
 	return !isDirty, nil
 }
 
-func makeRequest(repo *git.Repository, url, entryName string, files ...string) (*CoverageUploadRequest, error) {
+// RevisionRefs is a branch, a tag and a pull request of a revision to be
+// uploaded.
+type RevisionRefs struct {
+	Branch      string
+	Tag         string
+	PullRequest int
+}
+
+// override returns refs where non-empty fields of o replace ones of r.
+func (r RevisionRefs) override(o RevisionRefs) RevisionRefs {
+	if o.Branch != "" {
+		r.Branch = o.Branch
+	}
+	if o.Tag != "" {
+		r.Tag = o.Tag
+	}
+	if o.PullRequest != 0 {
+		r.PullRequest = o.PullRequest
+	}
+	return r
+}
+
+// refsFromGit returns a branch checked out and a tag pointing to HEAD.
+func refsFromGit(repo *git.Repository) (RevisionRefs, error) {
+	refs := RevisionRefs{}
+
+	head, err := repo.Head()
+	if err != nil {
+		return refs, err
+	}
+
+	if head.Name().IsBranch() {
+		refs.Branch = head.Name().Short()
+	}
+
+	tags, err := repo.Tags()
+	if err != nil {
+		return refs, err
+	}
+
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		hash := ref.Hash()
+		if tag, err := repo.TagObject(hash); err == nil { // annotated tag
+			hash = tag.Target
+		}
+		if hash == head.Hash() && refs.Tag == "" {
+			refs.Tag = ref.Name().Short()
+		}
+		return nil
+	})
+
+	return refs, err
+}
+
+// refsFromEnv returns refs given by environment variables of CI services.
+// Because CI checks out a revision as a detached HEAD, these are more
+// reliable than refs in git.
+func refsFromEnv(getenv func(string) string) RevisionRefs {
+	refs := RevisionRefs{}
+	atoi := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+
+	switch {
+	case getenv("GITHUB_ACTIONS") == "true": // also Gitea Actions
+		ref := getenv("GITHUB_REF")
+		if strings.HasPrefix(ref, "refs/heads/") {
+			refs.Branch = strings.TrimPrefix(ref, "refs/heads/")
+		} else if strings.HasPrefix(ref, "refs/tags/") {
+			refs.Tag = strings.TrimPrefix(ref, "refs/tags/")
+		} else if strings.HasPrefix(ref, "refs/pull/") {
+			// refs/pull/<number>/merge
+			refs.PullRequest = atoi(strings.Split(ref, "/")[2])
+			refs.Branch = getenv("GITHUB_HEAD_REF")
+		}
+	case getenv("GITLAB_CI") == "true":
+		refs.Branch = getenv("CI_COMMIT_BRANCH")
+		refs.Tag = getenv("CI_COMMIT_TAG")
+		if iid := getenv("CI_MERGE_REQUEST_IID"); iid != "" {
+			refs.PullRequest = atoi(iid)
+			refs.Branch = getenv("CI_MERGE_REQUEST_SOURCE_BRANCH_NAME")
+		}
+	case getenv("DRONE") == "true":
+		refs.Branch = getenv("DRONE_BRANCH")
+		refs.Tag = getenv("DRONE_TAG")
+		if pr := getenv("DRONE_PULL_REQUEST"); pr != "" {
+			refs.PullRequest = atoi(pr)
+			refs.Branch = getenv("DRONE_SOURCE_BRANCH")
+		}
+	}
+
+	return refs
+}
+
+// detectRefs detects refs from git and CI environment. Non-empty fields of
+// given refs are preferred to detected ones.
+func detectRefs(repo *git.Repository, given RevisionRefs) (RevisionRefs, error) {
+	refs, err := refsFromGit(repo)
+	if err != nil {
+		return RevisionRefs{}, err
+	}
+
+	return refs.override(refsFromEnv(os.Getenv)).override(given), nil
+}
+
+func makeRequest(repo *git.Repository, url, entryName string, entryTags map[string]string, refs RevisionRefs, files ...string) (*CoverageUploadRequest, error) {
 	ref, err := repo.Head()
 	if err != nil {
 		return nil, err
@@ -214,6 +322,7 @@ func makeRequest(repo *git.Repository, url, entryName string, files ...string) (
 		if err != nil {
 			return nil, err
 		}
+		e.Tags = entryTags
 
 		entries = append(entries, e)
 	}
@@ -228,11 +337,19 @@ func makeRequest(repo *git.Repository, url, entryName string, files ...string) (
 		url = strings.TrimSuffix(url, ".git")
 	}
 
+	refs, err = detectRefs(repo, refs)
+	if err != nil {
+		return nil, err
+	}
+
 	req := &CoverageUploadRequest{
-		RepoURL:   url,
-		Revision:  commit.Hash.String(),
-		Timestamp: commit.Committer.When,
-		Entries:   entries,
+		RepoURL:     url,
+		Revision:    commit.Hash.String(),
+		Timestamp:   commit.Committer.When,
+		Branch:      refs.Branch,
+		Tag:         refs.Tag,
+		PullRequest: refs.PullRequest,
+		Entries:     entries,
 	}
 
 	return req, nil
@@ -263,6 +380,21 @@ func printRequest(req *CoverageUploadRequest) {
 	fmt.Printf("%-20s%s\n", "Repository", req.RepoURL)
 	fmt.Printf("%-20s%s\n", "Revision", req.Revision)
 	fmt.Printf("%-20s%s\n", "Time:", req.Timestamp)
+	if req.Branch != "" {
+		fmt.Printf("%-20s%s\n", "Branch", req.Branch)
+	}
+	if req.Tag != "" {
+		fmt.Printf("%-20s%s\n", "Tag", req.Tag)
+	}
+	if req.PullRequest != 0 {
+		fmt.Printf("%-20s#%d\n", "Pull Request", req.PullRequest)
+	}
+	for _, e := range req.Entries {
+		if len(e.Tags) > 0 {
+			fmt.Printf("%-20s%s %v\n", "Entry", e.Name, e.Tags)
+			break
+		}
+	}
 	fmt.Printf("%-20s%.1f%% (%d Hit / %d Lines, %d Files)\n", "Coverage",
 		float64(s.Hits)*100.0/float64(s.Lines), s.Hits, s.Lines, nfiles)
 
@@ -282,13 +414,13 @@ func ask() (bool, error) {
 	return true, nil
 }
 
-func Upload(server, repoURL, repoPath, entryName string, dryRun, force bool, yes bool, args []string) error {
+func Upload(server, repoURL, repoPath, entryName string, entryTags map[string]string, refs RevisionRefs, dryRun, force bool, yes bool, args []string) error {
 	repo, err := git.PlainOpen(repoPath)
 	if err != nil {
 		return errors.New("can not open repository. Use -repo-path=<repository>")
 	}
 
-	req, err := makeRequest(repo, repoURL, entryName, args...)
+	req, err := makeRequest(repo, repoURL, entryName, entryTags, refs, args...)
 	if err != nil {
 		// log.Fatal().Err(err).Msg("failed to make a request")
 		return err
@@ -3,8 +3,12 @@ package coverage
 import (
 	"testing"
 	"testing/fstest"
+	"time"
 
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_relativePathFromRoot(t *testing.T) {
@@ -16,3 +20,80 @@ func Test_relativePathFromRoot(t *testing.T) {
 
 	assert.Equal(t, "src/test.cc", got)
 }
+
+func Test_refsFromEnv(t *testing.T) {
+	testCases := []struct {
+		env  map[string]string
+		want RevisionRefs
+	}{
+		{map[string]string{}, RevisionRefs{}},
+		{
+			map[string]string{"GITHUB_ACTIONS": "true", "GITHUB_REF": "refs/heads/main"},
+			RevisionRefs{Branch: "main"},
+		},
+		{
+			map[string]string{"GITHUB_ACTIONS": "true", "GITHUB_REF": "refs/tags/v1.0"},
+			RevisionRefs{Tag: "v1.0"},
+		},
+		{
+			map[string]string{"GITHUB_ACTIONS": "true", "GITHUB_REF": "refs/pull/123/merge",
+				"GITHUB_HEAD_REF": "topic"},
+			RevisionRefs{Branch: "topic", PullRequest: 123},
+		},
+		{
+			map[string]string{"GITLAB_CI": "true", "CI_MERGE_REQUEST_IID": "7",
+				"CI_MERGE_REQUEST_SOURCE_BRANCH_NAME": "topic"},
+			RevisionRefs{Branch: "topic", PullRequest: 7},
+		},
+		{
+			map[string]string{"GITLAB_CI": "true", "CI_COMMIT_TAG": "v1.0"},
+			RevisionRefs{Tag: "v1.0"},
+		},
+		{
+			map[string]string{"DRONE": "true", "DRONE_BRANCH": "main",
+				"DRONE_PULL_REQUEST": "3", "DRONE_SOURCE_BRANCH": "topic"},
+			RevisionRefs{Branch: "topic", PullRequest: 3},
+		},
+	}
+
+	for _, tc := range testCases {
+		got := refsFromEnv(func(key string) string { return tc.env[key] })
+		assert.Equal(t, tc.want, got)
+	}
+}
+
+func Test_refsFromGit(t *testing.T) {
+	repo, err := git.PlainInit(t.TempDir(), false)
+	require.NoError(t, err)
+
+	w, err := repo.Worktree()
+	require.NoError(t, err)
+
+	sig := &object.Signature{Name: "mora", Email: "mora@example.com", When: time.Now()}
+	hash, err := w.Commit("initial", &git.CommitOptions{AllowEmptyCommits: true, Author: sig})
+	require.NoError(t, err)
+
+	refs, err := refsFromGit(repo)
+	require.NoError(t, err)
+	assert.Equal(t, RevisionRefs{Branch: "master"}, refs)
+
+	_, err = repo.CreateTag("v1.0", hash, &git.CreateTagOptions{Tagger: sig, Message: "v1.0"})
+	require.NoError(t, err)
+
+	refs, err = refsFromGit(repo)
+	require.NoError(t, err)
+	assert.Equal(t, RevisionRefs{Branch: "master", Tag: "v1.0"}, refs)
+
+	err = w.Checkout(&git.CheckoutOptions{Hash: hash}) // detached
+	require.NoError(t, err)
+
+	refs, err = refsFromGit(repo)
+	require.NoError(t, err)
+	assert.Equal(t, RevisionRefs{Tag: "v1.0"}, refs)
+}
+
+func TestRevisionRefs_override(t *testing.T) {
+	refs := RevisionRefs{Branch: "main", Tag: "v1.0"}
+	got := refs.override(RevisionRefs{Branch: "topic", PullRequest: 1})
+	assert.Equal(t, RevisionRefs{Branch: "topic", Tag: "v1.0", PullRequest: 1}, got)
+}
@@ -0,0 +1,41 @@
+// Package migration provides helpers to migrate schemas of tables.
+package migration
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+type Column struct {
+	Name       string
+	Definition string // such as "TEXT NOT NULL DEFAULT ''"
+}
+
+// HasColumn returns true if a table has a column.
+func HasColumn(tx *sqlx.Tx, table, column string) (bool, error) {
+	var count int
+	err := tx.Get(&count,
+		"SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column)
+	return count > 0, err
+}
+
+// AddColumns adds columns to a table unless the table has them.
+func AddColumns(tx *sqlx.Tx, table string, columns []Column) error {
+	for _, c := range columns {
+		found, err := HasColumn(tx, table, c.Name)
+		if err != nil {
+			return err
+		}
+		if found {
+			continue
+		}
+
+		log.Info().Msgf("Add %s.%s", table, c.Name)
+		_, err = tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + c.Name + " " + c.Definition)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,245 @@
+package mirror
+
+import (
+	"sort"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/iszk1215/mora/mora/base"
+)
+
+// generation computes a generation number of a commit, i.e. a number of
+// commits on the longest path from a root commit. Because a commit is
+// immutable, computed numbers are memoized in gens.
+func generation(r *git.Repository, gens map[plumbing.Hash]int, hash plumbing.Hash) (int, error) {
+	stack := []plumbing.Hash{hash}
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		if _, ok := gens[h]; ok {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		commit, err := r.CommitObject(h)
+		if err != nil {
+			return 0, err
+		}
+
+		gen := 0
+		pending := false
+		for _, p := range commit.ParentHashes {
+			g, ok := gens[p]
+			if !ok {
+				stack = append(stack, p)
+				pending = true
+			} else if g+1 > gen {
+				gen = g + 1
+			}
+		}
+
+		if !pending {
+			gens[h] = gen
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	return gens[hash], nil
+}
+
+// Generations returns generation numbers of revisions. A revision always has
+// a larger number than its ancestors, thus sorting revisions by the number
+// gives a topological order of the commit graph.
+func (m *Mirror) Generations(repo base.Repository, revisions []string) (map[string]int, error) {
+	r, err := m.open(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	m.genLock.Lock()
+	defer m.genLock.Unlock()
+
+	gens, ok := m.gens[repo.Id]
+	if !ok {
+		gens = map[plumbing.Hash]int{}
+		m.gens[repo.Id] = gens
+	}
+
+	result := map[string]int{}
+	for _, rev := range revisions {
+		hash, err := r.ResolveRevision(plumbing.Revision(rev))
+		if err != nil {
+			return nil, err
+		}
+
+		result[rev], err = generation(r, gens, *hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// NearestAncestor returns a revision in candidates which is the nearest
+// ancestor of revision in terms of a number of commits. revision itself is
+// not its ancestor. An empty string is returned when no candidate is an
+// ancestor. Candidates not in the mirror are ignored.
+func (m *Mirror) NearestAncestor(repo base.Repository, revision string, candidates []string) (string, error) {
+	r, err := m.open(repo)
+	if err != nil {
+		return "", err
+	}
+
+	start, err := r.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return "", err
+	}
+
+	targets := map[plumbing.Hash]string{}
+	for _, c := range candidates {
+		hash, err := r.ResolveRevision(plumbing.Revision(c))
+		if err != nil || *hash == *start {
+			continue
+		}
+		targets[*hash] = c
+	}
+
+	if len(targets) == 0 {
+		return "", nil
+	}
+
+	// breadth first search from revision to its parents
+	visited := map[plumbing.Hash]bool{*start: true}
+	queue := []plumbing.Hash{*start}
+	for len(queue) > 0 {
+		commit, err := r.CommitObject(queue[0])
+		if err != nil {
+			return "", err
+		}
+		queue = queue[1:]
+
+		for _, p := range commit.ParentHashes {
+			if visited[p] {
+				continue
+			}
+			if c, ok := targets[p]; ok {
+				return c, nil
+			}
+			visited[p] = true
+			queue = append(queue, p)
+		}
+	}
+
+	return "", nil
+}
+
+// NearestAncestors returns the nearest ancestor in revisions of each revision
+// in revisions, which NearestAncestor returns. An empty string is mapped to a
+// revision without ancestors, and a revision not in the mirror is not in the
+// map.
+//
+// The commit graph is walked once in a topological order from the oldest
+// revision, because a commit older than it, i.e. with a smaller generation
+// number, has no revisions in its ancestors.
+func (m *Mirror) NearestAncestors(repo base.Repository, revisions []string) (map[string]string, error) {
+	r, err := m.open(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	m.genLock.Lock()
+	defer m.genLock.Unlock()
+
+	gens, ok := m.gens[repo.Id]
+	if !ok {
+		gens = map[plumbing.Hash]int{}
+		m.gens[repo.Id] = gens
+	}
+
+	targets := map[plumbing.Hash]string{}
+	minGen := -1
+	for _, rev := range revisions {
+		hash, err := r.ResolveRevision(plumbing.Revision(rev))
+		if err != nil {
+			continue
+		}
+
+		gen, err := generation(r, gens, *hash)
+		if err != nil {
+			return nil, err
+		}
+
+		targets[*hash] = rev
+		if minGen < 0 || gen < minGen {
+			minGen = gen
+		}
+	}
+
+	// parents of commits between the oldest revision and the others. gens
+	// has all ancestors of the revisions.
+	parents := map[plumbing.Hash][]plumbing.Hash{}
+	stack := []plumbing.Hash{}
+	for hash := range targets {
+		stack = append(stack, hash)
+	}
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := parents[h]; ok {
+			continue
+		}
+
+		commit, err := r.CommitObject(h)
+		if err != nil {
+			return nil, err
+		}
+
+		ps := []plumbing.Hash{}
+		for _, p := range commit.ParentHashes {
+			if gens[p] >= minGen {
+				ps = append(ps, p)
+				stack = append(stack, p)
+			}
+		}
+		parents[h] = ps
+	}
+
+	commits := []plumbing.Hash{}
+	for h := range parents {
+		commits = append(commits, h)
+	}
+	sort.Slice(commits, func(i, j int) bool {
+		return gens[commits[i]] < gens[commits[j]]
+	})
+
+	// the nearest revision from each commit and a number of commits to it.
+	// Parents are processed before children.
+	type nearest struct {
+		revision string
+		distance int
+	}
+	near := map[plumbing.Hash]nearest{}
+	for _, h := range commits {
+		var best *nearest
+		for _, p := range parents[h] {
+			n, ok := nearest{targets[p], 1}, true
+			if n.revision == "" {
+				n, ok = near[p]
+				n.distance++
+			}
+			if ok && (best == nil || n.distance < best.distance) {
+				best = &nearest{n.revision, n.distance}
+			}
+		}
+		if best != nil {
+			near[h] = *best
+		}
+	}
+
+	result := map[string]string{}
+	for hash, rev := range targets {
+		result[rev] = near[hash].revision
+	}
+
+	return result, nil
+}
@@ -0,0 +1,119 @@
+package mirror
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func commitEmpty(t *testing.T, r *git.Repository, when time.Time, parents ...string) string {
+	w, err := r.Worktree()
+	require.NoError(t, err)
+
+	hashes := []plumbing.Hash{}
+	for _, p := range parents {
+		hashes = append(hashes, plumbing.NewHash(p))
+	}
+
+	hash, err := w.Commit("commit", &git.CommitOptions{
+		AllowEmptyCommits: true,
+		Parents:           hashes,
+		Author:            &object.Signature{Name: "mora", Email: "mora@example.com", When: when},
+	})
+	require.NoError(t, err)
+
+	return hash.String()
+}
+
+// setupGraph creates a repository with a graph below, where c2 is backdated.
+//
+//	c0 - c1 - c3
+//	  \      /
+//	   c2 --
+func setupGraph(t *testing.T) (*Mirror, base.Repository, []string) {
+	src := t.TempDir()
+	r, err := git.PlainInit(src, false)
+	require.NoError(t, err)
+
+	now := time.Now()
+	c0 := commitEmpty(t, r, now.Add(-3*time.Hour))
+	c1 := commitEmpty(t, r, now.Add(-1*time.Hour), c0)
+	c2 := commitEmpty(t, r, now.Add(-2*time.Hour), c0)
+	c3 := commitEmpty(t, r, now, c1, c2)
+
+	m, err := New(t.TempDir())
+	require.NoError(t, err)
+
+	repo := base.Repository{Id: 1215, Url: src}
+	require.NoError(t, m.Fetch(context.Background(), repo, nil))
+
+	return m, repo, []string{c0, c1, c2, c3}
+}
+
+func TestMirror_Generations(t *testing.T) {
+	m, repo, c := setupGraph(t)
+
+	gens, err := m.Generations(repo, []string{c[3], c[1], c[0], c[2]})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{c[0]: 0, c[1]: 1, c[2]: 1, c[3]: 2}, gens)
+
+	// memoized
+	gens, err = m.Generations(repo, []string{c[3]})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{c[3]: 2}, gens)
+
+	_, err = m.Generations(repo, []string{"0123456789012345678901234567890123456789"})
+	assert.Error(t, err)
+}
+
+func TestMirror_NearestAncestor(t *testing.T) {
+	m, repo, c := setupGraph(t)
+
+	testCases := []struct {
+		revision   string
+		candidates []string
+		want       string
+	}{
+		{c[3], []string{c[0], c[1], c[2], c[3]}, c[1]},
+		{c[3], []string{c[0], c[2]}, c[2]},
+		{c[2], []string{c[1], c[3]}, ""},
+		{c[1], []string{c[0], c[1]}, c[0]},
+		{c[0], []string{c[1], c[2], c[3]}, ""},
+		{c[3], []string{"unknown", c[0]}, c[0]},
+	}
+
+	for _, tc := range testCases {
+		got, err := m.NearestAncestor(repo, tc.revision, tc.candidates)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, got)
+	}
+}
+
+func TestMirror_NearestAncestors(t *testing.T) {
+	m, repo, c := setupGraph(t)
+
+	got, err := m.NearestAncestors(repo, []string{c[3], c[2], c[1], c[0], "unknown"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{c[0]: "", c[1]: c[0], c[2]: c[0], c[3]: c[1]}, got)
+
+	got, err = m.NearestAncestors(repo, []string{c[3], c[2]})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{c[2]: "", c[3]: c[2]}, got)
+
+	// same as NearestAncestor
+	revisions := []string{c[0], c[2], c[3]}
+	got, err = m.NearestAncestors(repo, revisions)
+	require.NoError(t, err)
+	for _, rev := range revisions {
+		want, err := m.NearestAncestor(repo, rev, revisions)
+		require.NoError(t, err)
+		assert.Equal(t, want, got[rev])
+	}
+}
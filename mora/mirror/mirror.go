@@ -0,0 +1,226 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrNoMirror    = errors.New("no mirror found")
+	ErrRefNotFound = errors.New("ref not found")
+)
+
+// Mirror manages bare mirrors of repositories under a directory. Each mirror
+// is named by a repository id not to be affected by renaming.
+type Mirror struct {
+	dir string
+
+	lock  sync.Mutex
+	locks map[int64]*sync.Mutex // lock per repository
+
+	genLock sync.Mutex
+	gens    map[int64]map[plumbing.Hash]int // generation numbers per repository
+}
+
+func New(dir string) (*Mirror, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mirror{
+		dir:   dir,
+		locks: map[int64]*sync.Mutex{},
+		gens:  map[int64]map[plumbing.Hash]int{},
+	}, nil
+}
+
+func (m *Mirror) path(repo base.Repository) string {
+	return filepath.Join(m.dir, strconv.FormatInt(repo.Id, 10)+".git")
+}
+
+func (m *Mirror) repoLock(repo base.Repository) *sync.Mutex {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	lock, ok := m.locks[repo.Id]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.locks[repo.Id] = lock
+	}
+	return lock
+}
+
+// Has returns true if repo has been mirrored
+func (m *Mirror) Has(repo base.Repository) bool {
+	_, err := os.Stat(m.path(repo))
+	return err == nil
+}
+
+// Fetch updates a mirror of repo. A mirror is cloned from repo.Url when it
+// does not exist.
+func (m *Mirror) Fetch(ctx context.Context, repo base.Repository, auth transport.AuthMethod) error {
+	lock := m.repoLock(repo)
+	lock.Lock()
+	defer lock.Unlock()
+
+	path := m.path(repo)
+
+	r, err := git.PlainOpen(path)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		return m.clone(ctx, repo, auth)
+	} else if err != nil {
+		return err
+	}
+
+	err = setRemoteURL(r, repo.Url)
+	if err != nil {
+		return err
+	}
+
+	log.Print("Mirror: fetch ", repo.Url)
+	err = r.FetchContext(ctx, &git.FetchOptions{Auth: auth, Force: true})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil
+	}
+	return err
+}
+
+// setRemoteURL updates a URL of origin of a mirror when a repository has
+// been renamed or transferred.
+func setRemoteURL(r *git.Repository, url string) error {
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+
+	remote, ok := cfg.Remotes[git.DefaultRemoteName]
+	if !ok || (len(remote.URLs) == 1 && remote.URLs[0] == url) {
+		return nil
+	}
+
+	log.Info().Msgf("Mirror: set url of %s to %s", remote.URLs, url)
+	remote.URLs = []string{url}
+	return r.SetConfig(cfg)
+}
+
+// clone clones repo into a temporary directory, then renames it not to leave
+// a partial mirror.
+func (m *Mirror) clone(ctx context.Context, repo base.Repository, auth transport.AuthMethod) error {
+	path := m.path(repo)
+	log.Info().Msgf("Mirror: clone %s to %s", repo.Url, path)
+
+	tmp, err := os.MkdirTemp(m.dir, "clone-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp) // nolint:errcheck
+
+	_, err = git.PlainCloneContext(ctx, tmp, true, &git.CloneOptions{
+		URL:    repo.Url,
+		Auth:   auth,
+		Mirror: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (m *Mirror) open(repo base.Repository) (*git.Repository, error) {
+	r, err := git.PlainOpen(m.path(repo))
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil, ErrNoMirror
+	}
+	return r, err
+}
+
+// Contents returns contents of a file at a revision
+func (m *Mirror) Contents(repo base.Repository, revision, path string) ([]byte, error) {
+	r, err := m.open(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := r.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := r.CommitObject(*hash)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := commit.File(path)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(contents), nil
+}
+
+// ResolveRef resolves a branch or a tag to a commit hash. ErrRefNotFound is
+// returned when neither is found, which includes one not fetched yet.
+func (m *Mirror) ResolveRef(repo base.Repository, ref string) (string, error) {
+	r, err := m.open(repo)
+	if err != nil {
+		return "", err
+	}
+
+	names := []plumbing.ReferenceName{
+		plumbing.NewBranchReferenceName(ref),
+		plumbing.NewTagReferenceName(ref),
+	}
+	for _, name := range names {
+		hash, err := r.ResolveRevision(plumbing.Revision(name))
+		if err == nil {
+			return hash.String(), nil
+		} else if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return "", err
+		}
+	}
+
+	return "", ErrRefNotFound
+}
+
+// Remove removes a mirror of repo. Nothing is done when repo has not been
+// mirrored.
+func (m *Mirror) Remove(repo base.Repository) error {
+	lock := m.repoLock(repo)
+	lock.Lock()
+	defer lock.Unlock()
+
+	m.genLock.Lock()
+	delete(m.gens, repo.Id)
+	m.genLock.Unlock()
+
+	return os.RemoveAll(m.path(repo))
+}
+
+// FetchAll updates mirrors of all repositories. auth returns an auth method
+// for a repository.
+func (m *Mirror) FetchAll(ctx context.Context, repos []base.Repository, auth func(base.Repository) transport.AuthMethod) {
+	for _, repo := range repos {
+		err := m.Fetch(ctx, repo, auth(repo))
+		if err != nil {
+			log.Warn().Err(err).Msgf("Mirror: failed to fetch %s", repo.Url)
+		}
+	}
+}
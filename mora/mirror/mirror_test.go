@@ -0,0 +1,175 @@
+package mirror
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// commitFile commits a file to a non-bare repository at dir, and returns a
+// hash of the commit.
+func commitFile(t *testing.T, r *git.Repository, dir, filename, contents string) string {
+	err := os.WriteFile(filepath.Join(dir, filename), []byte(contents), 0o644)
+	require.NoError(t, err)
+
+	w, err := r.Worktree()
+	require.NoError(t, err)
+
+	_, err = w.Add(filename)
+	require.NoError(t, err)
+
+	hash, err := w.Commit("commit "+filename, &git.CommitOptions{
+		Author: &object.Signature{Name: "mora", Email: "mora@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	return hash.String()
+}
+
+func TestMirror_FetchAndContents(t *testing.T) {
+	src := t.TempDir()
+	r, err := git.PlainInit(src, false)
+	require.NoError(t, err)
+
+	rev0 := commitFile(t, r, src, "a.go", "package a")
+
+	m, err := New(t.TempDir())
+	require.NoError(t, err)
+
+	repo := base.Repository{Id: 1215, Url: src}
+	assert.False(t, m.Has(repo))
+
+	_, err = m.Contents(repo, rev0, "a.go")
+	assert.ErrorIs(t, err, ErrNoMirror)
+
+	require.NoError(t, m.Fetch(context.Background(), repo, nil))
+	assert.True(t, m.Has(repo))
+
+	code, err := m.Contents(repo, rev0, "a.go")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("package a"), code)
+
+	// fetch without new commits succeeds
+	require.NoError(t, m.Fetch(context.Background(), repo, nil))
+
+	rev1 := commitFile(t, r, src, "a.go", "package b")
+
+	_, err = m.Contents(repo, rev1, "a.go")
+	assert.Error(t, err)
+
+	require.NoError(t, m.Fetch(context.Background(), repo, nil))
+
+	code, err = m.Contents(repo, rev1, "a.go")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("package b"), code)
+
+	code, err = m.Contents(repo, rev0, "a.go")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("package a"), code)
+
+	_, err = m.Contents(repo, rev1, "b.go")
+	assert.Error(t, err)
+}
+
+func TestMirror_ResolveRef(t *testing.T) {
+	src := t.TempDir()
+	r, err := git.PlainInit(src, false)
+	require.NoError(t, err)
+
+	rev0 := commitFile(t, r, src, "a.go", "package a")
+	_, err = r.CreateTag("v1.0", plumbing.NewHash(rev0), &git.CreateTagOptions{
+		Message: "v1.0",
+		Tagger:  &object.Signature{Name: "mora", Email: "mora@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+	rev1 := commitFile(t, r, src, "a.go", "package b")
+
+	head, err := r.Head()
+	require.NoError(t, err)
+
+	m, err := New(t.TempDir())
+	require.NoError(t, err)
+
+	repo := base.Repository{Id: 1215, Url: src}
+	_, err = m.ResolveRef(repo, head.Name().Short())
+	assert.ErrorIs(t, err, ErrNoMirror)
+
+	require.NoError(t, m.Fetch(context.Background(), repo, nil))
+
+	got, err := m.ResolveRef(repo, head.Name().Short())
+	require.NoError(t, err)
+	assert.Equal(t, rev1, got)
+
+	// annotated tag is resolved to a commit
+	got, err = m.ResolveRef(repo, "v1.0")
+	require.NoError(t, err)
+	assert.Equal(t, rev0, got)
+
+	_, err = m.ResolveRef(repo, "unknown")
+	assert.ErrorIs(t, err, ErrRefNotFound)
+}
+
+func TestMirror_Remove(t *testing.T) {
+	src := t.TempDir()
+	r, err := git.PlainInit(src, false)
+	require.NoError(t, err)
+	commitFile(t, r, src, "a.go", "package a")
+
+	m, err := New(t.TempDir())
+	require.NoError(t, err)
+
+	repo := base.Repository{Id: 1215, Url: src}
+	require.NoError(t, m.Fetch(context.Background(), repo, nil))
+	require.True(t, m.Has(repo))
+
+	require.NoError(t, m.Remove(repo))
+	assert.False(t, m.Has(repo))
+
+	// removing again is not an error
+	require.NoError(t, m.Remove(repo))
+}
+
+func TestMirror_FetchFailure(t *testing.T) {
+	m, err := New(t.TempDir())
+	require.NoError(t, err)
+
+	repo := base.Repository{Id: 1215, Url: filepath.Join(t.TempDir(), "none")}
+	assert.Error(t, m.Fetch(context.Background(), repo, nil))
+	assert.False(t, m.Has(repo))
+}
+
+func TestMirror_FetchMovedRepository(t *testing.T) {
+	src := t.TempDir()
+	r, err := git.PlainInit(src, false)
+	require.NoError(t, err)
+	commitFile(t, r, src, "a.go", "package a")
+
+	m, err := New(t.TempDir())
+	require.NoError(t, err)
+
+	repo := base.Repository{Id: 1215, Url: src}
+	require.NoError(t, m.Fetch(context.Background(), repo, nil))
+
+	// move the repository
+	moved := filepath.Join(t.TempDir(), "moved")
+	require.NoError(t, os.Rename(src, moved))
+	r, err = git.PlainOpen(moved)
+	require.NoError(t, err)
+	rev := commitFile(t, r, moved, "a.go", "package b")
+
+	repo.Url = moved
+	require.NoError(t, m.Fetch(context.Background(), repo, nil))
+
+	code, err := m.Contents(repo, rev, "a.go")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("package b"), code)
+}
@@ -210,3 +210,31 @@ func ParseCoverage(reader io.Reader) ([]*Profile, error) {
 	profiles = postprocess(profiles)
 	return profiles, nil
 }
+
+// Union merges profiles of the same file into one profile, where a line is
+// hit if the line is hit in any profile. Counts of a line are summed.
+func Union(profiles ...*Profile) *Profile {
+	if len(profiles) == 0 {
+		return nil
+	}
+
+	counts := map[int]int{}
+	for _, p := range profiles {
+		for _, b := range p.Blocks {
+			for l := b[START]; l <= b[END]; l++ {
+				counts[l] += b[COUNT]
+			}
+		}
+	}
+
+	blocks := [][]int{}
+	for l, c := range counts {
+		blocks = append(blocks, []int{l, l, c})
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i][START] < blocks[j][START]
+	})
+
+	ret := postprocess([]*Profile{{FileName: profiles[0].FileName, Blocks: blocks}})
+	return ret[0]
+}
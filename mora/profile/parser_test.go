@@ -105,3 +105,21 @@ mockscm.com/mockowner/mockrepo/test2.go:1.2,3.4 3 0
 
 	require.Equal(t, expected, profiles)
 }
+
+func TestUnion(t *testing.T) {
+	a := &Profile{FileName: "a.go", Blocks: [][]int{{1, 3, 1}, {4, 6, 0}}}
+	b := &Profile{FileName: "a.go", Blocks: [][]int{{2, 2, 2}, {5, 5, 1}, {8, 8, 0}}}
+
+	got := Union(a, b)
+
+	want := &Profile{
+		FileName: "a.go",
+		Hits:     4,
+		Lines:    7,
+		Blocks:   [][]int{{1, 1, 1}, {2, 2, 3}, {3, 3, 1}, {4, 4, 0}, {5, 5, 1}, {6, 6, 0}, {8, 8, 0}},
+	}
+	require.Equal(t, want, got)
+
+	// inputs are not modified
+	require.Equal(t, [][]int{{1, 3, 1}, {4, 6, 0}}, a.Blocks)
+}
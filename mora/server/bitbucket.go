@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	login "github.com/drone/go-login/login/bitbucket"
+	driver "github.com/drone/go-scm/scm/driver/bitbucket"
+	"github.com/drone/go-scm/scm/transport/oauth2"
+)
+
+// Bitbucket is a repository manager for Bitbucket Cloud. See Stash for
+// Bitbucket Server.
+type Bitbucket struct {
+	BaseRepositoryManager
+}
+
+func (b *Bitbucket) RevisionURL(baseURL string, revision string) string {
+	return baseURL + "/commits/" + revision
+}
+
+func (b *Bitbucket) FindDescription(ctx context.Context, repo string) (string, error) {
+	return findDescription(ctx, b.client, "2.0/repositories/"+repo)
+}
+
+func NewBitbucket(id int64, urlstr string, config login.Config) *Bitbucket {
+	url, _ := url.Parse(urlstr)
+	bitbucket := new(Bitbucket)
+	bitbucket.Init(id, url, driver.NewDefault(), &config)
+	bitbucket.gitUsername = "x-token-auth"
+
+	bitbucket.client.Client = &http.Client{
+		Transport: &oauth2.Transport{
+			Scheme: oauth2.SchemeBearer,
+			Source: oauth2.ContextTokenSource(),
+		},
+	}
+
+	// access tokens of Bitbucket expire in two hours
+	bitbucket.SetTokenEndpoint("https://bitbucket.org/site/oauth2/access_token",
+		config.ClientID, config.ClientSecret)
+	return bitbucket
+}
+
+func NewBitbucketFromFile(id int64, url, filename string, redirect_url string) (*Bitbucket, error) {
+	secret, err := readSecret(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	config := login.Config{
+		ClientID:     secret.ClientID,
+		ClientSecret: secret.ClientSecret,
+		RedirectURL:  redirect_url,
+	}
+
+	bitbucket := NewBitbucket(id, url, config)
+	bitbucket.SetServiceToken(secret.ServiceToken)
+	return bitbucket, nil
+}
@@ -0,0 +1,17 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/drone/go-login/login/bitbucket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBitbucket_RevisionURL(t *testing.T) {
+	rm := NewBitbucket(1, "https://bitbucket.org", bitbucket.Config{})
+
+	assert.Equal(t, "https://bitbucket.org", rm.URL().String())
+	assert.Equal(t,
+		"https://bitbucket.org/workspace/repo/commits/0123abc",
+		rm.RevisionURL("https://bitbucket.org/workspace/repo", "0123abc"))
+}
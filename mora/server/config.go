@@ -1,8 +1,11 @@
 package server
 
 import (
+	"fmt"
 	"os"
+	"time"
 
+	"github.com/iszk1215/mora/mora/coverage"
 	"github.com/pelletier/go-toml/v2"
 )
 
@@ -17,13 +20,129 @@ type RepositoryManagerConfig struct {
 	SecretFilename string `toml:"secret_file"`
 }
 
+// url returns a URL of a repository manager. URLs of hosted services are
+// used when it is not configured.
+func (c RepositoryManagerConfig) url() string {
+	if c.URL != "" {
+		return c.URL
+	}
+
+	switch c.Driver {
+	case "github":
+		return "https://github.com"
+	case "gitlab":
+		return "https://gitlab.com"
+	case "bitbucket":
+		return "https://bitbucket.org"
+	}
+	return ""
+}
+
+// RetentionConfig configures pruning of coverages. Pruning is disabled when
+// KeepDays is zero.
+type RetentionConfig struct {
+	KeepDays      int      `toml:"keep_days"`
+	Thin          string   `toml:"thin"` // "daily" (default) or "weekly"
+	KeepRevisions []string `toml:"keep_revisions"`
+	Interval      string   `toml:"interval"` // default "24h"
+}
+
+// SourceCacheConfig configures the cache of source code fetched from
+// repository managers.
+type SourceCacheConfig struct {
+	Size     int    `toml:"size"`      // max number of files in memory. default 1024, negative to disable
+	Dir      string `toml:"dir"`       // on-disk store. disabled if empty
+	DiskSize int    `toml:"disk_size"` // max size of the on-disk store in MiB. default 1024
+}
+
+// MirrorConfig configures local mirrors of repositories. Mirroring is
+// disabled when Dir is empty. Mirrors are fetched only on request when
+// Interval is "0".
+type MirrorConfig struct {
+	Dir      string `toml:"dir"`
+	Interval string `toml:"interval"` // default "10m"
+}
+
+// RepositorySyncConfig configures sync of repositories with repository
+// managers. Sync is disabled when Interval is "0".
+type RepositorySyncConfig struct {
+	Interval string `toml:"interval"` // default "1h"
+}
+
 type MoraConfig struct {
 	Server             ServerConfig
 	RepositoryManagers []RepositoryManagerConfig `toml:"scm"`
+	Retention          RetentionConfig
+	SourceCache        SourceCacheConfig `toml:"source_cache"`
+	Mirror             MirrorConfig
+	Sync               RepositorySyncConfig
 	Debug              bool
 	DatabaseFilename   string
 }
 
+func (c SourceCacheConfig) size() int {
+	if c.Size == 0 {
+		return 1024
+	}
+	return c.Size
+}
+
+func (c SourceCacheConfig) diskSize() int64 {
+	if c.DiskSize == 0 {
+		return 1024 << 20
+	}
+	return int64(c.DiskSize) << 20
+}
+
+func (c RetentionConfig) Policy() (coverage.RetentionPolicy, error) {
+	policy := coverage.RetentionPolicy{
+		KeepDays:      c.KeepDays,
+		Thin:          c.Thin,
+		KeepRevisions: c.KeepRevisions,
+	}
+
+	if policy.Thin == "" {
+		policy.Thin = coverage.ThinDaily
+	}
+
+	if policy.Enabled() {
+		if err := policy.Validate(); err != nil {
+			return coverage.RetentionPolicy{}, err
+		}
+	}
+
+	return policy, nil
+}
+
+func (c RetentionConfig) PruneInterval() (time.Duration, error) {
+	if c.Interval == "" {
+		return 24 * time.Hour, nil
+	}
+
+	interval, err := time.ParseDuration(c.Interval)
+	if err != nil {
+		return 0, err
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("retention interval must be positive: %s", c.Interval)
+	}
+	return interval, nil
+}
+
+func (c MirrorConfig) FetchInterval() (time.Duration, error) {
+	if c.Interval == "" {
+		return 10 * time.Minute, nil
+	}
+	return time.ParseDuration(c.Interval)
+}
+
+func (c RepositorySyncConfig) SyncInterval() (time.Duration, error) {
+	if c.Interval == "" {
+		return time.Hour, nil
+	}
+	return time.ParseDuration(c.Interval)
+}
+
 func ReadMoraConfig(filename string) (MoraConfig, error) {
 	b, err := os.ReadFile(filename)
 	if err != nil {
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/coverage"
+	"github.com/iszk1215/mora/mora/mirror"
+	"github.com/iszk1215/mora/mora/render"
+	"github.com/iszk1215/mora/mora/udm"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+var errorRepositoryNotFound = errors.New("repository not found")
+
+// DeleteRepository removes a repository with its settings, coverages and
+// user defined metrics in one transaction. Its files on disk are not removed.
+// See RemoveRepositoryFiles.
+func DeleteRepository(db *sqlx.DB, repoID int64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // nolint:errcheck
+
+	res, err := tx.Exec("DELETE FROM repository WHERE id = $1", repoID)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errorRepositoryNotFound
+	}
+
+	_, err = tx.Exec("DELETE FROM repository_settings WHERE repo_id = $1", repoID)
+	if err != nil {
+		return err
+	}
+
+	coverages, err := coverage.DeleteRepository(tx, repoID)
+	if err != nil {
+		return err
+	}
+
+	err = udm.DeleteRepository(tx, repoID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Repository deleted: id=%d coverages=%d", repoID, coverages)
+	return nil
+}
+
+// RemoveRepositoryFiles removes the mirror and cached source code of a
+// repository on disk. This is used after DeleteRepository while the server is
+// not running.
+func RemoveRepositoryFiles(config MoraConfig, repo Repository) error {
+	if config.Mirror.Dir != "" {
+		mirrors, err := mirror.New(config.Mirror.Dir)
+		if err != nil {
+			return err
+		}
+		err = mirrors.Remove(repo)
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.SourceCache.Dir != "" {
+		return coverage.RemoveCachedSources(config.SourceCache.Dir, repo.Id)
+	}
+
+	return nil
+}
+
+// removeRepositoryFiles removes the mirror and cached source code of a
+// deleted repository. Errors are only logged because the repository has
+// already been deleted from the database.
+func (s *MoraServer) removeRepositoryFiles(repo Repository) {
+	if s.mirror != nil {
+		if err := s.mirror.Remove(repo); err != nil {
+			log.Warn().Err(err).Msg("removeRepositoryFiles")
+		}
+	}
+
+	if s.coverage != nil {
+		if err := s.coverage.RemoveCachedSources(repo.Id); err != nil {
+			log.Warn().Err(err).Msg("removeRepositoryFiles")
+		}
+	}
+}
+
+func (s *MoraServer) handleRepoDelete(w http.ResponseWriter, r *http.Request) {
+	repo, _ := base.RepoFrom(r.Context())
+
+	err := DeleteRepository(s.db, repo.Id)
+	if err != nil {
+		log.Err(err).Msg("handleRepoDelete")
+		render.InternalError(w, errors.New("internal error"))
+		return
+	}
+
+	s.removeRepositoryFiles(repo)
+
+	w.WriteHeader(http.StatusNoContent)
+}
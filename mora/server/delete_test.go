@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/coverage"
+	"github.com/iszk1215/mora/mora/udm"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupDeleteTest creates a database with two repositories which have
+// settings, coverages and metrics.
+func setupDeleteTest(t *testing.T) (*sqlx.DB, RepositoryStore, []*Repository) {
+	db, err := sqlx.Connect("sqlite3", ":memory:?_loc=auto")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1) // share the in-memory database
+
+	repos := NewRepositoryStore(db)
+	require.NoError(t, repos.Init())
+	settings := NewRepositorySettingsStore(db)
+	require.NoError(t, settings.Init())
+	coverages := coverage.NewCoverageStore(db)
+	require.NoError(t, coverages.Init())
+	_, err = udm.NewService(db)
+	require.NoError(t, err)
+
+	registered := []*Repository{
+		{RepositoryManager: 1, Namespace: "owner", Name: "repo0", Url: "https://scm.com/owner/repo0"},
+		{RepositoryManager: 1, Namespace: "owner", Name: "repo1", Url: "https://scm.com/owner/repo1"},
+	}
+	for i, repo := range registered {
+		require.NoError(t, repos.Put(repo))
+		require.NoError(t, settings.Put(repo.Id, &base.RepositorySettings{DefaultBranch: "main"}))
+		require.NoError(t, coverages.Put(&coverage.Coverage{
+			RepoID: repo.Id, Revision: fmt.Sprintf("rev%d", i), Timestamp: time.Now(),
+			Entries: []*coverage.CoverageEntry{{Name: "go"}},
+		}))
+
+		res, err := db.Exec("INSERT INTO udm_metric (repo_id, name) VALUES ($1, 'metric')", repo.Id)
+		require.NoError(t, err)
+		metricID, err := res.LastInsertId()
+		require.NoError(t, err)
+		res, err = db.Exec("INSERT INTO udm_item (metric_id, name, type) VALUES ($1, 'item', 0)", metricID)
+		require.NoError(t, err)
+		itemID, err := res.LastInsertId()
+		require.NoError(t, err)
+		_, err = db.Exec("INSERT INTO udm_value (item_id, revision, time, value) VALUES ($1, 'rev', $2, '1')",
+			itemID, time.Now())
+		require.NoError(t, err)
+	}
+
+	return db, repos, registered
+}
+
+func requireRowCounts(t *testing.T, db *sqlx.DB, want int) {
+	tables := []string{
+		"repository", "repository_settings", "coverage", "coverage_entry",
+		"udm_metric", "udm_item", "udm_value",
+	}
+	for _, table := range tables {
+		var count int
+		require.NoError(t, db.Get(&count, "SELECT COUNT(*) FROM "+table))
+		require.Equal(t, want, count, table)
+	}
+}
+
+func TestDeleteRepository(t *testing.T) {
+	db, repos, registered := setupDeleteTest(t)
+	requireRowCounts(t, db, 2)
+
+	require.NoError(t, DeleteRepository(db, registered[0].Id))
+	requireRowCounts(t, db, 1)
+
+	_, err := repos.Find(registered[0].Id)
+	assert.Error(t, err)
+	_, err = repos.Find(registered[1].Id)
+	assert.NoError(t, err)
+
+	assert.Equal(t, errorRepositoryNotFound, DeleteRepository(db, registered[0].Id))
+}
+
+func TestServer_RepoDelete(t *testing.T) {
+	db, repos, registered := setupDeleteTest(t)
+
+	server := NewMoraServerBuilder(t).WithRepositoryManager(NewMockRepositoryManager(1)).
+		WithSessionManager().WithAPIKey("key").Finish()
+	server.db = db
+	server.repos = repos
+
+	path := fmt.Sprintf("/api/repos/%d", registered[0].Id)
+
+	req := httptest.NewRequest(http.MethodDelete, path, nil)
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+	require.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+	requireRowCounts(t, db, 2)
+
+	req = httptest.NewRequest(http.MethodDelete, path, nil)
+	req.Header.Set("Authorization", "Bearer key")
+	w = httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+	require.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+	requireRowCounts(t, db, 1)
+}
@@ -1,11 +1,13 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
 	"net/http"
 	"strings"
 
 	login "github.com/drone/go-login/login/gitea"
+	"github.com/drone/go-scm/scm"
 	driver "github.com/drone/go-scm/scm/driver/gitea"
 	"github.com/drone/go-scm/scm/transport/oauth2"
 )
@@ -18,6 +20,14 @@ func (g *Gitea) RevisionURL(baseURL string, revision string) string {
 	return baseURL + "/src/commit/" + revision
 }
 
+func (g *Gitea) FindDescription(ctx context.Context, repo string) (string, error) {
+	return findDescription(ctx, g.client, "api/v1/repos/"+repo)
+}
+
+func (g *Gitea) FindByID(ctx context.Context, id string) (*scm.Repository, error) {
+	return findByFullName(ctx, g.client, "api/v1/repositories/"+id)
+}
+
 // from drone
 func defaultTransport(skipverify bool) http.RoundTripper {
 	return &http.Transport{
@@ -40,15 +50,13 @@ func NewGitea(id int64, url string, config login.Config) (*Gitea, error) {
 	gitea.client.Client = &http.Client{
 		Transport: &oauth2.Transport{
 			Scheme: oauth2.SchemeBearer,
-			Source: &oauth2.Refresher{
-				ClientID:     config.ClientID,
-				ClientSecret: config.ClientSecret,
-				Endpoint:     strings.TrimSuffix(url, "/") + "/login/oauth/access_token",
-				Source:       oauth2.ContextTokenSource(),
-			},
-			Base: defaultTransport( /*config.SkipVerify*/ false),
+			Source: oauth2.ContextTokenSource(),
+			Base:   defaultTransport( /*config.SkipVerify*/ false),
 		},
 	}
+
+	gitea.SetTokenEndpoint(strings.TrimSuffix(url, "/")+"/login/oauth/access_token",
+		config.ClientID, config.ClientSecret)
 	return gitea, nil
 }
 
@@ -65,5 +73,11 @@ func NewGiteaFromFile(id int64, filename string, url string, redirect_url string
 		RedirectURL:  redirect_url,
 	}
 
-	return NewGitea(id, url, config)
+	gitea, err := NewGitea(id, url, config)
+	if err != nil {
+		return nil, err
+	}
+
+	gitea.SetServiceToken(secret.ServiceToken)
+	return gitea, nil
 }
@@ -1,24 +1,62 @@
 package server
 
 import (
+	"context"
+	"net/http"
 	"net/url"
+	"strings"
 
 	login "github.com/drone/go-login/login/github"
+	"github.com/drone/go-scm/scm"
 	driver "github.com/drone/go-scm/scm/driver/github"
 )
 
 type Github struct {
 	BaseRepositoryManager
+	app *githubApp // nil if not configured
 }
 
 func (g *Github) RevisionURL(baseURL string, revision string) string {
 	return baseURL + "/tree/" + revision
 }
 
+func (g *Github) FindDescription(ctx context.Context, repo string) (string, error) {
+	return findDescription(ctx, g.client, "repos/"+repo)
+}
+
+func (g *Github) FindByID(ctx context.Context, id string) (*scm.Repository, error) {
+	return findByFullName(ctx, g.client, "repositories/"+id)
+}
+
+// ServiceToken returns an installation access token when mora is
+// configured as a GitHub App, otherwise a configured service token.
+func (g *Github) ServiceToken(ctx context.Context, repo string) (*scm.Token, error) {
+	if g.app != nil {
+		return g.app.Token(ctx, repo)
+	}
+	return g.BaseRepositoryManager.ServiceToken(ctx, repo)
+}
+
+// acceptJSON is a transport to request a json response, which the token
+// endpoint of GitHub returns only with the Accept header.
+type acceptJSON struct{}
+
+func (acceptJSON) RoundTrip(r *http.Request) (*http.Response, error) {
+	r2 := r.Clone(r.Context())
+	r2.Header.Set("Accept", "application/json")
+	return http.DefaultTransport.RoundTrip(r2)
+}
+
 func NewGithub(id int64, urlstr string, config login.Config) *Github {
 	url, _ := url.Parse(urlstr)
 	github := new(Github)
 	github.Init(id, url, driver.NewDefault(), &config)
+	github.gitUsername = "x-access-token" // also for installation tokens
+
+	// user access tokens expire when expiration is enabled in an app
+	github.SetTokenEndpoint(strings.TrimSuffix(urlstr, "/")+"/login/oauth/access_token",
+		config.ClientID, config.ClientSecret)
+	github.refresher.Client = &http.Client{Transport: acceptJSON{}}
 
 	return github
 }
@@ -35,5 +73,16 @@ func NewGithubFromFile(id int64, url, filename string) (*Github, error) {
 		Scope:        []string{"repo"},
 	}
 
-	return NewGithub(id, url, config), nil
+	github := NewGithub(id, url, config)
+	github.SetServiceToken(secret.ServiceToken)
+
+	if secret.AppID != 0 {
+		key, err := readPrivateKey(secret.AppPrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		github.app = newGithubApp(secret.AppID, key, github.client.BaseURL)
+	}
+
+	return github, nil
 }
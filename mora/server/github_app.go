@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone/go-scm/scm"
+)
+
+// githubApp authenticates as a GitHub App. It signs a JWT with a private key
+// of the app, and exchanges it for installation access tokens, which are
+// cached until they expire.
+type githubApp struct {
+	id     int64
+	key    *rsa.PrivateKey
+	apiURL *url.URL
+	client *http.Client
+	now    func() time.Time
+
+	sync.Mutex
+	installations map[string]int64     // [repo]installation ID
+	tokens        map[int64]*scm.Token // [installation ID]
+}
+
+func newGithubApp(id int64, key *rsa.PrivateKey, apiURL *url.URL) *githubApp {
+	return &githubApp{
+		id:            id,
+		key:           key,
+		apiURL:        apiURL,
+		client:        http.DefaultClient,
+		now:           time.Now,
+		installations: map[string]int64{},
+		tokens:        map[int64]*scm.Token{},
+	}
+}
+
+// jwt returns a JWT signed by RS256 to authenticate as the app.
+func (a *githubApp) jwt() (string, error) {
+	now := a.now()
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	// issued 60 seconds in the past to allow for clock drift
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-60 * time.Second).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": a.id,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + enc.EncodeToString(signature), nil
+}
+
+// githubAppError is an error response from the API.
+type githubAppError struct {
+	method, path string
+	status       int
+}
+
+func (e *githubAppError) Error() string {
+	return fmt.Sprintf("github app: %s %s: status=%d", e.method, e.path, e.status)
+}
+
+// do sends a request authenticated as the app, and decodes a response into
+// out.
+func (a *githubApp) do(ctx context.Context, method, path string, out interface{}) error {
+	jwt, err := a.jwt()
+	if err != nil {
+		return err
+	}
+
+	u, err := a.apiURL.Parse(path)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return &githubAppError{method, path, res.StatusCode}
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func (a *githubApp) findInstallation(ctx context.Context, repo string) (int64, error) {
+	a.Lock()
+	id, ok := a.installations[repo]
+	a.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	var out struct {
+		ID int64 `json:"id"`
+	}
+	err := a.do(ctx, http.MethodGet, "repos/"+repo+"/installation", &out)
+	if err != nil {
+		return 0, err
+	}
+
+	a.Lock()
+	a.installations[repo] = out.ID
+	a.Unlock()
+
+	return out.ID, nil
+}
+
+// Token returns an installation access token for repo.
+func (a *githubApp) Token(ctx context.Context, repo string) (*scm.Token, error) {
+	repo = strings.Trim(repo, "/")
+
+	installation, err := a.findInstallation(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	a.Lock()
+	token, ok := a.tokens[installation]
+	a.Unlock()
+	if ok && !tokenExpired(*token, a.now()) {
+		return token, nil
+	}
+
+	var out struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	path := fmt.Sprintf("app/installations/%d/access_tokens", installation)
+	err = a.do(ctx, http.MethodPost, path, &out)
+	if err != nil {
+		// the app may have been uninstalled or reinstalled with a new ID
+		var appErr *githubAppError
+		if errors.As(err, &appErr) &&
+			(appErr.status == http.StatusNotFound || appErr.status == http.StatusUnauthorized) {
+			a.Lock()
+			delete(a.installations, repo)
+			delete(a.tokens, installation)
+			a.Unlock()
+		}
+		return nil, err
+	}
+
+	token = &scm.Token{Token: out.Token, Expires: out.ExpiresAt}
+
+	a.Lock()
+	a.tokens[installation] = token
+	a.Unlock()
+
+	return token, nil
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// verifyJWT verifies a JWT signed by RS256, and returns its claims.
+func verifyJWT(key *rsa.PublicKey, jwt string) (map[string]int64, error) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed jwt: %s", jwt)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	claims := map[string]int64{}
+	err = json.Unmarshal(b, &claims)
+	return claims, err
+}
+
+func TestGithubApp_Token(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	now := time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC)
+	exchanged := 0
+	installation := 42
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := verifyJWT(&key.PublicKey, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if err != nil || claims["iss"] != 1215 || claims["exp"] <= now.Unix() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/installation":
+			fmt.Fprintf(w, `{"id": %d}`, installation)
+		case r.Method == http.MethodPost &&
+			r.URL.Path == fmt.Sprintf("/app/installations/%d/access_tokens", installation):
+			exchanged++
+			fmt.Fprintf(w, `{"token": "installation%d", "expires_at": %q}`,
+				exchanged, now.Add(time.Hour).Format(time.RFC3339))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	apiURL, _ := url.Parse(ts.URL + "/")
+	app := newGithubApp(1215, key, apiURL)
+	app.now = func() time.Time { return now }
+
+	token, err := app.Token(context.Background(), "owner/repo")
+	require.NoError(t, err)
+	assert.Equal(t, "installation1", token.Token)
+	assert.True(t, token.Expires.Equal(now.Add(time.Hour)))
+
+	// cached
+	token, err = app.Token(context.Background(), "owner/repo")
+	require.NoError(t, err)
+	assert.Equal(t, "installation1", token.Token)
+	assert.Equal(t, 1, exchanged)
+
+	// refreshed before expiry
+	now = now.Add(time.Hour - 30*time.Second)
+	token, err = app.Token(context.Background(), "owner/repo")
+	require.NoError(t, err)
+	assert.Equal(t, "installation2", token.Token)
+	assert.Equal(t, 2, exchanged)
+
+	// not installed
+	_, err = app.Token(context.Background(), "owner/other")
+	require.Error(t, err)
+
+	// reinstalled. The cached installation is dropped on failure.
+	installation = 43
+	now = now.Add(time.Hour)
+	_, err = app.Token(context.Background(), "owner/repo")
+	require.Error(t, err)
+
+	token, err = app.Token(context.Background(), "owner/repo")
+	require.NoError(t, err)
+	assert.Equal(t, "installation3", token.Token)
+}
+
+func TestNewGithubFromFile_App(t *testing.T) {
+	dir := t.TempDir()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	keyFile := filepath.Join(dir, "app.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, os.WriteFile(keyFile, b, 0o600))
+
+	filename := filepath.Join(dir, "github.conf")
+	secret := fmt.Sprintf("ClientID = \"id\"\nAppID = 1215\nAppPrivateKeyFile = %q", keyFile)
+	require.NoError(t, os.WriteFile(filename, []byte(secret), 0o600))
+
+	rm, err := NewGithubFromFile(1, "https://github.com", filename)
+	require.NoError(t, err)
+	require.NotNil(t, rm.app)
+	assert.Equal(t, int64(1215), rm.app.id)
+	assert.Equal(t, "https://api.github.com/", rm.app.apiURL.String())
+
+	// no private key
+	secret = "ClientID = \"id\"\nAppID = 1215"
+	require.NoError(t, os.WriteFile(filename, []byte(secret), 0o600))
+
+	_, err = NewGithubFromFile(1, "https://github.com", filename)
+	require.Error(t, err)
+}
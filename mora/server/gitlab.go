@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	login "github.com/drone/go-login/login/gitlab"
+	"github.com/drone/go-scm/scm"
+	driver "github.com/drone/go-scm/scm/driver/gitlab"
+	"github.com/drone/go-scm/scm/transport/oauth2"
+)
+
+type Gitlab struct {
+	BaseRepositoryManager
+}
+
+func (g *Gitlab) RevisionURL(baseURL string, revision string) string {
+	return baseURL + "/-/commit/" + revision
+}
+
+func (g *Gitlab) FindDescription(ctx context.Context, repo string) (string, error) {
+	return findDescription(ctx, g.client, "api/v4/projects/"+strings.ReplaceAll(repo, "/", "%2F"))
+}
+
+// FindByID finds a repository by its ID, which the API accepts in place of
+// a path.
+func (g *Gitlab) FindByID(ctx context.Context, id string) (*scm.Repository, error) {
+	found, _, err := g.client.Repositories.Find(ctx, id)
+	return found, err
+}
+
+func NewGitlab(id int64, url string, config login.Config) (*Gitlab, error) {
+	client, err := driver.New(url)
+	if err != nil {
+		return nil, err
+	}
+
+	gitlab := new(Gitlab)
+	gitlab.Init(id, client.BaseURL, client, &config)
+
+	gitlab.client.Client = &http.Client{
+		Transport: &oauth2.Transport{
+			Scheme: oauth2.SchemeBearer,
+			Source: oauth2.ContextTokenSource(),
+		},
+	}
+
+	// access tokens of GitLab expire in two hours
+	gitlab.SetTokenEndpoint(strings.TrimSuffix(url, "/")+"/oauth/token",
+		config.ClientID, config.ClientSecret)
+	return gitlab, nil
+}
+
+func NewGitlabFromFile(id int64, filename string, url string, redirect_url string) (*Gitlab, error) {
+	secret, err := readSecret(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	config := login.Config{
+		ClientID:     secret.ClientID,
+		ClientSecret: secret.ClientSecret,
+		Server:       url,
+		RedirectURL:  redirect_url,
+		Scope:        []string{"read_user", "read_api"},
+	}
+
+	gitlab, err := NewGitlab(id, url, config)
+	if err != nil {
+		return nil, err
+	}
+
+	gitlab.SetServiceToken(secret.ServiceToken)
+	return gitlab, nil
+}
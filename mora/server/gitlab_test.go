@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/drone/go-login/login"
+	"github.com/drone/go-login/login/gitlab"
+	"github.com/drone/go-scm/scm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeGitlab returns a server which serves a part of GitLab API used by
+// mora.
+func newFakeGitlab(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "code0", r.PostForm.Get("code"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token": "token0", "refresh_token": "refresh0", "expires_in": 7200}`)
+	})
+
+	mux.HandleFunc("/api/v4/projects/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token0" {
+			http.Error(w, `{"message": "401 Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.EscapedPath() != "/api/v4/projects/group%2Fsubgroup%2Frepo" {
+			http.NotFound(w, r)
+			return
+		}
+
+		fmt.Fprint(w, `{
+			"id": 1215,
+			"path": "repo",
+			"path_with_namespace": "group/subgroup/repo",
+			"namespace": {"path": "subgroup", "full_path": "group/subgroup"},
+			"default_branch": "main",
+			"visibility": "internal",
+			"web_url": "https://gitlab.example.com/group/subgroup/repo",
+			"description": "a project"
+		}`)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func TestGitlab_Login(t *testing.T) {
+	ts := newFakeGitlab(t)
+	defer ts.Close()
+
+	rm, err := NewGitlab(1, ts.URL, gitlab.Config{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Server:       ts.URL,
+		RedirectURL:  "http://mora.example.com/login",
+	})
+	require.NoError(t, err)
+
+	var token *login.Token
+	handler := rm.LoginHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, login.ErrorFrom(r.Context()))
+		token = login.TokenFrom(r.Context())
+	}))
+
+	// redirect to GitLab
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login", nil))
+	res := w.Result()
+	require.Equal(t, http.StatusSeeOther, res.StatusCode)
+
+	loc, err := res.Location()
+	require.NoError(t, err)
+	assert.Equal(t, ts.URL+"/oauth/authorize", loc.Scheme+"://"+loc.Host+loc.Path)
+	state := loc.Query().Get("state")
+
+	// redirect back from GitLab
+	path := "/login?" + url.Values{"code": {"code0"}, "state": {state}}.Encode()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for _, c := range res.Cookies() {
+		req.AddCookie(c)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.NotNil(t, token)
+	assert.Equal(t, "token0", token.Access)
+	assert.Equal(t, "refresh0", token.Refresh)
+}
+
+func TestGitlab_Repositories(t *testing.T) {
+	ts := newFakeGitlab(t)
+	defer ts.Close()
+
+	rm, err := NewGitlab(1, ts.URL, gitlab.Config{})
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), scm.TokenKey{}, &scm.Token{Token: "token0"})
+
+	found, _, err := rm.Client().Repositories.Find(ctx, "group/subgroup/repo")
+	require.NoError(t, err)
+	assert.Equal(t, "1215", found.ID)
+	assert.Equal(t, "group/subgroup", found.Namespace)
+	assert.Equal(t, "repo", found.Name)
+
+	synced := syncedRepository(Repository{Id: 1}, found)
+	assert.Equal(t, "main", synced.DefaultBranch)
+	assert.Equal(t, "internal", synced.Visibility)
+	assert.Equal(t, "https://gitlab.example.com/group/subgroup/repo", synced.Url)
+
+	description, err := rm.FindDescription(ctx, "group/subgroup/repo")
+	require.NoError(t, err)
+	assert.Equal(t, "a project", description)
+
+	// without a token
+	_, _, err = rm.Client().Repositories.Find(context.Background(), "group/subgroup/repo")
+	assert.Error(t, err)
+}
+
+func TestGitlab_RevisionURL(t *testing.T) {
+	rm, err := NewGitlab(1, "https://gitlab.example.com", gitlab.Config{})
+	require.NoError(t, err)
+
+	assert.Equal(t,
+		"https://gitlab.example.com/group/repo/-/commit/0123abc",
+		rm.RevisionURL("https://gitlab.example.com/group/repo", "0123abc"))
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/drone/go-login/login"
+	"github.com/drone/go-scm/scm"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/pelletier/go-toml/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Local is a repository manager serving bare git repositories in a local
+// directory for deployments without a hosted repository manager. A
+// repository at dir/group/repo.git is group/repo.
+//
+// Users are authenticated by HTTP basic authentication with a users file
+// (see localUsers). All users can read all repositories.
+type Local struct {
+	BaseRepositoryManager
+}
+
+// RevisionURL returns an empty string because there is no web UI for
+// local repositories.
+func (l *Local) RevisionURL(baseURL string, revision string) string {
+	return ""
+}
+
+// GitAuth returns nil because local repositories are fetched from the file
+// system.
+func (l *Local) GitAuth(token *scm.Token) transport.AuthMethod {
+	return nil
+}
+
+type (
+	localUser struct {
+		Name     string `toml:"name"`
+		Password string `toml:"password"` // hashed by bcrypt, e.g. `htpasswd -nbB user password`
+		Admin    bool   `toml:"admin"`
+	}
+
+	// localUsers is a users file of a local repository manager such as:
+	//
+	//	[[users]]
+	//	name = "alice"
+	//	password = "$2y$05$..."
+	//	admin = true
+	localUsers struct {
+		Users []localUser `toml:"users"`
+	}
+
+	// localLogin is a login middleware which authenticates users with
+	// localUsers. A user name is used as a token.
+	localLogin struct {
+		users map[string]localUser
+	}
+)
+
+func readLocalUsers(filename string) (map[string]localUser, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var file localUsers
+	err = toml.Unmarshal(b, &file)
+	if err != nil {
+		return nil, err
+	}
+
+	users := map[string]localUser{}
+	for _, u := range file.Users {
+		if u.Name == "" || u.Password == "" {
+			return nil, errors.New("ConfigError: name or password of a user is empty")
+		}
+		users[u.Name] = u
+	}
+
+	return users, nil
+}
+
+func (l *localLogin) authenticate(r *http.Request) (localUser, bool) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return localUser{}, false
+	}
+
+	user, ok := l.users[name]
+	if !ok {
+		return localUser{}, false
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	return user, err == nil
+}
+
+func (l *localLogin) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := l.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="mora", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		ctx := login.WithToken(r.Context(), &login.Token{Access: user.Name})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// userFrom returns a user logged in with a token in ctx.
+func (l *localLogin) userFrom(ctx context.Context) (localUser, bool) {
+	token, ok := ctx.Value(scm.TokenKey{}).(*scm.Token)
+	if !ok || token == nil {
+		return localUser{}, false
+	}
+
+	user, ok := l.users[token.Token]
+	return user, ok
+}
+
+// localDir returns a directory of repositories from a URL such as
+// file:///srv/git.
+func localDir(u *url.URL) (string, error) {
+	if u.Scheme != "file" || u.Path == "" {
+		return "", errors.New("ConfigError: url of a local repository manager has to be file:///path/to/dir")
+	}
+	return strings.TrimSuffix(u.Path, "/"), nil
+}
+
+func NewLocal(id int64, urlstr string, users map[string]localUser) (*Local, error) {
+	u, err := url.Parse(urlstr)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := localDir(u)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &localLogin{users: users}
+
+	local := new(Local)
+	local.Init(id, u, newLocalClient(dir, u.String(), auth), auth)
+	return local, nil
+}
+
+func NewLocalFromFile(id int64, url, filename string) (*Local, error) {
+	users, err := readLocalUsers(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLocal(id, url, users)
+}
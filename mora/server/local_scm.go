@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/drone/go-scm/scm"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// A go-scm client for local repositories. Services embed go-scm interfaces
+// which are nil, i.e. methods not used by mora are not implemented.
+
+type (
+	localRepositories struct {
+		dir  string
+		url  string
+		auth *localLogin
+	}
+
+	localRepositoryService struct {
+		scm.RepositoryService
+		*localRepositories
+	}
+
+	localContentService struct {
+		scm.ContentService
+		*localRepositories
+	}
+
+	localGitService struct {
+		scm.GitService
+		*localRepositories
+	}
+)
+
+func newLocalClient(dir, url string, auth *localLogin) *scm.Client {
+	repos := &localRepositories{dir: dir, url: strings.TrimSuffix(url, "/"), auth: auth}
+
+	client := new(scm.Client)
+	client.Repositories = &localRepositoryService{localRepositories: repos}
+	client.Contents = &localContentService{localRepositories: repos}
+	client.Git = &localGitService{localRepositories: repos}
+	return client
+}
+
+// path returns a directory of a repository such as group/repo.
+func (l *localRepositories) path(repo string) (string, error) {
+	if !strings.Contains(repo, "/") {
+		return "", scm.ErrNotFound
+	}
+
+	for _, elem := range strings.Split(repo, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return "", scm.ErrNotFound
+		}
+	}
+
+	return filepath.Join(l.dir, filepath.FromSlash(repo)+".git"), nil
+}
+
+func (l *localRepositories) open(repo string) (*git.Repository, error) {
+	path, err := l.path(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := git.PlainOpen(path)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil, scm.ErrNotFound
+	}
+	return r, err
+}
+
+func (l *localRepositories) convertRepository(repo string, r *git.Repository) *scm.Repository {
+	i := strings.LastIndex(repo, "/")
+	namespace, name := repo[:i], repo[i+1:]
+
+	branch := ""
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err == nil && head.Target().IsBranch() {
+		branch = head.Target().Short()
+	}
+
+	return &scm.Repository{
+		Namespace:  namespace,
+		Name:       name,
+		Branch:     branch,
+		Private:    true,
+		Visibility: scm.VisibilityPrivate,
+		Link:       l.url + "/" + repo + ".git",
+		Perm:       &scm.Perm{Pull: true},
+	}
+}
+
+// isBareRepository checks if dir looks like a bare repository
+func isBareRepository(dir string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// discover returns paths of repositories such as group/repo. Repositories
+// directly under the directory are ignored because they have no namespace.
+func (l *localRepositories) discover() ([]string, error) {
+	repos := []string{}
+	err := filepath.WalkDir(l.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || !strings.HasSuffix(path, ".git") || !isBareRepository(path) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.dir, strings.TrimSuffix(path, ".git"))
+		if err != nil {
+			return err
+		}
+		if strings.Contains(rel, string(filepath.Separator)) {
+			repos = append(repos, filepath.ToSlash(rel))
+		}
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(repos)
+	return repos, nil
+}
+
+func (s *localRepositoryService) Find(ctx context.Context, repo string) (*scm.Repository, *scm.Response, error) {
+	r, err := s.open(repo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.convertRepository(repo, r), &scm.Response{}, nil
+}
+
+func (s *localRepositoryService) FindPerms(ctx context.Context, repo string) (*scm.Perm, *scm.Response, error) {
+	_, err := s.open(repo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, _ := s.auth.userFrom(ctx)
+	return &scm.Perm{Pull: true, Admin: user.Admin}, &scm.Response{}, nil
+}
+
+// List returns all repositories in the first page.
+func (s *localRepositoryService) List(ctx context.Context, opts scm.ListOptions) ([]*scm.Repository, *scm.Response, error) {
+	paths, err := s.discover()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repos := []*scm.Repository{}
+	for _, path := range paths {
+		repo, _, err := s.Find(ctx, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		repos = append(repos, repo)
+	}
+
+	return repos, &scm.Response{}, nil
+}
+
+func (s *localContentService) Find(ctx context.Context, repo, path, ref string) (*scm.Content, *scm.Response, error) {
+	r, err := s.open(repo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hash, err := r.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, nil, scm.ErrNotFound
+	}
+
+	commit, err := r.CommitObject(*hash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := commit.File(path)
+	if err != nil {
+		return nil, nil, scm.ErrNotFound
+	}
+
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &scm.Content{
+		Path:   path,
+		Data:   []byte(contents),
+		Sha:    hash.String(),
+		BlobID: file.Hash.String(),
+	}, &scm.Response{}, nil
+}
+
+func (s *localGitService) findReference(repo string, name plumbing.ReferenceName) (*scm.Reference, *scm.Response, error) {
+	r, err := s.open(repo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hash, err := r.ResolveRevision(plumbing.Revision(name))
+	if err != nil {
+		return nil, nil, scm.ErrNotFound
+	}
+
+	return &scm.Reference{
+		Name: name.Short(),
+		Path: name.String(),
+		Sha:  hash.String(),
+	}, &scm.Response{}, nil
+}
+
+func (s *localGitService) FindBranch(ctx context.Context, repo, name string) (*scm.Reference, *scm.Response, error) {
+	return s.findReference(repo, plumbing.NewBranchReferenceName(name))
+}
+
+func (s *localGitService) FindTag(ctx context.Context, repo, name string) (*scm.Reference, *scm.Response, error) {
+	return s.findReference(repo, plumbing.NewTagReferenceName(name))
+}
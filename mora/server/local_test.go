@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/drone/go-login/login"
+	"github.com/drone/go-scm/scm"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// setupLocalRepositories creates bare repositories in a directory, and
+// returns the directory and a hash of a commit in the repositories.
+func setupLocalRepositories(t *testing.T) (string, string) {
+	src := t.TempDir()
+	r, err := git.PlainInit(src, false)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a.go"), []byte("package a"), 0o644))
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	_, err = w.Add("a.go")
+	require.NoError(t, err)
+	hash, err := w.Commit("commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "mora", Email: "mora@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+	_, err = r.CreateTag("v1.0", hash, &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "mora", Email: "mora@example.com", When: time.Now()},
+		Message: "v1.0",
+	})
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	for _, path := range []string{"group/repo.git", "group/sub/deep.git", "top.git"} {
+		_, err := git.PlainClone(filepath.Join(dir, path), true, &git.CloneOptions{URL: src})
+		require.NoError(t, err)
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "group", "empty.git"), 0o755))
+
+	return dir, hash.String()
+}
+
+func writeLocalUsers(t *testing.T) string {
+	alice, err := bcrypt.GenerateFromPassword([]byte("alice-password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	bob, err := bcrypt.GenerateFromPassword([]byte("bob-password"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	filename := filepath.Join(t.TempDir(), "users.toml")
+	users := fmt.Sprintf(`
+[[users]]
+name = "alice"
+password = %q
+admin = true
+
+[[users]]
+name = "bob"
+password = %q
+`, alice, bob)
+	require.NoError(t, os.WriteFile(filename, []byte(users), 0o600))
+
+	return filename
+}
+
+func newTestLocal(t *testing.T) (*Local, string) {
+	dir, hash := setupLocalRepositories(t)
+	rm, err := NewLocalFromFile(1, "file://"+dir, writeLocalUsers(t))
+	require.NoError(t, err)
+	return rm, hash
+}
+
+func withLocalUser(name string) context.Context {
+	return scm.WithContext(context.Background(), &scm.Token{Token: name})
+}
+
+func TestLocal_Repositories(t *testing.T) {
+	rm, _ := newTestLocal(t)
+	repos := rm.Client().Repositories
+	ctx := withLocalUser("alice")
+
+	list, _, err := repos.List(ctx, scm.ListOptions{Page: 1})
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "group", list[0].Namespace)
+	assert.Equal(t, "repo", list[0].Name)
+	assert.Equal(t, "group/sub", list[1].Namespace)
+	assert.Equal(t, "deep", list[1].Name)
+
+	found, _, err := repos.Find(ctx, "group/repo")
+	require.NoError(t, err)
+	assert.Equal(t, "master", found.Branch)
+	assert.Equal(t, rm.URL().String()+"/group/repo.git", found.Link)
+
+	for _, path := range []string{"top", "group/none", "group/../top", "/group/repo"} {
+		_, _, err = repos.Find(ctx, path)
+		assert.ErrorIs(t, err, scm.ErrNotFound, path)
+	}
+
+	perm, _, err := repos.FindPerms(ctx, "group/repo")
+	require.NoError(t, err)
+	assert.True(t, perm.Admin)
+
+	perm, _, err = repos.FindPerms(withLocalUser("bob"), "group/repo")
+	require.NoError(t, err)
+	assert.False(t, perm.Admin)
+}
+
+func TestLocal_Contents(t *testing.T) {
+	rm, hash := newTestLocal(t)
+	client := rm.Client()
+	ctx := withLocalUser("bob")
+
+	for _, ref := range []string{hash, "master"} {
+		content, _, err := client.Contents.Find(ctx, "group/repo", "a.go", ref)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("package a"), content.Data)
+		assert.Equal(t, hash, content.Sha)
+	}
+
+	_, _, err := client.Contents.Find(ctx, "group/repo", "b.go", hash)
+	assert.ErrorIs(t, err, scm.ErrNotFound)
+
+	branch, _, err := client.Git.FindBranch(ctx, "group/repo", "master")
+	require.NoError(t, err)
+	assert.Equal(t, hash, branch.Sha)
+
+	tag, _, err := client.Git.FindTag(ctx, "group/repo", "v1.0")
+	require.NoError(t, err)
+	assert.Equal(t, hash, tag.Sha)
+
+	_, _, err = client.Git.FindBranch(ctx, "group/repo", "v1.0")
+	assert.Error(t, err)
+}
+
+func TestLocal_Login(t *testing.T) {
+	rm, _ := newTestLocal(t)
+
+	var token *login.Token
+	handler := rm.LoginHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = login.TokenFrom(r.Context())
+	}))
+
+	login := func(name, password string) int {
+		token = nil
+		req := httptest.NewRequest(http.MethodGet, "/login", nil)
+		if name != "" {
+			req.SetBasicAuth(name, password)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result().StatusCode
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, login("", ""))
+	assert.Equal(t, http.StatusUnauthorized, login("alice", "bob-password"))
+	assert.Equal(t, http.StatusUnauthorized, login("carol", "alice-password"))
+	assert.Nil(t, token)
+
+	assert.Equal(t, http.StatusOK, login("alice", "alice-password"))
+	require.NotNil(t, token)
+	assert.Equal(t, "alice", token.Access)
+}
+
+func TestNewLocal_Error(t *testing.T) {
+	_, err := NewLocal(1, "/srv/git", nil)
+	assert.Error(t, err)
+
+	filename := filepath.Join(t.TempDir(), "users.toml")
+	require.NoError(t, os.WriteFile(filename, []byte("[[users]]\nname = \"alice\""), 0o600))
+	_, err = NewLocalFromFile(1, "file:///srv/git", filename)
+	assert.Error(t, err)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/drone/go-scm/scm"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/render"
+	"github.com/rs/zerolog/log"
+)
+
+// gitAuthenticator is implemented by repository managers which know how
+// their git servers take a token.
+type gitAuthenticator interface {
+	GitAuth(token *scm.Token) transport.AuthMethod
+}
+
+// mirrorAuth returns an auth method of rm using a token in ctx. nil is
+// returned when ctx has no token, i.e. a repository is fetched anonymously.
+func mirrorAuth(ctx context.Context, rm RepositoryManager) transport.AuthMethod {
+	token, ok := ctx.Value(scm.TokenKey{}).(*scm.Token)
+	if !ok || token == nil || token.Token == "" {
+		return nil
+	}
+
+	if a, ok := rm.(gitAuthenticator); ok {
+		return a.GitAuth(token)
+	}
+	return &githttp.BasicAuth{Username: "oauth2", Password: token.Token}
+}
+
+// fetchMirror starts fetching a mirror of repo in background.
+func (s *MoraServer) fetchMirror(ctx context.Context, repo base.Repository, auth transport.AuthMethod) {
+	go func() {
+		err := s.mirror.Fetch(ctx, repo, auth)
+		if err != nil {
+			log.Warn().Err(err).Msgf("fetchMirror: repo.Id=%d", repo.Id)
+		}
+	}()
+}
+
+// handleMirrorFetch starts fetching a mirror of a repository. This is
+// intended to be called from CI on push.
+func (s *MoraServer) handleMirrorFetch(w http.ResponseWriter, r *http.Request) {
+	repo, _ := base.RepoFrom(r.Context())
+	rm := s.findRepositoryManager(repo.RepositoryManager)
+	ctx := context.WithoutCancel(r.Context())
+
+	s.fetchMirror(ctx, repo, mirrorAuth(ctx, rm))
+	render.JSON(w, struct{}{}, http.StatusAccepted)
+}
+
+// handleMirrorWebhook starts fetching a mirror of a repository on a webhook
+// of its repository manager. Because a webhook has no token of a user, it is
+// not behind injectRepo but verified by its signature with the webhook
+// secret in settings of the repository. The mirror is fetched with a service
+// token.
+func (s *MoraServer) handleMirrorWebhook(w http.ResponseWriter, r *http.Request) {
+	repoID, err := strconv.ParseInt(chi.URLParam(r, "repo_id"), 10, 64)
+	if err != nil {
+		render.BadRequest(w, errors.New("invalid repository id"))
+		return
+	}
+
+	repo, err := s.repos.Find(repoID)
+	if err != nil {
+		render.NotFound(w, render.ErrNotFound)
+		return
+	}
+
+	rm := s.findRepositoryManager(repo.RepositoryManager)
+	if rm == nil || rm.Client().Webhooks == nil {
+		render.NotFound(w, render.ErrNotFound)
+		return
+	}
+
+	settings, err := s.settings.Find(repo.Id)
+	if err != nil {
+		log.Err(err).Msg("handleMirrorWebhook")
+		render.InternalError(w, errors.New("internal error"))
+		return
+	}
+	if settings.WebhookSecret == "" {
+		render.Forbidden(w, render.ErrForbidden)
+		return
+	}
+
+	_, err = rm.Client().Webhooks.Parse(r, func(scm.Webhook) (string, error) {
+		return settings.WebhookSecret, nil
+	})
+	if errors.Is(err, scm.ErrUnknownEvent) {
+		// such as a ping, which has nothing to fetch
+		render.JSON(w, struct{}{}, http.StatusOK)
+		return
+	} else if errors.Is(err, scm.ErrSignatureInvalid) {
+		render.Forbidden(w, render.ErrForbidden)
+		return
+	} else if err != nil {
+		render.BadRequest(w, err)
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	s.fetchMirror(ctx, repo, s.serviceMirrorAuth(ctx, repo))
+	render.JSON(w, struct{}{}, http.StatusAccepted)
+}
+
+// runMirror fetches mirrors of all repositories periodically until ctx is
+// done.
+func (s *MoraServer) runMirror(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		repos, err := s.repos.ListAll()
+		if err != nil {
+			log.Error().Err(err).Msg("runMirror")
+		} else {
+			s.mirror.FetchAll(ctx, repos,
+				func(repo base.Repository) transport.AuthMethod {
+					return s.serviceMirrorAuth(ctx, repo)
+				})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
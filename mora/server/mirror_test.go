@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone/go-login/login/bitbucket"
+	"github.com/drone/go-login/login/github"
+	"github.com/drone/go-login/login/gitlab"
+	"github.com/drone/go-login/login/stash"
+	"github.com/drone/go-scm/scm"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/mirror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_mirrorAuth(t *testing.T) {
+	rm := NewMockRepositoryManager(1)
+	assert.Nil(t, mirrorAuth(context.Background(), rm))
+
+	ctx := scm.WithContext(context.Background(), &scm.Token{Token: "token"})
+	assert.Equal(t, &githttp.BasicAuth{Username: "oauth2", Password: "token"}, mirrorAuth(ctx, rm))
+
+	github := NewGithub(1, "https://github.com", github.Config{})
+	assert.Equal(t, &githttp.BasicAuth{Username: "x-access-token", Password: "token"},
+		mirrorAuth(ctx, github))
+
+	gitlab, err := NewGitlab(1, "https://gitlab.com", gitlab.Config{})
+	require.NoError(t, err)
+	assert.Equal(t, &githttp.BasicAuth{Username: "oauth2", Password: "token"}, mirrorAuth(ctx, gitlab))
+
+	bitbucket := NewBitbucket(1, "https://bitbucket.org", bitbucket.Config{})
+	assert.Equal(t, &githttp.BasicAuth{Username: "x-token-auth", Password: "token"},
+		mirrorAuth(ctx, bitbucket))
+
+	stash, err := NewStash(1, "https://bitbucket.example.com", stash.Config{})
+	require.NoError(t, err)
+	assert.Equal(t, &githttp.TokenAuth{Token: "token"}, mirrorAuth(ctx, stash))
+}
+
+func TestServer_MirrorFetch(t *testing.T) {
+	src := t.TempDir()
+	r, err := git.PlainInit(src, false)
+	require.NoError(t, err)
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	_, err = w.Commit("initial", &git.CommitOptions{
+		AllowEmptyCommits: true,
+		Author:            &object.Signature{Name: "mora", Email: "mora@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	repo := Repository{RepositoryManager: 1, Namespace: "owner", Name: "repo", Url: src}
+
+	m, err := mirror.New(t.TempDir())
+	require.NoError(t, err)
+
+	server := NewMoraServerBuilder(t).
+		WithRepositoryManager(NewMockRepositoryManager(1)).
+		WithRepo(&repo).
+		WithSessionManager().
+		WithAPIKey("key").
+		Finish()
+	server.mirror = m
+
+	path := fmt.Sprintf("/api/repos/%d/mirror", repo.Id)
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	req.Header.Set("Authorization", "Bearer key")
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusAccepted, rec.Result().StatusCode)
+	require.Eventually(t, func() bool { return m.Has(repo) },
+		5*time.Second, 10*time.Millisecond)
+}
+
+func TestServer_MirrorWebhook(t *testing.T) {
+	src := t.TempDir()
+	r, err := git.PlainInit(src, false)
+	require.NoError(t, err)
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	_, err = w.Commit("initial", &git.CommitOptions{
+		AllowEmptyCommits: true,
+		Author:            &object.Signature{Name: "mora", Email: "mora@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	repo := Repository{RepositoryManager: 1, Namespace: "owner", Name: "repo", Url: src}
+
+	m, err := mirror.New(t.TempDir())
+	require.NoError(t, err)
+
+	server := NewMoraServerBuilder(t).
+		WithRepositoryManager(NewGithub(1, "https://github.com", github.Config{})).
+		WithRepo(&repo).
+		WithSettings().
+		WithSessionManager().
+		Finish()
+	server.mirror = m
+
+	post := func(secret string) int {
+		body := `{"ref": "refs/heads/main"}`
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+
+		path := fmt.Sprintf("/api/repos/%d/mirror/webhook", repo.Id)
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, req)
+		return rec.Result().StatusCode
+	}
+
+	// no secret is configured
+	require.Equal(t, http.StatusForbidden, post(""))
+
+	settings := base.NewRepositorySettings()
+	settings.WebhookSecret = "secret"
+	require.NoError(t, server.settings.Put(repo.Id, settings))
+
+	require.Equal(t, http.StatusForbidden, post("wrong"))
+	assert.False(t, m.Has(repo))
+
+	require.Equal(t, http.StatusAccepted, post("secret"))
+	require.Eventually(t, func() bool { return m.Has(repo) },
+		5*time.Second, 10*time.Millisecond)
+}
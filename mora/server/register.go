@@ -0,0 +1,291 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/drone/go-scm/scm"
+	"github.com/go-chi/chi/v5"
+	"github.com/iszk1215/mora/mora/render"
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog/log"
+)
+
+var errorRepositoryExists = errors.New("repository is already registered")
+
+type (
+	// handleRepoRegister
+	RepositoryRegisterRequest struct {
+		RepositoryManager int64  `json:"scm_id"`
+		Namespace         string `json:"namespace"`
+		Name              string `json:"name"`
+		Url               string `json:"url"` // required only with the API key
+	}
+
+	// handleImportableRepoList
+	ImportableRepositoryResponse struct {
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+		Url       string `json:"url"`
+		Private   bool   `json:"private"`
+		ID        int64  `json:"id,omitempty"` // ID in mora if registered
+	}
+)
+
+func isUniqueConstraintError(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+func putRepository(store RepositoryStore, repo *Repository) error {
+	err := store.Put(repo)
+	if isUniqueConstraintError(err) {
+		return errorRepositoryExists
+	}
+	return err
+}
+
+// listRepositories returns all repositories which a token in ctx can access.
+func listRepositories(ctx context.Context, client *scm.Client) ([]*scm.Repository, error) {
+	opts := scm.ListOptions{Page: 1, Size: 100}
+
+	all := []*scm.Repository{}
+	for {
+		repos, res, err := client.Repositories.List(ctx, opts)
+		if isTokenRejected(res) {
+			return nil, errorTokenExpired
+		} else if err != nil {
+			return nil, err
+		}
+		all = append(all, repos...)
+
+		if res == nil || res.Page.Next == 0 || res.Page.Next == opts.Page {
+			break
+		}
+		opts.Page = res.Page.Next
+	}
+
+	return all, nil
+}
+
+// handleImportableRepoList returns repositories in a repository manager which
+// a user can access. Registered repositories have IDs.
+func (s *MoraServer) handleImportableRepoList(w http.ResponseWriter, r *http.Request) {
+	rmID, err := strconv.ParseInt(chi.URLParam(r, "scm_id"), 10, 64)
+	if err != nil {
+		render.BadRequest(w, errors.New("invalid scm id"))
+		return
+	}
+
+	rm := s.findRepositoryManager(rmID)
+	if rm == nil {
+		render.NotFound(w, render.ErrNotFound)
+		return
+	}
+
+	sess, _ := MoraSessionFrom(r.Context())
+	ctx, err := sess.WithToken(r.Context(), rm)
+	if errors.Is(err, errorTokenExpired) {
+		render.Unauthorized(w, errorReloginRequired)
+		return
+	} else if err != nil {
+		render.Forbidden(w, render.ErrForbidden)
+		return
+	}
+
+	repos, err := listRepositories(ctx, rm.Client())
+	if err == errorTokenExpired {
+		sess.Remove(rm.ID())
+		render.Unauthorized(w, errorReloginRequired)
+		return
+	} else if err != nil {
+		log.Err(err).Msg("handleImportableRepoList")
+		render.InternalError(w, errors.New("internal error"))
+		return
+	}
+
+	registered, err := s.repos.ListAll()
+	if err != nil {
+		log.Err(err).Msg("handleImportableRepoList")
+		render.InternalError(w, errors.New("internal error"))
+		return
+	}
+
+	ids := map[string]int64{}
+	for _, repo := range registered {
+		if repo.RepositoryManager == rm.ID() {
+			ids[repo.Namespace+"/"+repo.Name] = repo.Id
+		}
+	}
+
+	resp := []ImportableRepositoryResponse{}
+	for _, repo := range repos {
+		resp = append(resp, ImportableRepositoryResponse{
+			Namespace: repo.Namespace,
+			Name:      repo.Name,
+			Url:       repo.Link,
+			Private:   repo.Private,
+			ID:        ids[repo.Namespace+"/"+repo.Name],
+		})
+	}
+
+	render.JSON(w, resp, http.StatusOK)
+}
+
+// handleRepoRegister registers a repository. A user has to be able to access
+// the repository in the repository manager. With the API key, the access is
+// not checked and the URL of the repository is required.
+func (s *MoraServer) handleRepoRegister(w http.ResponseWriter, r *http.Request) {
+	var req RepositoryRegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		render.BadRequest(w, err)
+		return
+	}
+
+	if req.Namespace == "" || req.Name == "" {
+		render.BadRequest(w, errors.New("namespace and name are required"))
+		return
+	}
+
+	rm := s.findRepositoryManager(req.RepositoryManager)
+	if rm == nil {
+		render.BadRequestf(w, "unknown scm id: %d", req.RepositoryManager)
+		return
+	}
+
+	repo := Repository{
+		RepositoryManager: rm.ID(),
+		Namespace:         req.Namespace,
+		Name:              req.Name,
+		Url:               req.Url,
+	}
+
+	apiKey := s.isAPIKeyRequest(r)
+	sess, _ := MoraSessionFrom(r.Context())
+	if apiKey {
+		if repo.Url == "" {
+			render.BadRequest(w, errors.New("url is required"))
+			return
+		}
+	} else {
+		ctx, err := sess.WithToken(r.Context(), rm)
+		if errors.Is(err, errorTokenExpired) {
+			render.Unauthorized(w, errorReloginRequired)
+			return
+		} else if err != nil {
+			render.Forbidden(w, render.ErrForbidden)
+			return
+		}
+
+		found, res, err := rm.Client().Repositories.Find(ctx, repo.Namespace+"/"+repo.Name)
+		if isTokenRejected(res) {
+			sess.Remove(rm.ID())
+			render.Unauthorized(w, errorReloginRequired)
+			return
+		} else if err != nil {
+			log.Warn().Err(err).Msg("handleRepoRegister")
+			render.NotFoundf(w, "repository not found: %s/%s", repo.Namespace, repo.Name)
+			return
+		}
+		repo = syncedRepository(repo, found)
+		repo.Url = found.Link
+	}
+
+	err := putRepository(s.repos, &repo)
+	if err == errorRepositoryExists {
+		render.ErrorCode(w, err, http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Err(err).Msg("handleRepoRegister")
+		render.InternalError(w, errors.New("internal error"))
+		return
+	}
+
+	log.Info().Msgf("Repository registered: id=%d url=%s", repo.Id, repo.Url)
+
+	if !apiKey {
+		sess.addReposCache(rm.ID(), repo.Id)
+	}
+
+	render.JSON(w, repo, http.StatusCreated)
+}
+
+// splitRepositoryPath splits a path of a repository URL into a namespace
+// and a name.
+func splitRepositoryPath(driver, path string) (string, string, error) {
+	if driver == "stash" {
+		return splitStashPath(path)
+	} else if driver == "local" {
+		path = strings.TrimSuffix(path, ".git")
+	}
+
+	i := strings.LastIndex(path, "/")
+	if i <= 0 || i == len(path)-1 {
+		return "", "", fmt.Errorf("no namespace or name in path: %s", path)
+	}
+	return path[:i], path[i+1:], nil
+}
+
+// AddRepository registers a repository by its URL such as
+// https://github.com/owner/repo. A repository manager of the repository is
+// found from config.
+func AddRepository(config MoraConfig, db *sqlx.DB, repoURL string) (Repository, error) {
+	rmStore := NewRepositoryManagerStore(db)
+	if err := rmStore.Init(); err != nil {
+		return Repository{}, err
+	}
+
+	repoStore := NewRepositoryStore(db)
+	if err := repoStore.Init(); err != nil {
+		return Repository{}, err
+	}
+
+	repoURL = strings.TrimSuffix(repoURL, "/")
+	for _, rmConfig := range config.RepositoryManagers {
+		rmConfig.URL = rmConfig.url()
+
+		prefix := strings.TrimSuffix(rmConfig.URL, "/") + "/"
+		if rmConfig.URL == "" || !strings.HasPrefix(repoURL, prefix) {
+			continue
+		}
+
+		path, err := url.PathUnescape(strings.TrimPrefix(repoURL, prefix))
+		if err != nil {
+			return Repository{}, err
+		}
+
+		namespace, name, err := splitRepositoryPath(rmConfig.Driver, path)
+		if err != nil {
+			return Repository{}, err
+		}
+
+		id, _, err := rmStore.FindURL(rmConfig.URL)
+		if err != nil {
+			return Repository{}, err
+		}
+		if id < 0 {
+			id, err = rmStore.Insert(rmConfig.Driver, rmConfig.URL)
+			if err != nil {
+				return Repository{}, err
+			}
+		}
+
+		repo := Repository{
+			RepositoryManager: id,
+			Namespace:         namespace,
+			Name:              name,
+			Url:               repoURL,
+		}
+		err = putRepository(repoStore, &repo)
+		return repo, err
+	}
+
+	return Repository{}, fmt.Errorf("no repository manager configured for %s", repoURL)
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/drone/go-scm/scm"
+	"github.com/golang/mock/gomock"
+	"github.com/iszk1215/mora/mora/mockscm"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_RepoRegister(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	scmRepos := []*scm.Repository{
+		{Namespace: "owner", Name: "repo0", Link: "https://scm.com/owner/repo0"},
+		{Namespace: "owner", Name: "repo1", Link: "https://scm.com/owner/repo1", Private: true},
+	}
+
+	repos := mockscm.NewMockRepositoryService(controller)
+	repos.EXPECT().List(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, opts scm.ListOptions) ([]*scm.Repository, *scm.Response, error) {
+			// one repository per page
+			res := &scm.Response{}
+			if opts.Page < len(scmRepos) {
+				res.Page.Next = opts.Page + 1
+			}
+			return scmRepos[opts.Page-1 : opts.Page], res, nil
+		}).AnyTimes()
+	repos.EXPECT().Find(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, name string) (*scm.Repository, *scm.Response, error) {
+			if name == "owner/revoked" {
+				return nil, &scm.Response{Status: http.StatusUnauthorized}, errors.New("unauthorized")
+			}
+			for _, r := range scmRepos {
+				if r.Namespace+"/"+r.Name == name {
+					return r, &scm.Response{}, nil
+				}
+			}
+			return nil, &scm.Response{}, scm.ErrNotFound
+		}).AnyTimes()
+
+	rm := NewMockRepositoryManager(1)
+	rm.loginHandler = MockLoginMiddleware{"/login"}.Handler
+	rm.client.Repositories = repos
+
+	server := NewMoraServerBuilder(t).WithRepositoryManager(rm).WithRepo().
+		WithSessionManager().WithAPIKey("key").Finish()
+	handler := server.Handler()
+	cookie := requireLogin(t, handler, rm.ID())
+
+	serve := func(req *http.Request, apiKey string) *http.Response {
+		if apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		} else {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	register := func(v RepositoryRegisterRequest, apiKey string) *http.Response {
+		body, err := json.Marshal(v)
+		require.NoError(t, err)
+		return serve(httptest.NewRequest(http.MethodPost, "/api/repos", bytes.NewBuffer(body)), apiKey)
+	}
+
+	t.Run("register", func(t *testing.T) {
+		res := register(RepositoryRegisterRequest{RepositoryManager: 1, Namespace: "owner", Name: "repo0"}, "")
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+
+		var got Repository
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+		assert.Equal(t, "https://scm.com/owner/repo0", got.Url)
+
+		stored, err := server.repos.Find(got.Id)
+		require.NoError(t, err)
+		assert.Equal(t, got, stored)
+	})
+
+	t.Run("duplicated", func(t *testing.T) {
+		res := register(RepositoryRegisterRequest{RepositoryManager: 1, Namespace: "owner", Name: "repo0"}, "")
+		require.Equal(t, http.StatusConflict, res.StatusCode)
+	})
+
+	t.Run("no access", func(t *testing.T) {
+		res := register(RepositoryRegisterRequest{RepositoryManager: 1, Namespace: "owner", Name: "secret"}, "")
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+
+	t.Run("unknown scm", func(t *testing.T) {
+		res := register(RepositoryRegisterRequest{RepositoryManager: 2, Namespace: "owner", Name: "repo1"}, "")
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+
+	t.Run("api key", func(t *testing.T) {
+		req := RepositoryRegisterRequest{RepositoryManager: 1, Namespace: "owner", Name: "repo2"}
+		res := register(req, "key")
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+		req.Url = "https://scm.com/owner/repo2"
+		res = register(req, "key")
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+	})
+
+	t.Run("without login", func(t *testing.T) {
+		body, err := json.Marshal(RepositoryRegisterRequest{RepositoryManager: 1, Namespace: "owner", Name: "repo1"})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/repos", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+	})
+
+	t.Run("importable repositories", func(t *testing.T) {
+		res := serve(httptest.NewRequest(http.MethodGet, "/api/scms/1/repos", nil), "")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		var got []ImportableRepositoryResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+		require.Equal(t, 2, len(got))
+
+		registered, err := server.repos.FindURL("https://scm.com/owner/repo0")
+		require.NoError(t, err)
+		assert.Equal(t, ImportableRepositoryResponse{
+			Namespace: "owner", Name: "repo0", Url: "https://scm.com/owner/repo0", ID: registered.Id,
+		}, got[0])
+		assert.Equal(t, ImportableRepositoryResponse{
+			Namespace: "owner", Name: "repo1", Url: "https://scm.com/owner/repo1", Private: true,
+		}, got[1])
+	})
+
+	t.Run("importable repositories without login", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/scms/1/repos", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+	})
+
+	// the token is removed from a session, then run at last
+	t.Run("rejected token", func(t *testing.T) {
+		res := register(RepositoryRegisterRequest{RepositoryManager: 1, Namespace: "owner", Name: "revoked"}, "")
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+		res = serve(httptest.NewRequest(http.MethodGet, "/api/scms/1/repos", nil), "")
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+	})
+}
+
+func TestAddRepository(t *testing.T) {
+	db, err := sqlx.Connect("sqlite3", ":memory:?_loc=auto")
+	require.NoError(t, err)
+
+	config := MoraConfig{
+		RepositoryManagers: []RepositoryManagerConfig{
+			{Driver: "gitea", URL: "https://gitea.example.com"},
+			{Driver: "github"},
+			{Driver: "stash", URL: "https://bitbucket.example.com"},
+			{Driver: "local", URL: "file:///srv/git"},
+		},
+	}
+
+	repo, err := AddRepository(config, db, "https://github.com/owner/repo/")
+	require.NoError(t, err)
+	assert.Equal(t, "owner", repo.Namespace)
+	assert.Equal(t, "repo", repo.Name)
+	assert.Equal(t, "https://github.com/owner/repo", repo.Url)
+
+	repo, err = AddRepository(config, db, "https://gitea.example.com/group/sub/repo")
+	require.NoError(t, err)
+	assert.Equal(t, "group/sub", repo.Namespace)
+	assert.Equal(t, "repo", repo.Name)
+
+	rmID, _, err := NewRepositoryManagerStore(db).FindURL("https://gitea.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, rmID, repo.RepositoryManager)
+
+	repo, err = AddRepository(config, db, "https://bitbucket.example.com/projects/PRJ/repos/repo/browse")
+	require.NoError(t, err)
+	assert.Equal(t, "PRJ", repo.Namespace)
+	assert.Equal(t, "repo", repo.Name)
+
+	_, err = AddRepository(config, db, "https://bitbucket.example.com/PRJ/repo")
+	assert.Error(t, err)
+
+	repo, err = AddRepository(config, db, "file:///srv/git/group/repo.git")
+	require.NoError(t, err)
+	assert.Equal(t, "group", repo.Namespace)
+	assert.Equal(t, "repo", repo.Name)
+
+	_, err = AddRepository(config, db, "https://github.com/owner/repo")
+	assert.Equal(t, errorRepositoryExists, err)
+
+	_, err = AddRepository(config, db, "https://gitlab.com/owner/repo")
+	assert.Error(t, err)
+
+	_, err = AddRepository(config, db, "https://github.com/repo")
+	assert.Error(t, err)
+}
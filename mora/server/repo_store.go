@@ -3,6 +3,7 @@ package server
 import (
 	"errors"
 
+	"github.com/iszk1215/mora/mora/migration"
 	"github.com/jmoiron/sqlx"
 	"github.com/rs/zerolog/log"
 )
@@ -14,6 +15,11 @@ CREATE TABLE IF NOT EXISTS repository (
     namespace TEXT NOT NULL,
     name TEXT NOT NULL,
     url TEXT NOT NULL UNIQUE,
+    scm_repo_id TEXT NOT NULL DEFAULT '',
+    default_branch TEXT NOT NULL DEFAULT '',
+    visibility TEXT NOT NULL DEFAULT '',
+    archived BOOLEAN NOT NULL DEFAULT FALSE,
+    description TEXT NOT NULL DEFAULT '',
     UNIQUE(scm, namespace, name)
 )`
 
@@ -24,6 +30,11 @@ type (
 		Namespace         string `db:"namespace"`
 		Name              string `db:"name"`
 		URL               string `db:"url"`
+		ScmRepoID         string `db:"scm_repo_id"`
+		DefaultBranch     string `db:"default_branch"`
+		Visibility        string `db:"visibility"`
+		Archived          bool   `db:"archived"`
+		Description       string `db:"description"`
 	}
 
 	repositoryStoreImpl struct {
@@ -35,13 +46,35 @@ func NewRepositoryStore(db *sqlx.DB) RepositoryStore {
 	return &repositoryStoreImpl{db}
 }
 
+// columns added after the repository table is introduced. Values are filled
+// when repositories are synced.
+var repositoryColumns = []migration.Column{
+	{Name: "scm_repo_id", Definition: "TEXT NOT NULL DEFAULT ''"},
+	{Name: "default_branch", Definition: "TEXT NOT NULL DEFAULT ''"},
+	{Name: "visibility", Definition: "TEXT NOT NULL DEFAULT ''"},
+	{Name: "archived", Definition: "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{Name: "description", Definition: "TEXT NOT NULL DEFAULT ''"},
+}
+
 func (s *repositoryStoreImpl) Init() error {
-	_, err := s.db.Exec(schema_repo)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // nolint:errcheck
+
+	_, err = tx.Exec(schema_repo)
 	if err != nil {
 		log.Err(err).Msg("")
 		return err
 	}
-	return nil
+
+	err = migration.AddColumns(tx, "repository", repositoryColumns)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func toRepo(from storableRepository) Repository {
@@ -51,9 +84,17 @@ func toRepo(from storableRepository) Repository {
 		Namespace:         from.Namespace,
 		Name:              from.Name,
 		Url:               from.URL,
+		ScmRepoId:         from.ScmRepoID,
+		DefaultBranch:     from.DefaultBranch,
+		Visibility:        from.Visibility,
+		Archived:          from.Archived,
+		Description:       from.Description,
 	}
 }
 
+const selectRepository = "SELECT id, scm, namespace, name, url, scm_repo_id," +
+	" default_branch, visibility, archived, description FROM repository"
+
 func (s *repositoryStoreImpl) findOne(query string, params ...interface{}) (Repository, error) {
 	rows := []storableRepository{}
 	err := s.db.Select(&rows, query, params...)
@@ -69,19 +110,22 @@ func (s *repositoryStoreImpl) findOne(query string, params ...interface{}) (Repo
 }
 
 func (s *repositoryStoreImpl) Find(id int64) (Repository, error) {
-	query := "SELECT id, scm, namespace, name, url FROM repository WHERE id = ?"
+	query := selectRepository + " WHERE id = ?"
 	return s.findOne(query, id)
 }
 
 func (s *repositoryStoreImpl) FindURL(url string) (Repository, error) {
-	query := "SELECT id, scm, namespace, name, url FROM repository WHERE url = ?"
+	query := selectRepository + " WHERE url = ?"
 	return s.findOne(query, url)
 }
 
 func (s *repositoryStoreImpl) Put(repo *Repository) error {
 	res, err := s.db.Exec(
-		"INSERT INTO repository (scm, namespace, name, url) VALUES ($1, $2, $3, $4)",
-		repo.RepositoryManager, repo.Namespace, repo.Name, repo.Url)
+		"INSERT INTO repository (scm, namespace, name, url, scm_repo_id,"+
+			" default_branch, visibility, archived, description)"+
+			" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		repo.RepositoryManager, repo.Namespace, repo.Name, repo.Url, repo.ScmRepoId,
+		repo.DefaultBranch, repo.Visibility, repo.Archived, repo.Description)
 	if err != nil {
 		return err
 	}
@@ -90,9 +134,32 @@ func (s *repositoryStoreImpl) Put(repo *Repository) error {
 	return err
 }
 
+// Update updates a repository in place, i.e. its ID and its repository
+// manager are not changed.
+func (s *repositoryStoreImpl) Update(repo Repository) error {
+	res, err := s.db.Exec(
+		"UPDATE repository SET namespace = $1, name = $2, url = $3, scm_repo_id = $4,"+
+			" default_branch = $5, visibility = $6, archived = $7, description = $8"+
+			" WHERE id = $9",
+		repo.Namespace, repo.Name, repo.Url, repo.ScmRepoId,
+		repo.DefaultBranch, repo.Visibility, repo.Archived, repo.Description, repo.Id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("no repo")
+	}
+	return nil
+}
+
 func (s *repositoryStoreImpl) ListAll() ([]Repository, error) {
 	rows := []storableRepository{}
-	err := s.db.Select(&rows, "SELECT id, scm, name, namespace, url FROM repository")
+	err := s.db.Select(&rows, selectRepository)
 
 	if err != nil {
 		return nil, err
@@ -100,14 +167,7 @@ func (s *repositoryStoreImpl) ListAll() ([]Repository, error) {
 
 	repos := []Repository{}
 	for _, record := range rows {
-		repo := Repository{
-			Id:                record.ID,
-			RepositoryManager: record.RepositoryManager,
-			Namespace:         record.Namespace,
-			Name:              record.Name,
-			Url:               record.URL,
-		}
-		repos = append(repos, repo)
+		repos = append(repos, toRepo(record))
 	}
 
 	return repos, nil
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryStore_Update(t *testing.T) {
+	repo := Repository{RepositoryManager: 1, Namespace: "team-a", Name: "foo", Url: "https://scm.com/team-a/foo"}
+	store := setupRepositoryStore(t, &repo)
+
+	repo.Namespace = "team-b"
+	repo.Url = "https://scm.com/team-b/foo"
+	repo.ScmRepoId = "42"
+	repo.DefaultBranch = "main"
+	repo.Visibility = "private"
+	repo.Archived = true
+	repo.Description = "foo"
+	require.NoError(t, store.Update(repo))
+
+	got, err := store.Find(repo.Id)
+	require.NoError(t, err)
+	assert.Equal(t, repo, got)
+
+	got, err = store.FindURL("https://scm.com/team-b/foo")
+	require.NoError(t, err)
+	assert.Equal(t, repo, got)
+
+	repo.Id++
+	assert.Error(t, store.Update(repo))
+}
+
+func TestRepositoryStore_MigrateColumns(t *testing.T) {
+	db, err := sqlx.Connect("sqlite3", ":memory:?_loc=auto")
+	require.NoError(t, err)
+
+	_, err = db.Exec(`CREATE TABLE repository (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    scm INTEGER NOT NULL,
+    namespace TEXT NOT NULL,
+    name TEXT NOT NULL,
+    url TEXT NOT NULL UNIQUE,
+    UNIQUE(scm, namespace, name)
+)`)
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO repository (scm, namespace, name, url) VALUES (1, 'owner', 'repo', 'https://scm.com/owner/repo')")
+	require.NoError(t, err)
+
+	store := NewRepositoryStore(db)
+	require.NoError(t, store.Init())
+	require.NoError(t, store.Init()) // migrated only once
+
+	got, err := store.FindURL("https://scm.com/owner/repo")
+	require.NoError(t, err)
+	assert.Equal(t, Repository{
+		Id:                got.Id,
+		RepositoryManager: 1,
+		Namespace:         "owner",
+		Name:              "repo",
+		Url:               "https://scm.com/owner/repo",
+	}, got)
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/drone/go-scm/scm"
+	"github.com/rs/zerolog/log"
+)
+
+var errorRepositoryReplaced = errors.New("another repository is found at the path of a repository")
+
+// descriptionFinder is implemented by repository managers which can find a
+// description of a repository, which scm.Repository does not have.
+type descriptionFinder interface {
+	FindDescription(ctx context.Context, repo string) (string, error)
+}
+
+// repositoryIDFinder is implemented by repository managers which can find a
+// repository by its ID, i.e. scm.Repository.ID.
+type repositoryIDFinder interface {
+	FindByID(ctx context.Context, id string) (*scm.Repository, error)
+}
+
+// isSameRepository checks if found, a repository found at a repository
+// manager by a path of repo, is repo itself. A repository registered
+// before IDs are stored is treated as the same one.
+func isSameRepository(repo Repository, found *scm.Repository) bool {
+	return repo.ScmRepoId == "" || found.ID == "" || repo.ScmRepoId == found.ID
+}
+
+func visibilityOf(found *scm.Repository) string {
+	if found.Visibility != scm.VisibilityUndefined {
+		return found.Visibility.String()
+	}
+	if found.Private {
+		return "private"
+	}
+	return "public"
+}
+
+// syncedRepository returns repo updated with found. Because repository
+// managers redirect an old path of a renamed or transferred repository,
+// found may have a new namespace, name and URL.
+func syncedRepository(repo Repository, found *scm.Repository) Repository {
+	if found.Namespace == "" || found.Name == "" {
+		return repo
+	}
+
+	synced := repo
+	synced.Namespace = found.Namespace
+	synced.Name = found.Name
+	if found.ID != "" {
+		synced.ScmRepoId = found.ID
+	}
+	if found.Link != "" {
+		synced.Url = found.Link
+	}
+	if found.Branch != "" {
+		synced.DefaultBranch = found.Branch
+	}
+	synced.Visibility = visibilityOf(found)
+	synced.Archived = found.Archived
+	return synced
+}
+
+// updateRepository stores synced, which is repo synced with a repository
+// manager, when it is changed. It is updated in place so that coverages and
+// metrics of the repository are kept.
+func (s *MoraServer) updateRepository(repo, synced Repository) (Repository, error) {
+	if synced == repo {
+		return repo, nil
+	}
+
+	err := s.repos.Update(synced)
+	if err != nil {
+		return Repository{}, err
+	}
+
+	if synced.Url != repo.Url {
+		log.Info().Msgf("Repository moved: id=%d %s -> %s", repo.Id, repo.Url, synced.Url)
+	}
+	return synced, nil
+}
+
+// findRepository finds repo at rm by its path. When no repository or
+// another repository is found at the path, which happens when repo is renamed
+// or transferred and the path is deleted or reused, repo is found by its ID
+// if rm supports it. Otherwise an error of the path, or
+// errorRepositoryReplaced when another repository is found, is returned.
+func findRepository(ctx context.Context, rm RepositoryManager, repo Repository) (*scm.Repository, *scm.Response, error) {
+	found, res, err := rm.Client().Repositories.Find(ctx, repo.Namespace+"/"+repo.Name)
+	if err == nil && isSameRepository(repo, found) {
+		return found, res, nil
+	}
+	if err != nil && (repo.ScmRepoId == "" || !isNotFound(res, err)) {
+		return nil, res, err
+	}
+
+	if err == nil {
+		err = errorRepositoryReplaced
+	}
+
+	finder, ok := rm.(repositoryIDFinder)
+	if !ok {
+		return nil, res, err
+	}
+
+	found, idErr := finder.FindByID(ctx, repo.ScmRepoId)
+	if idErr != nil {
+		log.Warn().Err(idErr).Msgf("findRepository: id=%s", repo.ScmRepoId)
+		return nil, res, err
+	}
+	if !isSameRepository(repo, found) {
+		return nil, res, err
+	}
+
+	log.Info().Msgf("Repository found by id: id=%s %s/%s", repo.ScmRepoId, found.Namespace, found.Name)
+	return found, res, nil
+}
+
+// isNotFound returns true if a repository manager responds that a repository
+// is not found.
+func isNotFound(res *scm.Response, err error) bool {
+	return errors.Is(err, scm.ErrNotFound) || (res != nil && res.Status == http.StatusNotFound)
+}
+
+// syncRepository updates repo with found, which is found at a repository
+// manager when a user accesses repo.
+func (s *MoraServer) syncRepository(repo Repository, found *scm.Repository) (Repository, error) {
+	if !isSameRepository(repo, found) {
+		return Repository{}, errorRepositoryReplaced
+	}
+
+	return s.updateRepository(repo, syncedRepository(repo, found))
+}
+
+// syncMetadata finds repo at rm, and updates its path and metadata.
+func (s *MoraServer) syncMetadata(ctx context.Context, rm RepositoryManager, repo Repository) error {
+	ctx, err := withServiceToken(ctx, rm, repo)
+	if err != nil {
+		return err
+	}
+
+	found, _, err := findRepository(ctx, rm, repo)
+	if err != nil {
+		return err
+	}
+
+	synced := syncedRepository(repo, found)
+	if finder, ok := rm.(descriptionFinder); ok {
+		synced.Description, err = finder.FindDescription(ctx, synced.Namespace+"/"+synced.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = s.updateRepository(repo, synced)
+	return err
+}
+
+// syncRepositories syncs all repositories with their repository managers.
+// Repositories are found with service tokens. Without them, private
+// repositories are synced only when users access them.
+func (s *MoraServer) syncRepositories(ctx context.Context) {
+	repos, err := s.repos.ListAll()
+	if err != nil {
+		log.Error().Err(err).Msg("syncRepositories")
+		return
+	}
+
+	for _, repo := range repos {
+		rm := s.findRepositoryManager(repo.RepositoryManager)
+		if rm == nil {
+			continue
+		}
+
+		err := s.syncMetadata(ctx, rm, repo)
+		if err != nil {
+			log.Warn().Err(err).Msgf("syncRepositories: repo.Id=%d", repo.Id)
+		}
+	}
+}
+
+// runRepositorySync syncs repositories periodically until ctx is done.
+func (s *MoraServer) runRepositorySync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.syncRepositories(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/drone/go-login/login/github"
+	"github.com/drone/go-scm/scm"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/mockscm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_syncedRepository(t *testing.T) {
+	repo := Repository{
+		Id:                1,
+		RepositoryManager: 2,
+		Namespace:         "team-a",
+		Name:              "foo",
+		Url:               "https://scm.com/team-a/foo",
+	}
+
+	got := syncedRepository(repo, &scm.Repository{
+		ID:        "42",
+		Namespace: "team-b",
+		Name:      "bar",
+		Link:      "https://scm.com/team-b/bar",
+		Branch:    "main",
+		Private:   true,
+		Archived:  true,
+	})
+	want := Repository{
+		Id:                1,
+		RepositoryManager: 2,
+		Namespace:         "team-b",
+		Name:              "bar",
+		Url:               "https://scm.com/team-b/bar",
+		ScmRepoId:         "42",
+		DefaultBranch:     "main",
+		Visibility:        "private",
+		Archived:          true,
+	}
+	assert.Equal(t, want, got)
+
+	// nothing is changed by a repository without information
+	assert.Equal(t, repo, syncedRepository(repo, &scm.Repository{}))
+}
+
+func Test_visibilityOf(t *testing.T) {
+	assert.Equal(t, "public", visibilityOf(&scm.Repository{}))
+	assert.Equal(t, "private", visibilityOf(&scm.Repository{Private: true}))
+	assert.Equal(t, "internal",
+		visibilityOf(&scm.Repository{Private: true, Visibility: scm.VisibilityInternal}))
+}
+
+func Test_isSameRepository(t *testing.T) {
+	repo := Repository{ScmRepoId: "42"}
+	assert.True(t, isSameRepository(repo, &scm.Repository{ID: "42"}))
+	assert.False(t, isSameRepository(repo, &scm.Repository{ID: "43"}))
+	assert.True(t, isSameRepository(Repository{}, &scm.Repository{ID: "43"}))
+}
+
+func Test_injectRepo_Moved(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	repo := Repository{
+		RepositoryManager: 1,
+		Namespace:         "team-a",
+		Name:              "foo",
+		Url:               "https://scm.com/team-a/foo",
+	}
+
+	// the repository manager redirects the old path to the new one
+	found := &scm.Repository{
+		ID:        "42",
+		Namespace: "team-b",
+		Name:      "foo",
+		Link:      "https://scm.com/team-b/foo",
+	}
+	repos := mockscm.NewMockRepositoryService(controller)
+	repos.EXPECT().Find(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, path string) (*scm.Repository, *scm.Response, error) {
+			return found, &scm.Response{}, nil
+		}).AnyTimes()
+
+	rm := NewMockRepositoryManager(1)
+	rm.client.Repositories = repos
+
+	server := NewMoraServerBuilder(t).WithRepositoryManager(rm).WithRepo(&repo).Finish()
+
+	callInjectRepo := func() (int, Repository) {
+		var got Repository
+
+		r := chi.NewRouter()
+		r.Route("/{repo_id}", func(r chi.Router) {
+			r.Use(server.injectRepo)
+			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				got, _ = base.RepoFrom(r.Context())
+			})
+		})
+
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/%d", repo.Id), nil)
+		sess := NewMoraSessionWithTokenFor(rm)
+		req = req.WithContext(WithMoraSession(req.Context(), sess))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		return w.Result().StatusCode, got
+	}
+
+	want := Repository{
+		Id:                repo.Id,
+		RepositoryManager: 1,
+		Namespace:         "team-b",
+		Name:              "foo",
+		Url:               "https://scm.com/team-b/foo",
+		ScmRepoId:         "42",
+		Visibility:        "public",
+	}
+
+	status, got := callInjectRepo()
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, want, got)
+
+	stored, err := server.repos.Find(repo.Id)
+	require.NoError(t, err)
+	assert.Equal(t, want, stored)
+
+	// another repository is created at the path
+	found = &scm.Repository{
+		ID:        "43",
+		Namespace: "team-b",
+		Name:      "foo",
+		Link:      "https://scm.com/team-b/foo",
+	}
+	status, _ = callInjectRepo()
+	assert.Equal(t, http.StatusForbidden, status)
+
+	stored, err = server.repos.Find(repo.Id)
+	require.NoError(t, err)
+	assert.Equal(t, want, stored)
+}
+
+func TestServer_syncRepositories(t *testing.T) {
+	// fake GitHub API
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/owner/repo0", "/repos/owner/renamed":
+			fmt.Fprint(w, `{
+				"id": 42,
+				"owner": {"login": "owner"},
+				"name": "renamed",
+				"html_url": "https://github.com/owner/renamed",
+				"default_branch": "develop",
+				"private": true,
+				"visibility": "private",
+				"archived": true,
+				"description": "a renamed repository"
+			}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	rm := NewGithub(1, "https://github.com", github.Config{})
+	rm.client.BaseURL, _ = url.Parse(ts.URL + "/")
+
+	repo0 := Repository{RepositoryManager: 1, Namespace: "owner", Name: "repo0", Url: "https://github.com/owner/repo0"}
+	repo1 := Repository{RepositoryManager: 1, Namespace: "owner", Name: "repo1", Url: "https://github.com/owner/repo1"}
+	server := NewMoraServerBuilder(t).WithRepositoryManager(rm).WithRepo(&repo0, &repo1).Finish()
+
+	server.syncRepositories(context.Background())
+
+	got, err := server.repos.Find(repo0.Id)
+	require.NoError(t, err)
+	assert.Equal(t, Repository{
+		Id:                repo0.Id,
+		RepositoryManager: 1,
+		Namespace:         "owner",
+		Name:              "renamed",
+		Url:               "https://github.com/owner/renamed",
+		ScmRepoId:         "42",
+		DefaultBranch:     "develop",
+		Visibility:        "private",
+		Archived:          true,
+		Description:       "a renamed repository",
+	}, got)
+
+	// not found repository is not changed
+	got, err = server.repos.Find(repo1.Id)
+	require.NoError(t, err)
+	assert.Equal(t, repo1, got)
+
+	// the handler returns metadata
+	req := httptest.NewRequest(http.MethodGet, "/api/repos", nil)
+	req.Header.Set("Authorization", "Bearer key")
+	server.apiKey = "key"
+	server.sessionManager = NewMoraSessionManager()
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	var list []Repository
+	require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&list))
+	require.Len(t, list, 2)
+	assert.True(t, list[0].Archived)
+	assert.Equal(t, "develop", list[0].DefaultBranch)
+	assert.Equal(t, "a renamed repository", list[0].Description)
+}
+
+func TestServer_syncRepositories_PathReused(t *testing.T) {
+	// fake GitHub API where repo0 is renamed to moved, and another
+	// repository is created at the old path
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/owner/repo0":
+			fmt.Fprint(w, `{"id": 43, "owner": {"login": "owner"}, "name": "repo0",
+				"html_url": "https://github.com/owner/repo0"}`)
+		case "/repositories/42":
+			fmt.Fprint(w, `{"id": 42, "full_name": "owner/moved"}`)
+		case "/repos/owner/moved":
+			fmt.Fprint(w, `{"id": 42, "owner": {"login": "owner"}, "name": "moved",
+				"html_url": "https://github.com/owner/moved"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	rm := NewGithub(1, "https://github.com", github.Config{})
+	rm.client.BaseURL, _ = url.Parse(ts.URL + "/")
+
+	repo := Repository{RepositoryManager: 1, Namespace: "owner", Name: "repo0",
+		Url: "https://github.com/owner/repo0", ScmRepoId: "42"}
+	server := NewMoraServerBuilder(t).WithRepositoryManager(rm).WithRepo(&repo).Finish()
+
+	server.syncRepositories(context.Background())
+
+	got, err := server.repos.Find(repo.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "moved", got.Name)
+	assert.Equal(t, "https://github.com/owner/moved", got.Url)
+	assert.Equal(t, "42", got.ScmRepoId)
+}
+
+func TestServer_syncRepositories_PathDeleted(t *testing.T) {
+	// fake GitHub API where repo0 is renamed to moved, and the old path is
+	// not redirected
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repositories/42":
+			fmt.Fprint(w, `{"id": 42, "full_name": "owner/moved"}`)
+		case "/repos/owner/moved":
+			fmt.Fprint(w, `{"id": 42, "owner": {"login": "owner"}, "name": "moved",
+				"html_url": "https://github.com/owner/moved"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	rm := NewGithub(1, "https://github.com", github.Config{})
+	rm.client.BaseURL, _ = url.Parse(ts.URL + "/")
+
+	repo := Repository{RepositoryManager: 1, Namespace: "owner", Name: "repo0",
+		Url: "https://github.com/owner/repo0", ScmRepoId: "42"}
+	server := NewMoraServerBuilder(t).WithRepositoryManager(rm).WithRepo(&repo).Finish()
+
+	server.syncRepositories(context.Background())
+
+	got, err := server.repos.Find(repo.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "moved", got.Name)
+	assert.Equal(t, "https://github.com/owner/moved", got.Url)
+}
@@ -1,13 +1,22 @@
 package server
 
 import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
 	"net/http"
 	"net/url"
 	"os"
+	"time"
 
 	"github.com/drone/go-login/login"
 	"github.com/drone/go-scm/scm"
 	"github.com/drone/go-scm/scm/transport/oauth2"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/pelletier/go-toml/v2"
 )
 
@@ -16,6 +25,9 @@ type BaseRepositoryManager struct {
 	client          *scm.Client
 	url             *url.URL
 	loginMiddleware login.Middleware
+	serviceToken    string            // empty if not configured
+	refresher       *oauth2.Refresher // nil if tokens do not expire
+	gitUsername     string            // "oauth2" if empty
 }
 
 func (s *BaseRepositoryManager) Init(id int64, url *url.URL, client *scm.Client,
@@ -49,9 +61,107 @@ func (s *BaseRepositoryManager) LoginHandler(next http.Handler) http.Handler {
 	return s.loginMiddleware.Handler(next)
 }
 
+// GitAuth returns an auth method for git over HTTP with token. Git servers
+// take a token as a password of basic auth with a user name, which depends
+// on servers.
+func (s *BaseRepositoryManager) GitAuth(token *scm.Token) transport.AuthMethod {
+	username := s.gitUsername
+	if username == "" {
+		username = "oauth2"
+	}
+	return &githttp.BasicAuth{Username: username, Password: token.Token}
+}
+
+func (s *BaseRepositoryManager) SetServiceToken(token string) {
+	s.serviceToken = token
+}
+
+// ServiceToken returns a token configured for server-initiated calls. The
+// same token is used for all repositories.
+func (s *BaseRepositoryManager) ServiceToken(ctx context.Context, repo string) (*scm.Token, error) {
+	if s.serviceToken == "" {
+		return nil, errorServiceTokenNotConfigured
+	}
+	return &scm.Token{Token: s.serviceToken}, nil
+}
+
+// tokens are refreshed when they expire within this margin to allow for
+// clock drift
+const tokenExpiryMargin = time.Minute
+
+func tokenExpired(token scm.Token, now time.Time) bool {
+	return !token.Expires.IsZero() && now.Add(tokenExpiryMargin).After(token.Expires)
+}
+
+// SetTokenEndpoint enables to refresh expired tokens at endpoint.
+func (s *BaseRepositoryManager) SetTokenEndpoint(endpoint, clientID, clientSecret string) {
+	s.refresher = &oauth2.Refresher{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Endpoint:     endpoint,
+	}
+}
+
+// RefreshToken returns a refreshed token when token expires, otherwise token
+// as it is. errorTokenExpired is returned when token can not be refreshed.
+func (s *BaseRepositoryManager) RefreshToken(token scm.Token) (scm.Token, error) {
+	now := time.Now()
+	if !tokenExpired(token, now) {
+		return token, nil
+	}
+
+	if s.refresher == nil || token.Refresh == "" {
+		return scm.Token{}, errorTokenExpired
+	}
+
+	refreshed := token
+	err := s.refresher.Refresh(&refreshed)
+	if err != nil {
+		return scm.Token{}, fmt.Errorf("%w: %v", errorTokenExpired, err)
+	}
+
+	// some servers keep a refresh token, or issue tokens which do not expire,
+	// i.e. without expires_in.
+	if refreshed.Refresh == "" {
+		refreshed.Refresh = token.Refresh
+	}
+	if !refreshed.Expires.After(now) {
+		refreshed.Expires = time.Time{}
+	}
+
+	return refreshed, nil
+}
+
 type secret struct {
 	ClientID     string `yaml:"ClientID"`
 	ClientSecret string `yaml:"ClientSecret"`
+
+	// RSA private key in PEM used by Bitbucket Server
+	PrivateKeyFile string `yaml:"PrivateKeyFile"`
+
+	// token of a bot account used for server-initiated calls such as
+	// requests with the API key. optional
+	ServiceToken string `yaml:"ServiceToken"`
+
+	// GitHub App used for server-initiated calls instead of ServiceToken.
+	// optional
+	AppID             int64  `yaml:"AppID"`
+	AppPrivateKeyFile string `yaml:"AppPrivateKeyFile"`
+}
+
+// readPrivateKey reads an RSA private key in PEM. login.ParsePrivateKeyFile
+// is not used because it panics when a file is not PEM.
+func readPrivateKey(filename string) (*rsa.PrivateKey, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", filename)
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
 }
 
 func readSecret(filename string) (secret, error) {
@@ -68,3 +178,44 @@ func readSecret(filename string) (secret, error) {
 
 	return s, nil
 }
+
+// getRepositoryJSON decodes a repository in json returned by an API at path.
+func getRepositoryJSON(ctx context.Context, client *scm.Client, path string, out interface{}) error {
+	res, err := client.Do(ctx, &scm.Request{Method: http.MethodGet, Path: path})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.Status != http.StatusOK {
+		return fmt.Errorf("%s: status=%d", path, res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// findDescription finds a description of a repository with an API at path
+// which returns a repository in json.
+func findDescription(ctx context.Context, client *scm.Client, path string) (string, error) {
+	var out struct {
+		Description string `json:"description"`
+	}
+	err := getRepositoryJSON(ctx, client, path, &out)
+	return out.Description, err
+}
+
+// findByFullName finds a repository with an API at path which returns a
+// repository with its full name in json. The repository is found again by
+// the full name to be converted by the driver.
+func findByFullName(ctx context.Context, client *scm.Client, path string) (*scm.Repository, error) {
+	var out struct {
+		FullName string `json:"full_name"`
+	}
+	err := getRepositoryJSON(ctx, client, path, &out)
+	if err != nil {
+		return nil, err
+	}
+
+	found, _, err := client.Repositories.Find(ctx, out.FullName)
+	return found, err
+}
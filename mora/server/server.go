@@ -12,12 +12,14 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/drone/go-scm/scm"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/iszk1215/mora/mora/base"
 	"github.com/iszk1215/mora/mora/coverage"
+	"github.com/iszk1215/mora/mora/mirror"
 	"github.com/iszk1215/mora/mora/render"
 	"github.com/iszk1215/mora/mora/udm"
 	"github.com/jmoiron/sqlx"
@@ -26,6 +28,13 @@ import (
 
 var (
 	errorTokenNotFound = errors.New("token not found in a session")
+
+	// a token is expired and can not be refreshed, or is rejected by a
+	// repository manager
+	errorTokenExpired = errors.New("token expired")
+
+	// returned to a user with errorTokenExpired
+	errorReloginRequired = errors.New("re-login required")
 )
 
 type (
@@ -60,15 +69,33 @@ type (
 		FindURL(url string) (Repository, error)
 		ListAll() ([]Repository, error)
 		Put(repo *Repository) error
+		Update(repo Repository) error
+	}
+
+	RepositorySettingsStore interface {
+		Init() error
+		// Find returns default settings if a repository is not configured
+		Find(repoID int64) (*base.RepositorySettings, error)
+		Put(repoID int64, settings *base.RepositorySettings) error
 	}
 
 	MoraServer struct {
+		db                 *sqlx.DB // nil if repositories can not be deleted
 		repositoryManagers []RepositoryManager
 		repos              RepositoryStore
+		settings           RepositorySettingsStore // nil if disabled
 		coverage           *coverage.CoverageService
 		udm                *udm.Service
 		apiKey             string
 
+		retention     coverage.RetentionPolicy
+		pruneInterval time.Duration
+
+		mirror         *mirror.Mirror // nil if disabled
+		mirrorInterval time.Duration  // fetched only on request if zero
+
+		syncInterval time.Duration // disabled if zero
+
 		sessionManager     *MoraSessionManager
 		frontendFileServer http.Handler
 	}
@@ -116,10 +143,20 @@ func (s *MoraServer) handleRepoList(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		err = checkRepoAccess(sess, rm, repo)
-		if err == nil {
-			resp = append(resp, repo)
+		found, err := checkRepoAccess(sess, rm, repo)
+		if err != nil {
+			continue
 		}
+
+		if found != nil {
+			synced, err := s.syncRepository(repo, found)
+			if err != nil {
+				log.Err(err).Msgf("handleRepoList: repo.Id=%d", repo.Id)
+			} else {
+				repo = synced
+			}
+		}
+		resp = append(resp, repo)
 	}
 
 	render.JSON(w, resp, http.StatusOK)
@@ -141,44 +178,49 @@ func (s *MoraServer) handleRepositoryManagerList(w http.ResponseWriter, r *http.
 	render.JSON(w, resp, 200)
 }
 
-func checkRepoAccessByRepositoryManager(session *MoraSession, rm RepositoryManager, owner, name string) error {
-	ctx, err := session.WithToken(context.Background(), rm.ID())
+// isTokenRejected returns true if a repository manager rejects a token, e.g.
+// the token is revoked.
+func isTokenRejected(res *scm.Response) bool {
+	return res != nil && res.Status == http.StatusUnauthorized
+}
+
+func checkRepoAccessByRepositoryManager(session *MoraSession, rm RepositoryManager, repo Repository) (*scm.Repository, error) {
+	ctx, err := session.WithToken(context.Background(), rm)
 	if err != nil {
-		return err // errorTokenNotFound
+		return nil, err // errorTokenNotFound or errorTokenExpired
 	}
 
-	_, _, err = rm.Client().Repositories.Find(ctx, owner+"/"+name)
-	if err != nil {
-		return err
+	found, res, err := findRepository(ctx, rm, repo)
+	if isTokenRejected(res) {
+		session.Remove(rm.ID())
+		return nil, errorTokenExpired
+	} else if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return found, nil
 }
 
-// checkRepoAccess checks if token in session can access a repo 'owner/name'
-func checkRepoAccess(sess *MoraSession, rm RepositoryManager, repo Repository) error {
-	cache := sess.getReposCache(rm.ID())
-	_, ok := cache[repo.Id]
-	if ok {
+// checkRepoAccess checks if token in session can access a repo 'owner/name'.
+// A repository found at RepositoryManager is returned, or nil when the
+// access is found in cache.
+func checkRepoAccess(sess *MoraSession, rm RepositoryManager, repo Repository) (*scm.Repository, error) {
+	if sess.hasReposCache(rm.ID(), repo.Id) {
 		log.Print("checkRepoAccess: found in cache")
-		return nil
+		return nil, nil
 	}
 
-	err := checkRepoAccessByRepositoryManager(sess, rm, repo.Namespace, repo.Name)
+	found, err := checkRepoAccessByRepositoryManager(sess, rm, repo)
 	if err != nil {
 		log.Print("checkRepoAccess: no repo or no access at RepositoryManager")
-		return err
+		return nil, err
 	}
 	log.Print("checkRepoAccess: found in RepositoryManager: ", repo.Url)
 
 	// store cache
-	if cache == nil {
-		cache = map[int64]bool{}
-	}
-	cache[repo.Id] = true
-	sess.setReposCache(rm.ID(), cache)
+	sess.addReposCache(rm.ID(), repo.Id)
 
-	return err
+	return found, nil
 }
 
 func (s *MoraServer) injectRepo(next http.Handler) http.Handler {
@@ -212,26 +254,57 @@ func (s *MoraServer) injectRepo(next http.Handler) http.Handler {
 
 		if s.apiKey == "" || s.apiKey != token {
 			sess, _ := MoraSessionFrom(r.Context())
-			err = checkRepoAccess(sess, rm, repo)
-			if err == errorTokenNotFound {
+			found, err := checkRepoAccess(sess, rm, repo)
+			if err == nil {
+				// the token is not checked when the access is found in cache
+				ctx, err = sess.WithToken(ctx, rm)
+			}
+			if err == errorTokenNotFound || err == errorRepositoryReplaced {
 				render.Forbidden(w, render.ErrForbidden)
 				return
+			} else if errors.Is(err, errorTokenExpired) {
+				log.Warn().Err(err).Msg("injectRepo")
+				render.Unauthorized(w, errorReloginRequired)
+				return
 			} else if err != nil {
 				log.Err(err).Msg("injectRepo")
 				render.InternalError(w, errors.New("internal error"))
 				return
-			} else {
-				ctx, _ = sess.WithToken(ctx, rm.ID())
-				log.Print(ctx)
+			}
+
+			if found != nil {
+				repo, err = s.syncRepository(repo, found)
+				if err != nil {
+					log.Err(err).Msg("injectRepo")
+					render.InternalError(w, errors.New("internal error"))
+					return
+				}
 			}
 		} else {
 			log.Print("injectRepo: skip checking repo access")
+			ctx, err = withServiceToken(ctx, rm, repo)
+			if err != nil {
+				log.Err(err).Msg("injectRepo")
+				render.InternalError(w, errors.New("internal error"))
+				return
+			}
 		}
 
 		// ctx := r.Context()
 		// ctx = base.WithRepostioryManager(ctx, rm)
 		ctx = base.WithRepositoryClient(ctx, rm)
 		ctx = base.WithRepo(ctx, repo)
+
+		if s.settings != nil {
+			settings, err := s.settings.Find(repo.Id)
+			if err != nil {
+				log.Err(err).Msg("injectRepo")
+				render.InternalError(w, errors.New("internal error"))
+				return
+			}
+			ctx = base.WithSettings(ctx, settings)
+		}
+
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -244,11 +317,21 @@ func (s *MoraServer) Handler() http.Handler {
 	// api
 
 	r.Get("/api/scms", s.handleRepositoryManagerList)
+	r.Get("/api/scms/{scm_id}/repos", s.handleImportableRepoList)
 
 	r.Route("/api/repos", func(r chi.Router) {
 		r.Get("/", s.handleRepoList)
+		r.Post("/", s.handleRepoRegister)
+		if s.mirror != nil && s.settings != nil {
+			// called by repository managers without tokens of users
+			r.Post("/{repo_id}/mirror/webhook", s.handleMirrorWebhook)
+		}
 		r.Route("/{repo_id}", func(r chi.Router) {
 			r.Use(s.injectRepo)
+			if s.db != nil {
+				r.With(s.requireRepoAdmin).Delete("/", s.handleRepoDelete)
+			}
+
 			if s.coverage != nil {
 				r.Mount("/coverages", s.coverage.Handler())
 			}
@@ -256,6 +339,18 @@ func (s *MoraServer) Handler() http.Handler {
 			if s.udm != nil {
 				r.Mount("/udm", s.udm.Handler())
 			}
+
+			if s.mirror != nil {
+				r.Post("/mirror", s.handleMirrorFetch)
+			}
+
+			if s.settings != nil {
+				r.Route("/settings", func(r chi.Router) {
+					r.Use(s.requireRepoAdmin)
+					r.Get("/", s.handleSettings)
+					r.Put("/", s.handleSettingsUpdate)
+				})
+			}
 		})
 	})
 
@@ -285,11 +380,27 @@ func (s *MoraServer) Handler() http.Handler {
 	return r
 }
 
-func initRepositoryManager(config RepositoryManagerConfig, baseURL string, store RepositoryManagerStore) (RepositoryManager, error) {
-	if config.Driver == "github" && config.URL == "" {
-		config.URL = "https://github.com"
+// RunBackgroundJobs starts jobs running in background until ctx is done.
+func (s *MoraServer) RunBackgroundJobs(ctx context.Context) {
+	if s.coverage != nil && s.retention.Enabled() {
+		log.Info().Msgf("Start pruner: interval=%s", s.pruneInterval)
+		go s.coverage.RunPruner(ctx, s.retention, s.pruneInterval)
 	}
 
+	if s.mirror != nil && s.mirrorInterval > 0 {
+		log.Info().Msgf("Start mirror: interval=%s", s.mirrorInterval)
+		go s.runMirror(ctx, s.mirrorInterval)
+	}
+
+	if s.syncInterval > 0 {
+		log.Info().Msgf("Start repository sync: interval=%s", s.syncInterval)
+		go s.runRepositorySync(ctx, s.syncInterval)
+	}
+}
+
+func initRepositoryManager(config RepositoryManagerConfig, baseURL string, store RepositoryManagerStore) (RepositoryManager, error) {
+	config.URL = config.url()
+
 	if config.URL == "" {
 		return nil, errors.New("ConfigError: rm.url is empty")
 	}
@@ -323,6 +434,22 @@ func initRepositoryManager(config RepositoryManagerConfig, baseURL string, store
 			baseURL+"/login")
 	} else if config.Driver == "github" {
 		return NewGithubFromFile(id, config.URL, config.SecretFilename)
+	} else if config.Driver == "gitlab" {
+		return NewGitlabFromFile(
+			id,
+			config.SecretFilename,
+			config.URL,
+			baseURL+"/login")
+	} else if config.Driver == "bitbucket" {
+		return NewBitbucketFromFile(id, config.URL, config.SecretFilename, baseURL+"/login")
+	} else if config.Driver == "local" {
+		return NewLocalFromFile(id, config.URL, config.SecretFilename)
+	} else if config.Driver == "stash" {
+		return NewStashFromFile(
+			id,
+			config.SecretFilename,
+			config.URL,
+			baseURL+"/login")
 	}
 
 	return nil, fmt.Errorf("ConfigError: unknown repository manager: %s", config.Driver)
@@ -392,6 +519,11 @@ func NewMoraServerFromConfig(config MoraConfig) (*MoraServer, error) {
 		return nil, err
 	}
 
+	settingsStore := NewRepositorySettingsStore(db)
+	if err := settingsStore.Init(); err != nil {
+		return nil, err
+	}
+
 	repositoryManagers, err := initRepositoryManagers(config, rmStore)
 	if err != nil {
 		return nil, err
@@ -405,7 +537,40 @@ func NewMoraServerFromConfig(config MoraConfig) (*MoraServer, error) {
 		return nil, err
 	}
 
-	coverage, err := coverage.NewCoverageService(db)
+	retention, err := config.Retention.Policy()
+	if err != nil {
+		return nil, err
+	}
+
+	pruneInterval, err := config.Retention.PruneInterval()
+	if err != nil {
+		return nil, err
+	}
+
+	mirrorInterval, err := config.Mirror.FetchInterval()
+	if err != nil {
+		return nil, err
+	}
+
+	syncInterval, err := config.Sync.SyncInterval()
+	if err != nil {
+		return nil, err
+	}
+
+	var mirrors *mirror.Mirror
+	if config.Mirror.Dir != "" {
+		mirrors, err = mirror.New(config.Mirror.Dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	coverage, err := coverage.NewCoverageService(db, coverage.ServiceConfig{
+		SourceCacheSize:     config.SourceCache.size(),
+		SourceCacheDir:      config.SourceCache.Dir,
+		SourceCacheDiskSize: config.SourceCache.diskSize(),
+		Mirror:              mirrors,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -416,13 +581,20 @@ func NewMoraServerFromConfig(config MoraConfig) (*MoraServer, error) {
 	}
 
 	s := &MoraServer{
+		db:                 db,
 		sessionManager:     NewMoraSessionManager(),
 		repositoryManagers: repositoryManagers,
 		repos:              repoStore,
+		settings:           settingsStore,
 		frontendFileServer: frontendFileServer,
 		coverage:           coverage,
 		udm:                udm,
 		apiKey:             os.Getenv("MORA_API_KEY"),
+		retention:          retention,
+		pruneInterval:      pruneInterval,
+		mirror:             mirrors,
+		mirrorInterval:     mirrorInterval,
+		syncInterval:       syncInterval,
 	}
 
 	return s, err
@@ -10,11 +10,13 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/drone/go-scm/scm"
 	"github.com/go-chi/chi/v5"
 	"github.com/golang/mock/gomock"
 	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/coverage"
 	"github.com/iszk1215/mora/mora/mockscm"
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
@@ -61,6 +63,10 @@ func createMockRepoService(
 						Name:      r.Name,
 						Namespace: r.Namespace,
 						Link:      r.Url,
+						ID:        r.ScmRepoId,
+						Branch:    r.DefaultBranch,
+						Private:   r.Visibility == "private",
+						Archived:  r.Archived,
 					}
 					return &ret, &scm.Response{}, nil
 				}
@@ -82,13 +88,18 @@ func Test_checkRepoAccess(t *testing.T) {
 	cache := sess.getReposCache(rm.ID())
 	require.Equal(t, 0, len(cache))
 
-	err := checkRepoAccess(sess, rm, repo)
+	found, err := checkRepoAccess(sess, rm, repo)
 	require.NoError(t, err)
+	require.NotNil(t, found)
 
 	// cache has the repo
 	cache = sess.getReposCache(rm.ID())
 	require.NotNil(t, cache)
 	require.Equal(t, map[int64]bool{repo.Id: true}, cache)
+
+	found, err = checkRepoAccess(sess, rm, repo)
+	require.NoError(t, err)
+	require.Nil(t, found)
 }
 
 func Test_checkRepoAccess_NoAccess(t *testing.T) {
@@ -104,7 +115,7 @@ func Test_checkRepoAccess_NoAccess(t *testing.T) {
 	cache := sess.getReposCache(rm.ID())
 	require.Equal(t, 0, len(cache))
 
-	err := checkRepoAccess(sess, rm, repo1)
+	_, err := checkRepoAccess(sess, rm, repo1)
 	require.Error(t, err)
 
 	cache = sess.getReposCache(rm.ID())
@@ -136,6 +147,15 @@ func (b *MoraServerBuilder) WithRepo(repos ...*Repository) *MoraServerBuilder {
 	return b
 }
 
+func (b *MoraServerBuilder) WithSettings() *MoraServerBuilder {
+	db, err := sqlx.Connect("sqlite3", ":memory:?_loc=auto")
+	require.NoError(b.t, err)
+
+	b.Server.settings = NewRepositorySettingsStore(db)
+	require.NoError(b.t, b.Server.settings.Init())
+	return b
+}
+
 func (b *MoraServerBuilder) WithSessionManager() *MoraServerBuilder {
 	b.Server.sessionManager = NewMoraSessionManager()
 	return b
@@ -154,6 +174,7 @@ func Test_injectRepo(t *testing.T) {
 		Namespace:         "owner",
 		Name:              "repo",
 		Url:               "http://mock.com/owner/repo",
+		Visibility:        "public",
 	}
 
 	rm := NewMockRepositoryManager(1)
@@ -209,6 +230,28 @@ func Test_injectRepo(t *testing.T) {
 		require.Equal(t, http.StatusForbidden, status)
 	})
 
+	t.Run("expired token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, valid_path, nil)
+		sess := NewMoraSession()
+		sess.setToken(rm.ID(), scm.Token{Token: "token", Expires: time.Now().Add(-time.Hour)})
+		req = req.WithContext(WithMoraSession(req.Context(), sess))
+
+		status, _ := callInjectRepo(req)
+		require.Equal(t, http.StatusUnauthorized, status)
+
+		_, ok := sess.getToken(rm.ID())
+		require.False(t, ok)
+
+		// access found in cache
+		sess = NewMoraSession()
+		sess.setToken(rm.ID(), scm.Token{Token: "token", Expires: time.Now().Add(-time.Hour)})
+		sess.setReposCache(rm.ID(), map[int64]bool{repo.Id: true})
+		req = req.WithContext(WithMoraSession(req.Context(), sess))
+
+		status, _ = callInjectRepo(req)
+		require.Equal(t, http.StatusUnauthorized, status)
+	})
+
 	t.Run("invalid path", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/abc", nil)
 		sess := NewMoraSessionWithTokenFor(rm)
@@ -312,7 +355,8 @@ func TestServerRepoList(t *testing.T) {
 		RepositoryManager: 1215,
 		Namespace:         "owner",
 		Name:              "repo",
-		Url:               "https://scm.com/owner/repo"}
+		Url:               "https://scm.com/owner/repo",
+		Visibility:        "public"}
 
 	rm := NewMockRepositoryManager(1215)
 	rm.loginHandler = MockLoginMiddleware{"/login"}.Handler
@@ -377,13 +421,15 @@ func TestServerRepoList2(t *testing.T) {
 		RepositoryManager: 1,
 		Namespace:         "owner",
 		Name:              "repo0",
-		Url:               "https://scm.com/owner/repo0"}
+		Url:               "https://scm.com/owner/repo0",
+		Visibility:        "public"}
 
 	repo1 := Repository{
 		RepositoryManager: 1,
 		Namespace:         "owner",
 		Name:              "repo1",
-		Url:               "https://scm.com/owner/repo1"}
+		Url:               "https://scm.com/owner/repo1",
+		Visibility:        "public"}
 
 	rm := NewMockRepositoryManager(1)
 	rm.loginHandler = MockLoginMiddleware{"/login"}.Handler
@@ -478,3 +524,158 @@ func Test_NewMoraServerFromConfig_Gitea(t *testing.T) {
 	assert.Equal(t, int64(1), got.ID())
 	assert.Equal(t, config.RepositoryManagers[0].URL, got.URL().String())
 }
+
+func Test_NewMoraServerFromConfig_Gitlab(t *testing.T) {
+	tmp, err := os.CreateTemp("", "gitlab.conf")
+	require.NoError(t, err)
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write([]byte("ClientID = \"id\"\nClientSecret = \"secret\""))
+	require.NoError(t, err)
+
+	config := MoraConfig{}
+	config.Server.URL = "http://localhost:4000"
+	config.RepositoryManagers = []RepositoryManagerConfig{
+		{
+			Driver:         "gitlab",
+			SecretFilename: tmp.Name(),
+		},
+		{
+			Driver:         "gitlab",
+			URL:            "https://gitlab.example.com",
+			SecretFilename: tmp.Name(),
+		},
+	}
+
+	server, err := NewMoraServerFromConfig(config)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(server.repositoryManagers))
+
+	got := server.repositoryManagers[0]
+	assert.Equal(t, int64(1), got.ID())
+	assert.Equal(t, "https://gitlab.com/", got.URL().String())
+
+	got = server.repositoryManagers[1]
+	assert.Equal(t, int64(2), got.ID())
+	assert.Equal(t, "https://gitlab.example.com/", got.URL().String())
+}
+
+func Test_NewMoraServerFromConfig_Bitbucket(t *testing.T) {
+	tmp, err := os.CreateTemp("", "bitbucket.conf")
+	require.NoError(t, err)
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write([]byte("ClientID = \"id\"\nClientSecret = \"secret\""))
+	require.NoError(t, err)
+
+	config := MoraConfig{}
+	config.Server.URL = "http://localhost:4000"
+	config.RepositoryManagers = []RepositoryManagerConfig{
+		{
+			Driver:         "bitbucket",
+			SecretFilename: tmp.Name(),
+		},
+	}
+
+	server, err := NewMoraServerFromConfig(config)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(server.repositoryManagers))
+
+	got := server.repositoryManagers[0]
+	assert.Equal(t, int64(1), got.ID())
+	assert.Equal(t, "https://bitbucket.org", got.URL().String())
+}
+
+func Test_NewMoraServerFromConfig_Stash(t *testing.T) {
+	config := MoraConfig{}
+	config.Server.URL = "http://localhost:4000"
+	config.RepositoryManagers = []RepositoryManagerConfig{
+		{
+			Driver:         "stash",
+			URL:            "https://bitbucket.example.com/",
+			SecretFilename: writeStashSecret(t),
+		},
+	}
+
+	server, err := NewMoraServerFromConfig(config)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(server.repositoryManagers))
+
+	got := server.repositoryManagers[0]
+	assert.Equal(t, int64(1), got.ID())
+	assert.Equal(t, config.RepositoryManagers[0].URL, got.URL().String())
+
+	config.RepositoryManagers[0].URL = ""
+	_, err = NewMoraServerFromConfig(config)
+	assert.Error(t, err)
+}
+
+func Test_NewMoraServerFromConfig_Local(t *testing.T) {
+	dir, _ := setupLocalRepositories(t)
+
+	config := MoraConfig{}
+	config.RepositoryManagers = []RepositoryManagerConfig{
+		{
+			Driver:         "local",
+			URL:            "file://" + dir,
+			SecretFilename: writeLocalUsers(t),
+		},
+	}
+
+	server, err := NewMoraServerFromConfig(config)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(server.repositoryManagers))
+
+	got := server.repositoryManagers[0]
+	assert.Equal(t, int64(1), got.ID())
+	assert.Equal(t, "file://"+dir, got.URL().String())
+	assert.Equal(t, "", got.RevisionURL("file://"+dir+"/group/repo.git", "0123abc"))
+}
+
+func Test_RetentionConfig(t *testing.T) {
+	config := RetentionConfig{KeepDays: 30}
+	policy, err := config.Policy()
+	require.NoError(t, err)
+	assert.Equal(t, coverage.RetentionPolicy{KeepDays: 30, Thin: coverage.ThinDaily}, policy)
+
+	interval, err := config.PruneInterval()
+	require.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, interval)
+
+	config = RetentionConfig{KeepDays: 30, Thin: "monthly"}
+	_, err = config.Policy()
+	require.Error(t, err)
+
+	config = RetentionConfig{Interval: "1h"}
+	interval, err = config.PruneInterval()
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, interval)
+
+	for _, v := range []string{"0s", "-1h"} {
+		_, err = RetentionConfig{Interval: v}.PruneInterval()
+		assert.Error(t, err, v)
+	}
+}
+
+func Test_MirrorConfig(t *testing.T) {
+	interval, err := MirrorConfig{}.FetchInterval()
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, interval)
+
+	interval, err = MirrorConfig{Interval: "0"}.FetchInterval()
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), interval)
+}
+
+func Test_RepositorySyncConfig(t *testing.T) {
+	interval, err := RepositorySyncConfig{}.SyncInterval()
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, interval)
+
+	interval, err = RepositorySyncConfig{Interval: "0"}.SyncInterval()
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), interval)
+
+	_, err = RepositorySyncConfig{Interval: "abc"}.SyncInterval()
+	assert.Error(t, err)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/drone/go-scm/scm"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/rs/zerolog/log"
+)
+
+var errorServiceTokenNotConfigured = errors.New("service token is not configured")
+
+// serviceTokenSource is implemented by repository managers which can have
+// credentials for server-initiated calls, i.e. calls without users'
+// tokens such as requests with the API key, mirroring and repository sync.
+type serviceTokenSource interface {
+	ServiceToken(ctx context.Context, repo string) (*scm.Token, error)
+}
+
+// withServiceToken returns ctx with a token for server-initiated calls on
+// repo. ctx is returned as it is when rm has no credentials, i.e. calls are
+// anonymous.
+func withServiceToken(ctx context.Context, rm RepositoryManager, repo Repository) (context.Context, error) {
+	source, ok := rm.(serviceTokenSource)
+	if !ok {
+		return ctx, nil
+	}
+
+	token, err := source.ServiceToken(ctx, repo.Namespace+"/"+repo.Name)
+	if err == errorServiceTokenNotConfigured {
+		return ctx, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return scm.WithContext(ctx, token), nil
+}
+
+// serviceMirrorAuth returns an auth method of a service token of a
+// repository manager of repo for mirroring.
+func (s *MoraServer) serviceMirrorAuth(ctx context.Context, repo base.Repository) transport.AuthMethod {
+	rm := s.findRepositoryManager(repo.RepositoryManager)
+	if rm == nil {
+		return nil
+	}
+
+	ctx, err := withServiceToken(ctx, rm, repo)
+	if err != nil {
+		log.Warn().Err(err).Msgf("serviceMirrorAuth: repo.Id=%d", repo.Id)
+		return nil
+	}
+
+	return mirrorAuth(ctx, rm)
+}
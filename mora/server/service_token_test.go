@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/drone/go-scm/scm"
+	"github.com/go-chi/chi/v5"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tokenFrom(ctx context.Context) string {
+	token, ok := ctx.Value(scm.TokenKey{}).(*scm.Token)
+	if !ok || token == nil {
+		return ""
+	}
+	return token.Token
+}
+
+func newServiceTokenGithub(t *testing.T, token string) *Github {
+	filename := filepath.Join(t.TempDir(), "github.conf")
+	secret := fmt.Sprintf("ClientID = \"id\"\nClientSecret = \"secret\"\nServiceToken = %q", token)
+	require.NoError(t, os.WriteFile(filename, []byte(secret), 0o600))
+
+	rm, err := NewGithubFromFile(1, "https://github.com", filename)
+	require.NoError(t, err)
+	return rm
+}
+
+func Test_withServiceToken(t *testing.T) {
+	repo := Repository{RepositoryManager: 1, Namespace: "owner", Name: "repo"}
+
+	ctx, err := withServiceToken(context.Background(), newServiceTokenGithub(t, "bot"), repo)
+	require.NoError(t, err)
+	assert.Equal(t, "bot", tokenFrom(ctx))
+
+	// not configured
+	ctx, err = withServiceToken(context.Background(), newServiceTokenGithub(t, ""), repo)
+	require.NoError(t, err)
+	assert.Equal(t, "", tokenFrom(ctx))
+
+	// not supported
+	ctx, err = withServiceToken(context.Background(), NewMockRepositoryManager(1), repo)
+	require.NoError(t, err)
+	assert.Equal(t, "", tokenFrom(ctx))
+}
+
+func Test_injectRepo_ServiceToken(t *testing.T) {
+	rm := newServiceTokenGithub(t, "bot")
+	repo := Repository{RepositoryManager: 1, Namespace: "owner", Name: "repo", Url: "https://github.com/owner/repo"}
+
+	server := NewMoraServerBuilder(t).WithRepositoryManager(rm).WithRepo(&repo).
+		WithAPIKey("key").Finish()
+
+	var token string
+	r := chi.NewRouter()
+	r.Route("/{repo_id}", func(r chi.Router) {
+		r.Use(server.injectRepo)
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			token = tokenFrom(r.Context())
+		})
+	})
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/%d", repo.Id), nil)
+	req = req.WithContext(WithMoraSession(req.Context(), NewMoraSession()))
+	req.Header.Set("Authorization", "Bearer key")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "bot", token)
+}
+
+func TestServer_serviceMirrorAuth(t *testing.T) {
+	repo := Repository{RepositoryManager: 1, Namespace: "owner", Name: "repo"}
+
+	server := NewMoraServerBuilder(t).WithRepositoryManager(newServiceTokenGithub(t, "bot")).Finish()
+	auth := server.serviceMirrorAuth(context.Background(), repo)
+	assert.Equal(t, &githttp.BasicAuth{Username: "x-access-token", Password: "bot"}, auth)
+
+	server = NewMoraServerBuilder(t).WithRepositoryManager(newServiceTokenGithub(t, "")).Finish()
+	assert.Nil(t, server.serviceMirrorAuth(context.Background(), repo))
+
+	repo.RepositoryManager = 2
+	assert.Nil(t, server.serviceMirrorAuth(context.Background(), repo))
+}
@@ -21,6 +21,7 @@ const (
 )
 
 type MoraSession struct {
+	lock        sync.Mutex               // guards reposMap and tokenMap
 	reposMap    map[int64]map[int64]bool // [rmID][repoID]
 	tokenMap    map[int64]scm.Token      // [rmID]
 	timestamp   time.Time
@@ -36,34 +37,115 @@ func NewMoraSession() *MoraSession {
 	}
 }
 
+// getReposCache returns a copy of repositories which a user can access.
 func (s *MoraSession) getReposCache(rmID int64) map[int64]bool {
-	return s.reposMap[rmID]
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	cache, ok := s.reposMap[rmID]
+	if !ok {
+		return nil
+	}
+
+	copied := map[int64]bool{}
+	for repoID, v := range cache {
+		copied[repoID] = v
+	}
+	return copied
 }
 
 func (s *MoraSession) setReposCache(rumID int64, repos map[int64]bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	s.reposMap[rumID] = repos
 }
 
+// hasReposCache returns true if a user can access a repository.
+func (s *MoraSession) hasReposCache(rmID int64, repoID int64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.reposMap[rmID][repoID]
+}
+
+// addReposCache adds a repository which a user can access.
+func (s *MoraSession) addReposCache(rmID int64, repoID int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	cache, ok := s.reposMap[rmID]
+	if !ok {
+		cache = map[int64]bool{}
+		s.reposMap[rmID] = cache
+	}
+	cache[repoID] = true
+}
+
 func (s *MoraSession) getToken(rmID int64) (scm.Token, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	token, ok := s.tokenMap[rmID]
 	return token, ok
 }
 
 func (s *MoraSession) setToken(rmID int64, token scm.Token) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	s.tokenMap[rmID] = token
 }
 
-func (s *MoraSession) Remove(rmID int64) {
+// remove removes a token and cache of rm. s.lock has to be held.
+func (s *MoraSession) remove(rmID int64) {
 	delete(s.tokenMap, rmID)
 	delete(s.reposMap, rmID)
 }
 
-func (s *MoraSession) WithToken(ctx context.Context, rmID int64) (context.Context, error) {
-	token, ok := s.getToken(rmID)
+func (s *MoraSession) Remove(rmID int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.remove(rmID)
+}
+
+// tokenRefresher is implemented by repository managers which can refresh
+// expired tokens.
+type tokenRefresher interface {
+	RefreshToken(token scm.Token) (scm.Token, error)
+}
+
+// WithToken returns ctx with a token for rm. An expired token is refreshed
+// and written back to the session. When a token can not be refreshed, it is
+// removed from the session and errorTokenExpired is returned, i.e. a user has
+// to log in again. The session is locked during a refresh so that concurrent
+// requests of the session refresh a token only once.
+func (s *MoraSession) WithToken(ctx context.Context, rm RepositoryManager) (context.Context, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	token, ok := s.tokenMap[rm.ID()]
 	if !ok {
 		return nil, errorTokenNotFound
 	}
 
+	if refresher, ok := rm.(tokenRefresher); ok {
+		refreshed, err := refresher.RefreshToken(token)
+		if err != nil {
+			s.remove(rm.ID())
+			return nil, err
+		}
+		if refreshed != token {
+			log.Print("Refreshed token for RepositoryManager: id=", rm.ID())
+			token = refreshed
+			s.tokenMap[rm.ID()] = token
+		}
+	} else if tokenExpired(token, time.Now()) {
+		s.remove(rm.ID())
+		return nil, errorTokenExpired
+	}
+
 	return scm.WithContext(ctx, &token), nil
 }
 
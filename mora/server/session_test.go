@@ -1,11 +1,18 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/drone/go-login/login/gitlab"
+	"github.com/drone/go-scm/scm"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -20,3 +27,108 @@ func TestSessionManager(t *testing.T) {
 	got := httptest.NewRecorder()
 	handler.ServeHTTP(got, req)
 }
+
+// newRefreshingGitlab returns GitLab whose refresh token "refresh0" can be
+// used only once.
+func newRefreshingGitlab(t *testing.T) *Gitlab {
+	var lock sync.Mutex
+	used := false
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth/token" || r.FormValue("grant_type") != "refresh_token" {
+			http.NotFound(w, r)
+			return
+		}
+
+		lock.Lock()
+		reused := used
+		used = true
+		lock.Unlock()
+
+		if r.FormValue("refresh_token") != "refresh0" || reused {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "invalid_grant", "error_description": "invalid refresh token"}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token": "token1", "refresh_token": "refresh1", "expires_in": 7200}`)
+	}))
+	t.Cleanup(ts.Close)
+
+	rm, err := NewGitlab(1, ts.URL, gitlab.Config{ClientID: "id", ClientSecret: "secret"})
+	require.NoError(t, err)
+	return rm
+}
+
+func TestMoraSession_WithToken(t *testing.T) {
+	rm := newRefreshingGitlab(t)
+	sess := NewMoraSession()
+
+	// not expired
+	token := scm.Token{Token: "token0", Refresh: "refresh0", Expires: time.Now().Add(time.Hour)}
+	sess.setToken(rm.ID(), token)
+	ctx, err := sess.WithToken(context.Background(), rm)
+	require.NoError(t, err)
+	assert.Equal(t, "token0", tokenFrom(ctx))
+
+	// expired, and refreshed
+	token.Expires = time.Now().Add(-time.Hour)
+	sess.setToken(rm.ID(), token)
+	ctx, err = sess.WithToken(context.Background(), rm)
+	require.NoError(t, err)
+	assert.Equal(t, "token1", tokenFrom(ctx))
+
+	stored, ok := sess.getToken(rm.ID())
+	require.True(t, ok)
+	assert.Equal(t, "token1", stored.Token)
+	assert.Equal(t, "refresh1", stored.Refresh)
+	assert.True(t, stored.Expires.After(time.Now().Add(time.Hour)))
+
+	// expired, and refresh is rejected
+	token.Refresh = "revoked"
+	sess.setToken(rm.ID(), token)
+	_, err = sess.WithToken(context.Background(), rm)
+	require.ErrorIs(t, err, errorTokenExpired)
+
+	_, ok = sess.getToken(rm.ID())
+	require.False(t, ok)
+
+	// not found
+	_, err = sess.WithToken(context.Background(), rm)
+	require.Equal(t, errorTokenNotFound, err)
+}
+
+func TestMoraSession_WithToken_Concurrent(t *testing.T) {
+	rm := newRefreshingGitlab(t)
+	sess := NewMoraSession()
+	sess.setToken(rm.ID(),
+		scm.Token{Token: "token0", Refresh: "refresh0", Expires: time.Now().Add(-time.Hour)})
+
+	// refreshed only once
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = sess.WithToken(context.Background(), rm)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	stored, ok := sess.getToken(rm.ID())
+	require.True(t, ok)
+	assert.Equal(t, "token1", stored.Token)
+}
+
+func TestMoraSession_WithToken_NoRefresher(t *testing.T) {
+	rm := NewMockRepositoryManager(1)
+	sess := NewMoraSession()
+
+	sess.setToken(rm.ID(), scm.Token{Token: "token0", Expires: time.Now().Add(-time.Hour)})
+	_, err := sess.WithToken(context.Background(), rm)
+	require.ErrorIs(t, err, errorTokenExpired)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/render"
+	"github.com/rs/zerolog/log"
+)
+
+var errorNotRepoAdmin = errors.New("not an admin of the repository")
+
+type RepositorySettingsResponse struct {
+	Repo     Repository               `json:"repo"`
+	Settings *base.RepositorySettings `json:"settings"`
+}
+
+func (s *MoraServer) isAPIKeyRequest(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return s.apiKey != "" && s.apiKey == token
+}
+
+// checkRepoAdmin checks if a user of a request is an admin of the repository
+// injected by injectRepo. A request with the API key is treated as an admin.
+func (s *MoraServer) checkRepoAdmin(r *http.Request) error {
+	if s.isAPIKeyRequest(r) {
+		return nil
+	}
+
+	rm, _ := base.RepositoryClientFrom(r.Context())
+	repo, _ := base.RepoFrom(r.Context())
+
+	perm, _, err := rm.Client().Repositories.FindPerms(r.Context(), repo.Namespace+"/"+repo.Name)
+	if err != nil {
+		return err
+	}
+
+	if !perm.Admin {
+		return errorNotRepoAdmin
+	}
+
+	return nil
+}
+
+func (s *MoraServer) requireRepoAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := s.checkRepoAdmin(r)
+		if err != nil {
+			log.Warn().Err(err).Msg("requireRepoAdmin")
+			render.Forbidden(w, render.ErrForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *MoraServer) handleSettings(w http.ResponseWriter, r *http.Request) {
+	repo, _ := base.RepoFrom(r.Context())
+	settings, _ := base.SettingsFrom(r.Context())
+
+	render.JSON(w, RepositorySettingsResponse{Repo: repo, Settings: settings}, http.StatusOK)
+}
+
+func (s *MoraServer) handleSettingsUpdate(w http.ResponseWriter, r *http.Request) {
+	repo, _ := base.RepoFrom(r.Context())
+
+	settings := base.NewRepositorySettings()
+	if err := json.NewDecoder(r.Body).Decode(settings); err != nil {
+		render.BadRequest(w, err)
+		return
+	}
+
+	if err := settings.Validate(); err != nil {
+		render.BadRequest(w, err)
+		return
+	}
+
+	if err := s.settings.Put(repo.Id, settings); err != nil {
+		log.Err(err).Msg("handleSettingsUpdate")
+		render.InternalError(w, errors.New("internal error"))
+		return
+	}
+
+	render.JSON(w, RepositorySettingsResponse{Repo: repo, Settings: settings}, http.StatusOK)
+}
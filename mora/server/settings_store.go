@@ -0,0 +1,59 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/jmoiron/sqlx"
+)
+
+var schema_settings = `
+CREATE TABLE IF NOT EXISTS repository_settings (
+    repo_id INTEGER PRIMARY KEY,
+    settings TEXT NOT NULL
+)`
+
+type repositorySettingsStoreImpl struct {
+	db *sqlx.DB
+}
+
+func NewRepositorySettingsStore(db *sqlx.DB) RepositorySettingsStore {
+	return &repositorySettingsStoreImpl{db}
+}
+
+func (s *repositorySettingsStoreImpl) Init() error {
+	_, err := s.db.Exec(schema_settings)
+	return err
+}
+
+func (s *repositorySettingsStoreImpl) Find(repoID int64) (*base.RepositorySettings, error) {
+	var text string
+	err := s.db.Get(&text, "SELECT settings FROM repository_settings WHERE repo_id = $1", repoID)
+	if err == sql.ErrNoRows {
+		return base.NewRepositorySettings(), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	settings := base.NewRepositorySettings()
+	err = json.Unmarshal([]byte(text), settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+func (s *repositorySettingsStoreImpl) Put(repoID int64, settings *base.RepositorySettings) error {
+	text, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(
+		"INSERT INTO repository_settings (repo_id, settings) VALUES ($1, $2)"+
+			" ON CONFLICT(repo_id) DO UPDATE SET settings = excluded.settings",
+		repoID, string(text))
+	return err
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositorySettingsStore(t *testing.T) {
+	db, err := sqlx.Connect("sqlite3", ":memory:?_loc=auto")
+	require.NoError(t, err)
+
+	store := NewRepositorySettingsStore(db)
+	require.NoError(t, store.Init())
+
+	got, err := store.Find(1215)
+	require.NoError(t, err)
+	require.Equal(t, base.NewRepositorySettings(), got)
+
+	settings := base.NewRepositorySettings()
+	settings.DefaultBranch = "main"
+	settings.Components = []*base.Component{{Name: "payments", Paths: []string{"services/payments/**"}}}
+	require.NoError(t, store.Put(1215, settings))
+
+	got, err = store.Find(1215)
+	require.NoError(t, err)
+	require.Equal(t, settings, got)
+
+	settings.DefaultBranch = "develop"
+	require.NoError(t, store.Put(1215, settings))
+
+	got, err = store.Find(1215)
+	require.NoError(t, err)
+	require.Equal(t, "develop", got.DefaultBranch)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/drone/go-scm/scm"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/iszk1215/mora/mora/base"
+	"github.com/iszk1215/mora/mora/mockscm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Settings(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	repo := Repository{RepositoryManager: 1, Namespace: "owner", Name: "repo", Url: "https://scm.com/owner/repo"}
+	other := Repository{RepositoryManager: 1, Namespace: "owner", Name: "other", Url: "https://scm.com/owner/other"}
+
+	repos := mockscm.NewMockRepositoryService(controller)
+	repos.EXPECT().Find(gomock.Any(), gomock.Any()).Return(&scm.Repository{}, &scm.Response{}, nil).AnyTimes()
+	repos.EXPECT().FindPerms(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, name string) (*scm.Perm, *scm.Response, error) {
+			return &scm.Perm{Pull: true, Admin: name == "owner/repo"}, &scm.Response{}, nil
+		}).AnyTimes()
+
+	rm := NewMockRepositoryManager(1)
+	rm.loginHandler = MockLoginMiddleware{"/login"}.Handler
+	rm.client.Repositories = repos
+
+	server := NewMoraServerBuilder(t).WithRepositoryManager(rm).WithRepo(&repo, &other).
+		WithSessionManager().WithSettings().WithAPIKey("key").Finish()
+	handler := server.Handler()
+	cookie := requireLogin(t, handler, rm.ID())
+
+	serve := func(method string, repo Repository, v interface{}, apiKey string) *http.Response {
+		var body bytes.Buffer
+		if v != nil {
+			require.NoError(t, json.NewEncoder(&body).Encode(v))
+		}
+		path := fmt.Sprintf("/api/repos/%d/settings", repo.Id)
+		req := httptest.NewRequest(method, path, &body)
+		if apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		} else {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	settings := base.NewRepositorySettings()
+	settings.DefaultBranch = "main"
+	settings.Components = []*base.Component{{Name: "payments", Paths: []string{"services/payments/**"}}}
+
+	t.Run("update with api key", func(t *testing.T) {
+		res := serve(http.MethodPut, repo, settings, "key")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		got, err := server.settings.Find(repo.Id)
+		require.NoError(t, err)
+		assert.Equal(t, settings, got)
+	})
+
+	t.Run("invalid settings", func(t *testing.T) {
+		invalid := base.NewRepositorySettings()
+		invalid.Thresholds.Minimum = 200
+		res := serve(http.MethodPut, repo, invalid, "key")
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+
+	t.Run("get as admin", func(t *testing.T) {
+		res := serve(http.MethodGet, repo, nil, "")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		var got RepositorySettingsResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+		assert.Equal(t, settings, got.Settings)
+	})
+
+	t.Run("not admin", func(t *testing.T) {
+		res := serve(http.MethodGet, other, nil, "")
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+		res = serve(http.MethodPut, other, settings, "")
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+	})
+}
+
+func Test_injectRepo_Settings(t *testing.T) {
+	repo := Repository{RepositoryManager: 1, Namespace: "owner", Name: "repo", Url: "https://scm.com/owner/repo"}
+	server := NewMoraServerBuilder(t).WithRepositoryManager(NewMockRepositoryManager(1)).
+		WithRepo(&repo).WithSettings().WithAPIKey("key").Finish()
+
+	settings := base.NewRepositorySettings()
+	settings.DefaultBranch = "main"
+	require.NoError(t, server.settings.Put(repo.Id, settings))
+
+	var got *base.RepositorySettings
+	handler := server.injectRepo(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = base.SettingsFrom(r.Context())
+	}))
+
+	r := chi.NewRouter()
+	r.Handle("/{repo_id}", handler)
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/%d", repo.Id), nil)
+	req.Header.Set("Authorization", "Bearer key")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, settings, got)
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	login "github.com/drone/go-login/login/stash"
+	"github.com/drone/go-scm/scm"
+	driver "github.com/drone/go-scm/scm/driver/stash"
+	"github.com/drone/go-scm/scm/transport/oauth1"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// Stash is a repository manager for Bitbucket Server, which authorizes
+// users by OAuth 1.0a with an RSA key.
+type Stash struct {
+	BaseRepositoryManager
+	transport *stashTransport
+}
+
+// stashTransport signs requests with a token in a context by OAuth 1.0a. A
+// service token is sent as a bearer token instead because it is an HTTP
+// access token, which Bitbucket Server does not take as an OAuth token.
+type stashTransport struct {
+	oauth1       *oauth1.Transport
+	serviceToken string
+}
+
+func (t *stashTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	token, ok := r.Context().Value(scm.TokenKey{}).(*scm.Token)
+	if !ok || t.serviceToken == "" || token.Token != t.serviceToken {
+		return t.oauth1.RoundTrip(r)
+	}
+
+	r2 := r.Clone(r.Context())
+	r2.Header.Set("Authorization", "Bearer "+token.Token)
+	return http.DefaultTransport.RoundTrip(r2)
+}
+
+// RevisionURL returns a URL of a commit. baseURL is a URL of a repository
+// such as https://bitbucket.example.com/projects/PRJ/repos/repo/browse.
+func (s *Stash) RevisionURL(baseURL string, revision string) string {
+	return strings.TrimSuffix(baseURL, "/browse") + "/commits/" + revision
+}
+
+func (s *Stash) FindDescription(ctx context.Context, repo string) (string, error) {
+	namespace, name := scm.Split(repo)
+	return findDescription(ctx, s.client,
+		fmt.Sprintf("rest/api/1.0/projects/%s/repos/%s", namespace, name))
+}
+
+// GitAuth returns an auth method with a bearer token because Bitbucket Server
+// takes an HTTP access token with a name of its owner in basic auth.
+func (s *Stash) GitAuth(token *scm.Token) transport.AuthMethod {
+	return &githttp.TokenAuth{Token: token.Token}
+}
+
+// SetServiceToken sets an HTTP access token for server-initiated calls.
+func (s *Stash) SetServiceToken(token string) {
+	s.BaseRepositoryManager.SetServiceToken(token)
+	s.transport.serviceToken = token
+}
+
+func NewStash(id int64, url string, config login.Config) (*Stash, error) {
+	client, err := driver.New(url)
+	if err != nil {
+		return nil, err
+	}
+
+	stash := new(Stash)
+	stash.Init(id, client.BaseURL, client, &config)
+
+	stash.transport = &stashTransport{
+		oauth1: &oauth1.Transport{
+			ConsumerKey: config.ConsumerKey,
+			PrivateKey:  config.PrivateKey,
+			Source:      oauth1.ContextTokenSource(),
+		},
+	}
+	stash.client.Client = &http.Client{Transport: stash.transport}
+	return stash, nil
+}
+
+func NewStashFromFile(id int64, filename string, url string, redirect_url string) (*Stash, error) {
+	secret, err := readSecret(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret.PrivateKeyFile == "" {
+		return nil, errors.New("ConfigError: PrivateKeyFile is empty")
+	}
+
+	key, err := readPrivateKey(secret.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := login.Config{
+		Address:        url,
+		ConsumerKey:    secret.ClientID,
+		ConsumerSecret: secret.ClientSecret,
+		CallbackURL:    redirect_url,
+		PrivateKey:     key,
+	}
+
+	stash, err := NewStash(id, url, config)
+	if err != nil {
+		return nil, err
+	}
+
+	stash.SetServiceToken(secret.ServiceToken)
+	return stash, nil
+}
+
+// splitStashPath splits a path of a repository URL such as
+// projects/PRJ/repos/repo/browse into a project key and a repository slug.
+func splitStashPath(path string) (string, string, error) {
+	parts := strings.Split(strings.TrimSuffix(path, "/browse"), "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[2] != "repos" {
+		return "", "", fmt.Errorf("not a repository path of Bitbucket Server: %s", path)
+	}
+	return parts[1], parts[3], nil
+}
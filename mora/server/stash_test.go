@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/drone/go-scm/scm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeStashSecret writes a secret file with a private key, and returns its
+// filename.
+func writeStashSecret(t *testing.T) string {
+	dir := t.TempDir()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	keyFile := filepath.Join(dir, "stash.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, os.WriteFile(keyFile, b, 0o600))
+
+	secretFile := filepath.Join(dir, "stash.conf")
+	secret := fmt.Sprintf("ClientID = \"mora\"\nPrivateKeyFile = %q", keyFile)
+	require.NoError(t, os.WriteFile(secretFile, []byte(secret), 0o600))
+
+	return secretFile
+}
+
+func TestStash_Repositories(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "OAuth ") ||
+			!strings.Contains(auth, `oauth_consumer_key="mora"`) ||
+			!strings.Contains(auth, `oauth_token="token0"`) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/rest/api/1.0/projects/PRJ/repos/repo":
+		case "/rest/api/1.0/projects/PRJ/repos/repo/branches/default":
+			fmt.Fprint(w, `{"displayId": "develop"}`)
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		fmt.Fprintf(w, `{
+			"id": 1215,
+			"slug": "repo",
+			"project": {"key": "PRJ"},
+			"description": "a repository",
+			"links": {"self": [{"href": "%s/projects/PRJ/repos/repo/browse"}]}
+		}`, "https://bitbucket.example.com")
+	}))
+	defer ts.Close()
+
+	rm, err := NewStashFromFile(1, writeStashSecret(t), ts.URL, "http://mora.example.com/login")
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), scm.TokenKey{},
+		&scm.Token{Token: "token0", Refresh: "secret0"})
+
+	found, _, err := rm.Client().Repositories.Find(ctx, "PRJ/repo")
+	require.NoError(t, err)
+	assert.Equal(t, "1215", found.ID)
+	assert.Equal(t, "PRJ", found.Namespace)
+	assert.Equal(t, "repo", found.Name)
+	assert.Equal(t, "https://bitbucket.example.com/projects/PRJ/repos/repo/browse", found.Link)
+	assert.Equal(t, "develop", found.Branch)
+
+	description, err := rm.FindDescription(ctx, "PRJ/repo")
+	require.NoError(t, err)
+	assert.Equal(t, "a repository", description)
+
+	assert.Equal(t,
+		"https://bitbucket.example.com/projects/PRJ/repos/repo/commits/0123abc",
+		rm.RevisionURL(found.Link, "0123abc"))
+}
+
+func TestStash_ServiceToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer service0" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/rest/api/1.0/projects/PRJ/repos/repo":
+			fmt.Fprint(w, `{"id": 1215, "slug": "repo", "project": {"key": "PRJ"}}`)
+		case "/rest/api/1.0/projects/PRJ/repos/repo/branches/default":
+			fmt.Fprint(w, `{"displayId": "main"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	secretFile := writeStashSecret(t)
+	f, err := os.OpenFile(secretFile, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = fmt.Fprint(f, "\nServiceToken = \"service0\"")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	rm, err := NewStashFromFile(1, secretFile, ts.URL, "http://mora.example.com/login")
+	require.NoError(t, err)
+
+	ctx, err := withServiceToken(context.Background(), rm, Repository{Namespace: "PRJ", Name: "repo"})
+	require.NoError(t, err)
+
+	found, _, err := rm.Client().Repositories.Find(ctx, "PRJ/repo")
+	require.NoError(t, err)
+	assert.Equal(t, "1215", found.ID)
+}
+
+func TestNewStashFromFile_NoPrivateKey(t *testing.T) {
+	dir := t.TempDir()
+
+	secretFile := filepath.Join(dir, "stash.conf")
+	require.NoError(t, os.WriteFile(secretFile, []byte("ClientID = \"mora\""), 0o600))
+	_, err := NewStashFromFile(1, secretFile, "https://bitbucket.example.com", "")
+	assert.Error(t, err)
+
+	keyFile := filepath.Join(dir, "stash.pem")
+	require.NoError(t, os.WriteFile(keyFile, []byte("not a key"), 0o600))
+	secret := fmt.Sprintf("ClientID = \"mora\"\nPrivateKeyFile = %q", keyFile)
+	require.NoError(t, os.WriteFile(secretFile, []byte(secret), 0o600))
+	_, err = NewStashFromFile(1, secretFile, "https://bitbucket.example.com", "")
+	assert.Error(t, err)
+}
+
+func Test_splitStashPath(t *testing.T) {
+	namespace, name, err := splitStashPath("projects/PRJ/repos/repo/browse")
+	require.NoError(t, err)
+	assert.Equal(t, "PRJ", namespace)
+	assert.Equal(t, "repo", name)
+
+	namespace, name, err = splitStashPath("projects/PRJ/repos/repo")
+	require.NoError(t, err)
+	assert.Equal(t, "PRJ", namespace)
+	assert.Equal(t, "repo", name)
+
+	_, _, err = splitStashPath("PRJ/repo")
+	assert.Error(t, err)
+}
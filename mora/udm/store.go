@@ -246,3 +246,24 @@ func (s *udmStore) initialize() error {
 
 	return err
 }
+
+// DeleteRepository removes all metrics, items and values of a repository in
+// tx.
+func DeleteRepository(tx *sqlx.Tx, repoID int64) error {
+	_, err := tx.Exec(`DELETE FROM udm_value WHERE item_id IN
+(SELECT i.id FROM udm_item i JOIN udm_metric m ON i.metric_id = m.id WHERE m.repo_id = $1)`,
+		repoID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"DELETE FROM udm_item WHERE metric_id IN (SELECT id FROM udm_metric WHERE repo_id = $1)",
+		repoID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM udm_metric WHERE repo_id = $1", repoID)
+	return err
+}